@@ -0,0 +1,497 @@
+// Command navserve serves path queries over a loaded Env as JSON on
+// localhost, so the HTML tools can call the Go planners instead of their
+// JavaScript copies.
+//
+//	navserve -scene web_world_data/转角楼梯.json
+//	navserve -map world.znav -agent-h 1.1 -step-up 1.1 -step-down 3
+//
+// Coordinates follow the web_world_data scenes: x/z are sub-cells (0.25m),
+// y is in 1/20m; LP cells are addressed as mx/mz.
+//
+//	GET  /api/info
+//	POST /api/path      {"start":{x,z,y},"goal":{x,z,y},"filter":{...},"smooth":true,"jps":true}
+//	GET  /api/interval  ?x=&z=&y= plus optional filter fields
+//	GET  /api/column    ?mx=&mz=
+//	POST /api/edit      {"add":[{x,z,y0,y1}],"remove":[...],"sub":true,"texture":4,"config":0}
+//
+// Browser requests are only served for localhost pages and the origins given
+// with -cors-origin; pass -cors-origin null for the HTML tools opened from
+// disk. Path searches stop after -max-expansions nodes.
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	zmap3base "pathfinding/new_map"
+	"pathfinding/new_map/navgation"
+)
+
+func main() {
+	addr := flag.String("addr", "127.0.0.1:8787", "listen address")
+	scenePath := flag.String("scene", "", "web_world_data scene to load")
+	mapPath := flag.String("map", "", "binary map file to load (see navgation.WriteMap)")
+	origins := flag.String("cors-origin", "", "comma-separated extra origins allowed besides localhost (\"null\" for pages opened from disk)")
+	maxExp := flag.Int("max-expansions", 2000000, "node expansion limit per path search, 0 for none")
+	fp := filterFlags{}
+	fp.register(flag.CommandLine)
+	flag.Parse()
+
+	srv, err := load(*scenePath, *mapPath, fp)
+	if err != nil {
+		log.Fatal(err)
+	}
+	srv.maxExpansions = *maxExp
+	srv.origins = make(map[string]bool)
+	for _, o := range strings.Split(*origins, ",") {
+		if o = strings.TrimSpace(o); o != "" {
+			srv.origins[o] = true
+		}
+	}
+	log.Printf("navserve: %v on http://%s", srv.env.Rect(), *addr)
+	log.Fatal(http.ListenAndServe(*addr, srv.handler()))
+}
+
+func load(scenePath, mapPath string, fp filterFlags) (*server, error) {
+	switch {
+	case scenePath != "" && mapPath != "":
+		return nil, errors.New("use either -scene or -map")
+	case scenePath != "":
+		f, err := os.Open(scenePath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		sc, err := navgation.LoadScene(f)
+		if err != nil {
+			return nil, err
+		}
+		return &server{env: sc.Env, filter: sc.Filter}, nil
+	case mapPath != "":
+		f, err := os.Open(mapPath)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		env, err := navgation.ReadMap(f)
+		if err != nil {
+			return nil, err
+		}
+		return &server{env: env, filter: fp.filter()}, nil
+	}
+	return nil, errors.New("no map: pass -scene or -map")
+}
+
+// filterFlags are the agent settings in the visualizer units.
+type filterFlags struct {
+	AgentH    float64 `json:"agentH"`
+	StepUp    float64 `json:"stepUp"`
+	StepDown  float64 `json:"stepDown"`
+	BanTex    uint32  `json:"banTex"`
+	IgnoreTex uint32  `json:"ignoreTex"`
+}
+
+func (fp *filterFlags) register(fs *flag.FlagSet) {
+	fs.Float64Var(&fp.AgentH, "agent-h", 1.1, "agent height, meters")
+	fs.Float64Var(&fp.StepUp, "step-up", 1.1, "step up limit, meters")
+	fs.Float64Var(&fp.StepDown, "step-down", 3, "step down limit, meters")
+	fs.Func("ban-tex", "forbidden texture mask", func(s string) error { return parseMask(s, &fp.BanTex) })
+	fs.Func("ignore-tex", "ignored texture mask", func(s string) error { return parseMask(s, &fp.IgnoreTex) })
+}
+
+func (fp filterFlags) filter() navgation.Filter {
+	return navgation.NewFilter(fp.IgnoreTex, fp.BanTex, meters(fp.AgentH), meters(fp.StepUp), meters(fp.StepDown))
+}
+
+// flagsOf returns the settings of f in the visualizer units.
+func flagsOf(f navgation.Filter) filterFlags {
+	return filterFlags{
+		AgentH:    float64(f.Height()) / zmap3base.HeightScale,
+		StepUp:    float64(f.UpLimit()) / zmap3base.HeightScale,
+		StepDown:  float64(f.DownLimit()) / zmap3base.HeightScale,
+		BanTex:    f.ForbiddenTexture(),
+		IgnoreTex: f.IgnoreTexture(),
+	}
+}
+
+// queryFilter returns the server filter with the settings present in q
+// overridden, or an error for a malformed one.
+func (s *server) queryFilter(q url.Values) (navgation.Filter, error) {
+	names := []string{"agentH", "stepUp", "stepDown", "banTex", "ignoreTex"}
+	if !slices.ContainsFunc(names, q.Has) {
+		return s.filter, nil
+	}
+	fp := flagsOf(s.filter)
+	for _, v := range []struct {
+		name string
+		dst  *float64
+	}{{"agentH", &fp.AgentH}, {"stepUp", &fp.StepUp}, {"stepDown", &fp.StepDown}} {
+		if !q.Has(v.name) {
+			continue
+		}
+		var err error
+		if *v.dst, err = strconv.ParseFloat(q.Get(v.name), 64); err != nil {
+			return s.filter, fmt.Errorf("%s: %w", v.name, err)
+		}
+	}
+	for _, v := range []struct {
+		name string
+		dst  *uint32
+	}{{"banTex", &fp.BanTex}, {"ignoreTex", &fp.IgnoreTex}} {
+		if !q.Has(v.name) {
+			continue
+		}
+		if err := parseMask(q.Get(v.name), v.dst); err != nil {
+			return s.filter, fmt.Errorf("%s: %w", v.name, err)
+		}
+	}
+	return fp.filter(), nil
+}
+
+func parseMask(s string, dst *uint32) error {
+	v, err := strconv.ParseUint(s, 0, 32)
+	*dst = uint32(v)
+	return err
+}
+
+func meters(m float64) int32 {
+	return int32(m*zmap3base.HeightScale + 0.5)
+}
+
+// server guards the Env: queries share it, edits take it exclusively.
+type server struct {
+	mu     sync.RWMutex
+	env    *zmap3base.Env
+	filter navgation.Filter
+
+	origins       map[string]bool // allowed besides localhost
+	maxExpansions int             // per path search; 0 means unlimited
+}
+
+func (s *server) handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /api/info", s.info)
+	mux.HandleFunc("POST /api/path", s.path)
+	mux.HandleFunc("GET /api/interval", s.interval)
+	mux.HandleFunc("GET /api/column", s.column)
+	mux.HandleFunc("POST /api/edit", s.edit)
+	return s.cors(mux)
+}
+
+// cors lets the HTML tools on localhost (or the -cors-origin pages) call the
+// service. Requests from any other page are refused outright, since simple
+// cross-site POSTs reach the handlers without a preflight.
+func (s *server) cors(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if origin := r.Header.Get("Origin"); origin != "" {
+			if !s.allowOrigin(origin) {
+				writeError(w, http.StatusForbidden, fmt.Errorf("origin %q not allowed", origin))
+				return
+			}
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.Header().Add("Vary", "Origin")
+		}
+		if r.Method == http.MethodOptions {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+func (s *server) allowOrigin(origin string) bool {
+	if s.origins[origin] {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+		return false
+	}
+	switch u.Hostname() {
+	case "localhost", "127.0.0.1", "::1":
+		return true
+	}
+	return false
+}
+
+type point struct {
+	X int32 `json:"x"`
+	Z int32 `json:"z"`
+	Y int32 `json:"y"`
+}
+
+func (p point) point3d() (zmap3base.Point3d, error) {
+	if p.Y < 0 || p.Y > 0xFFFF {
+		return zmap3base.Point3d{}, fmt.Errorf("y %d out of range", p.Y)
+	}
+	pt, ok := navgation.SubCellPoint(p.X, p.Z, uint16(p.Y))
+	if !ok {
+		return pt, fmt.Errorf("sub-cell (%d,%d) out of range", p.X, p.Z)
+	}
+	return pt, nil
+}
+
+func pointOf(p zmap3base.Point3d) point {
+	qx, qy := navgation.SubCellOf(p.Point2d())
+	return point{X: qx, Z: qy, Y: int32(p.H)}
+}
+
+type rangeJSON struct {
+	Begin   uint16 `json:"begin"`
+	End     uint16 `json:"end"`
+	Texture uint32 `json:"texture"`
+	Config  uint32 `json:"config,omitempty"`
+}
+
+func rangesJSON(rrs []zmap3base.RichRange) []rangeJSON {
+	out := make([]rangeJSON, 0, len(rrs))
+	for _, rr := range rrs {
+		out = append(out, rangeJSON{rr.Begin, rr.End, uint32(rr.Accessory.Texture), rr.Accessory.Config})
+	}
+	return out
+}
+
+func (s *server) info(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	gw, gh := s.env.GridDims()
+	loaded := 0
+	for gy := 0; gy < gh; gy++ {
+		for gx := 0; gx < gw; gx++ {
+			if s.env.GridAt(gx, gy) != nil {
+				loaded++
+			}
+		}
+	}
+	rect := s.env.Rect()
+	writeJSON(w, http.StatusOK, map[string]any{
+		"rect":   [4]uint16{rect.Min.X, rect.Min.Y, rect.Max.X, rect.Max.Y},
+		"grids":  [3]int{gw, gh, loaded},
+		"filter": flagsOf(s.filter),
+	})
+}
+
+type pathRequest struct {
+	Start  point        `json:"start"`
+	Goal   point        `json:"goal"`
+	Filter *filterFlags `json:"filter"`
+	Smooth bool         `json:"smooth"`
+	JPS    bool         `json:"jps"`
+}
+
+type waypointJSON struct {
+	point
+	Link string `json:"link,omitempty"`
+	Mode string `json:"mode,omitempty"`
+}
+
+func (s *server) path(w http.ResponseWriter, r *http.Request) {
+	var req pathRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	start, err := req.Start.point3d()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	goal, err := req.Goal.point3d()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	f := s.filter
+	if req.Filter != nil {
+		f = req.Filter.filter()
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	pl := navgation.NewPlanner(s.env, f)
+	pl.JumpPoints = req.JPS
+	pl.MaxExpansions = s.maxExpansions
+	res, ok := pl.FindPath(start, goal)
+	path := res.Waypoints
+	if ok && req.Smooth {
+		path = navgation.SmoothPath(s.env, path, f)
+	}
+	out := map[string]any{"ok": ok, "expanded": res.Expanded}
+	if !ok && s.maxExpansions > 0 && res.Expanded >= s.maxExpansions {
+		out["limited"] = true
+	}
+	if ok {
+		wps := make([]waypointJSON, 0, len(path))
+		world := make([][3]float32, 0, len(path))
+		for _, wp := range path {
+			j := waypointJSON{point: pointOf(wp.Point3d)}
+			if wp.Link != navgation.LinkNone {
+				j.Link = wp.Link.String()
+			}
+			if wp.Mode != navgation.LocoWalk {
+				j.Mode = wp.Mode.String()
+			}
+			wps = append(wps, j)
+			world = append(world, wp.WorldFor(f))
+		}
+		out["cost"] = res.Cost
+		out["start"] = pointOf(res.Start)
+		out["goal"] = pointOf(res.Goal)
+		out["waypoints"] = wps
+		out["world"] = world
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *server) interval(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	var p point
+	var err error
+	for _, v := range []struct {
+		name string
+		dst  *int32
+	}{{"x", &p.X}, {"z", &p.Z}, {"y", &p.Y}} {
+		if *v.dst, err = queryInt(q.Get(v.name)); err != nil {
+			writeError(w, http.StatusBadRequest, fmt.Errorf("%s: %w", v.name, err))
+			return
+		}
+	}
+	pt, err := p.point3d()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	f, err := s.queryFilter(q)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	snap, ok := f.Interval(s.env, pt.Point2d(), p.Y)
+	out := map[string]any{"ok": ok}
+	if ok {
+		out["begin"], out["end"], out["texture"] = snap.Begin, snap.End, uint32(snap.Texture)
+	}
+	var gaps []rangeJSON
+	navgation.ForEachInterval(s.env, pt.Point2d(), f, func(g navgation.Gap) bool {
+		gaps = append(gaps, rangeJSON{g.Begin, g.End, uint32(g.Accessory.Texture), g.Accessory.Config})
+		return true
+	})
+	out["gaps"] = gaps
+	writeJSON(w, http.StatusOK, out)
+}
+
+func (s *server) column(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	mx, err1 := queryInt(q.Get("mx"))
+	mz, err2 := queryInt(q.Get("mz"))
+	if err := errors.Join(err1, err2); err != nil || mx < 0 || mz < 0 || mx > 0xFFFF || mz > 0xFFFF {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("bad cell mx=%q mz=%q", q.Get("mx"), q.Get("mz")))
+		return
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	c, ok := navgation.ReadCell(s.env, zmap3base.Point2d{X: uint16(mx), Y: uint16(mz)})
+	out := map[string]any{"ok": ok}
+	if ok {
+		out["terrain"] = rangesJSON([]zmap3base.RichRange{c.Terrain})[0]
+		out["lp"] = rangesJSON(c.LP)
+		if c.HP != nil {
+			hp := make([][]rangeJSON, len(c.HP))
+			for i, spans := range c.HP {
+				hp[i] = rangesJSON(spans)
+			}
+			out["hp"] = hp
+		}
+	}
+	writeJSON(w, http.StatusOK, out)
+}
+
+type editPoint struct {
+	X  int32  `json:"x"`
+	Z  int32  `json:"z"`
+	Y0 uint16 `json:"y0"`
+	Y1 uint16 `json:"y1"`
+}
+
+type editRequest struct {
+	Add     []editPoint `json:"add"`
+	Remove  []editPoint `json:"remove"`
+	Sub     bool        `json:"sub"` // x/z are sub-cells instead of LP cells
+	Texture uint32      `json:"texture"`
+	Config  uint32      `json:"config"`
+}
+
+func (req editRequest) points(eps []editPoint) ([]zmap3base.Point3d, error) {
+	out := make([]zmap3base.Point3d, 0, len(eps))
+	for _, e := range eps {
+		if e.Y1 <= e.Y0 {
+			return nil, fmt.Errorf("empty range [%d,%d)", e.Y0, e.Y1)
+		}
+		var p zmap3base.Point3d
+		if req.Sub {
+			var ok bool
+			if p, ok = navgation.SubCellPoint(e.X, e.Z, e.Y0); !ok {
+				return nil, fmt.Errorf("sub-cell (%d,%d) out of range", e.X, e.Z)
+			}
+		} else {
+			if e.X < 0 || e.Z < 0 || e.X > 0xFFFF || e.Z > 0xFFFF {
+				return nil, fmt.Errorf("cell (%d,%d) out of range", e.X, e.Z)
+			}
+			p = zmap3base.Point3d{X: uint16(e.X), Y: uint16(e.Z), H: e.Y0}
+		}
+		p.RangeEnd = e.Y1
+		out = append(out, p)
+	}
+	return out, nil
+}
+
+func (s *server) edit(w http.ResponseWriter, r *http.Request) {
+	var req editRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	add, err := req.points(req.Add)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	remove, err := req.points(req.Remove)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ok := s.env.ApplyRichOperationsExt(add, remove, zmap3base.Accessory{Texture: zmap3base.Texture(req.Texture), Config: req.Config})
+	writeJSON(w, http.StatusOK, map[string]any{"ok": ok})
+}
+
+func queryInt(s string) (int32, error) {
+	v, err := strconv.ParseInt(s, 10, 32)
+	return int32(v), err
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
@@ -0,0 +1,359 @@
+// Command navtool inspects maps and runs path queries from the shell.
+// FILE is a binary map (see navgation.WriteMap) or a web_world_data scene.
+//
+//	navtool info     [-v] FILE
+//	navtool column   FILE x z [xoff zoff]
+//	navtool interval [filter flags] -y H FILE x z [xoff zoff]
+//	navtool path     [filter flags] [-jps] [-smooth] [-trace OUT] FILE [sx sz sy gx gz gy]
+//	navtool convert  [filter flags] IN OUT
+//
+// column and interval take LP cells with optional 1..4 offsets, as Point2d;
+// path takes sub-cells (0.25m) and heights in 1/20m like the scenes, and
+// defaults to the scene markers; -trace writes the search events for the
+// visualizer's trace replay. Filter flags default to the scene settings.
+// convert writes a scene when OUT ends in .json and a map file otherwise.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"pathfinding/navtrace"
+	zmap3base "pathfinding/new_map"
+	"pathfinding/new_map/navgation"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	cmds := map[string]func(args []string) error{
+		"info":     runInfo,
+		"column":   runColumn,
+		"interval": runInterval,
+		"path":     runPath,
+		"convert":  runConvert,
+	}
+	run, ok := cmds[os.Args[1]]
+	if !ok {
+		usage()
+	}
+	if err := run(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "navtool:", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: navtool info|column|interval|path|convert [flags] FILE ...")
+	os.Exit(2)
+}
+
+// filterFlags are the agent settings in the visualizer units. Unset flags
+// keep the scene values.
+type filterFlags struct {
+	fs                       *flag.FlagSet
+	agentH, stepUp, stepDown float64
+	ban, ignore              uint
+	footprint                int
+}
+
+func newFilterFlags(fs *flag.FlagSet) *filterFlags {
+	ff := &filterFlags{fs: fs}
+	fs.Float64Var(&ff.agentH, "agent-h", 1.1, "agent height, meters")
+	fs.Float64Var(&ff.stepUp, "step-up", 1.1, "step up limit, meters")
+	fs.Float64Var(&ff.stepDown, "step-down", 3, "step down limit, meters")
+	fs.UintVar(&ff.ban, "ban-tex", 0, "forbidden texture mask")
+	fs.UintVar(&ff.ignore, "ignore-tex", 0, "ignored texture mask")
+	fs.IntVar(&ff.footprint, "footprint", 0, "footprint edge in sub-cells (0 = 2)")
+	return ff
+}
+
+func (ff *filterFlags) filter(sc *navgation.Scene, isScene bool) navgation.Filter {
+	set := map[string]bool{}
+	ff.fs.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	pick := func(name string, flagV float64, sceneV int32) int32 {
+		if isScene && !set[name] {
+			return sceneV
+		}
+		return int32(flagV*zmap3base.HeightScale + 0.5)
+	}
+	pickMask := func(name string, flagV uint, sceneV uint32) uint32 {
+		if isScene && !set[name] {
+			return sceneV
+		}
+		return uint32(flagV)
+	}
+	sf := sc.Filter
+	f := navgation.NewFilter(
+		pickMask("ignore-tex", ff.ignore, sf.IgnoreTexture()),
+		pickMask("ban-tex", ff.ban, sf.ForbiddenTexture()),
+		pick("agent-h", ff.agentH, sf.Height()),
+		pick("step-up", ff.stepUp, sf.UpLimit()),
+		pick("step-down", ff.stepDown, sf.DownLimit()),
+	)
+	if ff.footprint > 0 {
+		f = f.WithFootprint(int32(ff.footprint))
+	}
+	return f
+}
+
+func load(path string) (*navgation.Scene, bool, error) {
+	return navgation.LoadFile(path)
+}
+
+// point2d parses "x z [xoff zoff]".
+func point2d(args []string) (zmap3base.Point2d, error) {
+	if len(args) != 2 && len(args) != 4 {
+		return zmap3base.Point2d{}, fmt.Errorf("want x z [xoff zoff], got %q", args)
+	}
+	v := make([]uint64, len(args))
+	for i, a := range args {
+		n, err := strconv.ParseUint(a, 10, 16)
+		if err != nil {
+			return zmap3base.Point2d{}, err
+		}
+		v[i] = n
+	}
+	p := zmap3base.Point2d{X: uint16(v[0]), Y: uint16(v[1])}
+	if len(v) == 4 {
+		if v[2] < 1 || v[2] > zmap3base.SecondaryAccuracy || v[3] < 1 || v[3] > zmap3base.SecondaryAccuracy {
+			return p, fmt.Errorf("offsets must be 1..%d", zmap3base.SecondaryAccuracy)
+		}
+		p.XOffset, p.YOffset = uint8(v[2]), uint8(v[3])
+	}
+	return p, nil
+}
+
+func fmtRange(rr zmap3base.RichRange) string {
+	s := fmt.Sprintf("[%5d,%5d) tex=%#x", rr.Begin, rr.End, uint32(rr.Accessory.Texture))
+	if rr.Accessory.Config != 0 {
+		s += fmt.Sprintf(" cfg=%d", rr.Accessory.Config)
+	}
+	return s
+}
+
+func runInfo(args []string) error {
+	fs := flag.NewFlagSet("info", flag.ExitOnError)
+	verbose := fs.Bool("v", false, "print every grid")
+	fs.Parse(args)
+	if fs.NArg() != 1 {
+		return fmt.Errorf("info: want FILE")
+	}
+	sc, isScene, err := load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	env := sc.Env
+	r := env.Rect()
+	gw, gh := env.GridDims()
+	fmt.Printf("rect      [%d,%d)-[%d,%d)  %dx%d m\n", r.Min.X, r.Min.Y, r.Max.X, r.Max.Y, r.Width(), r.Height())
+	var total zmap3base.GridStats
+	loaded := 0
+	for gy := 0; gy < gh; gy++ {
+		for gx := 0; gx < gw; gx++ {
+			g := env.GridAt(gx, gy)
+			if g == nil {
+				continue
+			}
+			loaded++
+			s := g.Stats()
+			if *verbose {
+				fmt.Printf("grid %3d,%-3d base=(%d,%d) hp=%d dirty=%d segments=%d ranges=%d nodes=%d free=%d\n",
+					gx, gy, g.BaseX(), g.BaseY(), s.HPCells, s.DirtyCells, s.BaseSegments, s.BaseRanges, s.DirtyNodes, s.FreeNodes)
+			}
+			total.HPCells += s.HPCells
+			total.DirtyCells += s.DirtyCells
+			total.BaseSegments += s.BaseSegments
+			total.BaseRanges += s.BaseRanges
+			total.DirtyNodes += s.DirtyNodes
+			total.FreeNodes += s.FreeNodes
+		}
+	}
+	fmt.Printf("grids     %d x %d, %d loaded\n", gw, gh, loaded)
+	fmt.Printf("hp cells  %d\n", total.HPCells)
+	fmt.Printf("dirty     %d cells\n", total.DirtyCells)
+	fmt.Printf("BaseStore %d segments, %d ranges\n", total.BaseSegments, total.BaseRanges)
+	fmt.Printf("NodePool  %d nodes, %d free\n", total.DirtyNodes, total.FreeNodes)
+	if isScene {
+		f := sc.Filter
+		fmt.Printf("scene     agentH=%d stepUp=%d stepDown=%d ban=%#x ignore=%#x start=%v goal=%v\n",
+			f.Height(), f.UpLimit(), f.DownLimit(), f.ForbiddenTexture(), f.IgnoreTexture(), sc.Start, sc.Goal)
+	}
+	return nil
+}
+
+func runColumn(args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("column: want FILE x z [xoff zoff]")
+	}
+	sc, _, err := load(args[0])
+	if err != nil {
+		return err
+	}
+	p, err := point2d(args[1:])
+	if err != nil {
+		return err
+	}
+	terrain, spans, ok := navgation.ColumnSpans(sc.Env, p)
+	if !ok {
+		return fmt.Errorf("column %v: no data", p)
+	}
+	fmt.Printf("terrain %s\n", fmtRange(terrain))
+	for _, s := range spans {
+		fmt.Printf("span    %s\n", fmtRange(s))
+	}
+	if c, ok := navgation.ReadCell(sc.Env, p); ok && c.HP != nil && p.LowPrecision() {
+		fmt.Println("cell has high-precision data; pass xoff zoff for a sub-cell")
+	}
+	return nil
+}
+
+func runInterval(args []string) error {
+	fs := flag.NewFlagSet("interval", flag.ExitOnError)
+	ff := newFilterFlags(fs)
+	y := fs.Int("y", 0, "current height, 1/20m")
+	all := fs.Bool("all", false, "also list every standable gap")
+	fs.Parse(args)
+	if fs.NArg() < 3 {
+		return fmt.Errorf("interval: want FILE x z [xoff zoff]")
+	}
+	sc, isScene, err := load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	p, err := point2d(fs.Args()[1:])
+	if err != nil {
+		return err
+	}
+	f := ff.filter(sc, isScene)
+	if snap, ok := f.Interval(sc.Env, p, int32(*y)); ok {
+		fmt.Printf("interval [%d,%d) tex=%#x\n", snap.Begin, snap.End, uint32(snap.Texture))
+	} else {
+		fmt.Println("interval none")
+	}
+	if *all {
+		for _, g := range navgation.AllIntervals(sc.Env, p, f) {
+			fmt.Printf("gap      [%d,%d) tex=%#x cfg=%d\n", g.Begin, g.End, uint32(g.Accessory.Texture), g.Accessory.Config)
+		}
+	}
+	return nil
+}
+
+func runPath(args []string) error {
+	fs := flag.NewFlagSet("path", flag.ExitOnError)
+	ff := newFilterFlags(fs)
+	jps := fs.Bool("jps", false, "use jump point search")
+	smooth := fs.Bool("smooth", false, "smooth the result")
+	world := fs.Bool("world", false, "print world coordinates")
+	traceOut := fs.String("trace", "", "write the search trace to this file")
+	fs.Parse(args)
+	if fs.NArg() != 1 && fs.NArg() != 7 {
+		return fmt.Errorf("path: want FILE [sx sz sy gx gz gy]")
+	}
+	sc, isScene, err := load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	start, goal := sc.Start, sc.Goal
+	if fs.NArg() == 7 {
+		var v [6]int64
+		for i := range v {
+			if v[i], err = strconv.ParseInt(fs.Arg(i+1), 10, 32); err != nil {
+				return err
+			}
+		}
+		var ok1, ok2 bool
+		start, ok1 = navgation.SubCellPoint(int32(v[0]), int32(v[1]), uint16(v[2]))
+		goal, ok2 = navgation.SubCellPoint(int32(v[3]), int32(v[4]), uint16(v[5]))
+		if !ok1 || !ok2 {
+			return fmt.Errorf("path: endpoint out of range")
+		}
+	} else if !isScene {
+		return fmt.Errorf("path: map files need sx sz sy gx gz gy")
+	}
+	f := ff.filter(sc, isScene)
+	pl := navgation.NewPlanner(sc.Env, f)
+	pl.JumpPoints = *jps
+	var tr *navtrace.Trace
+	if *traceOut != "" {
+		tr = navgation.NewTrace()
+		pl.Tracer = tr
+	}
+	res, ok := pl.FindPath(start, goal)
+	if tr != nil {
+		if err := writeTrace(*traceOut, tr); err != nil {
+			return err
+		}
+		fmt.Printf("trace %d events -> %s\n", len(tr.Events), *traceOut)
+	}
+	if !ok {
+		fmt.Printf("no path (expanded %d)\n", res.Expanded)
+		return nil
+	}
+	path := res.Waypoints
+	if *smooth {
+		path = navgation.SmoothPath(sc.Env, path, f)
+	}
+	fmt.Printf("cost %.3f expanded %d waypoints %d\n", res.Cost, res.Expanded, len(path))
+	for _, w := range path {
+		qx, qy := navgation.SubCellOf(w.Point2d())
+		line := fmt.Sprintf("%5d %5d %5d", qx, qy, w.H)
+		if w.Link != navgation.LinkNone {
+			line += " link=" + w.Link.String()
+		}
+		if w.Mode != navgation.LocoWalk {
+			line += " mode=" + w.Mode.String()
+		}
+		if *world {
+			p := w.WorldFor(f)
+			line += fmt.Sprintf("  (%.2f, %.2f, %.2f)", p[0], p[1], p[2])
+		}
+		fmt.Println(line)
+	}
+	return nil
+}
+
+func writeTrace(path string, tr *navtrace.Trace) error {
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	_, err = tr.WriteTo(out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func runConvert(args []string) error {
+	fs := flag.NewFlagSet("convert", flag.ExitOnError)
+	ff := newFilterFlags(fs)
+	fs.Parse(args)
+	if fs.NArg() != 2 {
+		return fmt.Errorf("convert: want IN OUT")
+	}
+	sc, isScene, err := load(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	out, err := os.Create(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+	var w io.Writer = out
+	if strings.EqualFold(filepath.Ext(fs.Arg(1)), ".json") {
+		err = navgation.WriteScene(w, sc.Env, ff.filter(sc, isScene), sc.Start, sc.Goal)
+	} else {
+		err = navgation.WriteMap(w, sc.Env)
+	}
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,115 @@
+// ---------- cost.go ----------
+package main
+
+// StepInfo 描述一步宏格移动，供 CostModel 计价
+type StepInfo struct {
+	Dist       float32 // 水平长度（宏格）：1 或 √2
+	FromH, ToH uint16  // 移动前后的站立高度（1/20m）
+	Texture    Texture // 落脚面（站立高度所在区间）的材质编号
+	Config     uint32  // 落脚面区间的 Accessory.Config
+}
+
+// CostModel 为 Pathfinder 的每一步计价。
+// StepCost 不得低于 MinFactor()*Dist：启发式按 MinFactor 缩放，
+// 以保证比 1 更便宜的地面（道路）存在时 A* 仍然最优
+type CostModel interface {
+	StepCost(s StepInfo) float32
+	MinFactor() float32
+}
+
+// TextureCost 对落脚面材质命中 Mask 的步子按 Factor 缩放
+type TextureCost struct {
+	Mask   TextureMask
+	Factor float32
+}
+
+// TextureCostModel 按 Textures 中第一个命中项的倍率（都不命中时为 Base）乘以水平长度，
+// 再加上每 1/20m 的 Climb（上）/ Descent（下）代价和 ConfigCost[Config]。
+// 与 navgation.TextureCostModel 相同，只是材质按编号（TextureMask）匹配。所有值须 >= 0。
+//
+// 可视化器的 costM/costN 对应 Base = costM、Climb = Descent = costN/20（宏格 1m，高度 0.05m）
+type TextureCostModel struct {
+	Base           float32 // 0 表示 1
+	Textures       []TextureCost
+	Climb, Descent float32
+	ConfigCost     map[uint32]float32
+}
+
+func (m *TextureCostModel) base() float32 {
+	if m.Base == 0 {
+		return 1
+	}
+	return m.Base
+}
+
+func (m *TextureCostModel) StepCost(s StepInfo) float32 {
+	factor := m.base()
+	for _, tc := range m.Textures {
+		if tc.Mask.Has(s.Texture) {
+			factor = tc.Factor
+			break
+		}
+	}
+	c := factor * s.Dist
+	if s.ToH > s.FromH {
+		c += m.Climb * float32(s.ToH-s.FromH)
+	} else {
+		c += m.Descent * float32(s.FromH-s.ToH)
+	}
+	if len(m.ConfigCost) > 0 {
+		c += m.ConfigCost[s.Config]
+	}
+	return c
+}
+
+func (m *TextureCostModel) MinFactor() float32 {
+	min := m.base()
+	for _, tc := range m.Textures {
+		if tc.Factor < min {
+			min = tc.Factor
+		}
+	}
+	return min
+}
+
+// moveCost 为从高度 fromH 沿 d 走到宏格 (tx,tz) 站立高度 toH 的一步计价；
+// 未设置 Cost 时为 1 或 √2
+func (pf *Pathfinder) moveCost(d Dir, fromH uint16, tx, tz int32, toH uint16) float32 {
+	dist := float32(1)
+	if isDiag(d) {
+		dist = sqrt2
+	}
+	if pf.Cost == nil {
+		return dist
+	}
+	s := StepInfo{Dist: dist, FromH: fromH, ToH: toH}
+	if r, ok := pf.floorAt(tx, tz, toH); ok {
+		s.Texture, s.Config = r.Texture, r.Config
+	}
+	return pf.Cost.StepCost(s)
+}
+
+// floorAt 返回宏格 (tx,tz) 中心四子格里上表面为 h20 的第一个区间（站立高度取自它）
+func (pf *Pathfinder) floorAt(tx, tz int32, h20 uint16) (RichRange, bool) {
+	txq, tzq := (tx<<2)+1, (tz<<2)+1
+	for _, q := range [4][2]int32{{txq, tzq}, {txq, tzq + 1}, {txq + 1, tzq}, {txq + 1, tzq + 1}} {
+		colID, ok := pf.W.columnAtQuarter(q[0], q[1])
+		if !ok {
+			continue
+		}
+		for _, r := range pf.W.cols.Get(colID).raw {
+			if r.End == h20 {
+				return r, true
+			}
+		}
+	}
+	return RichRange{}, false
+}
+
+// hscale 是启发式的缩放系数
+func (pf *Pathfinder) hscale() float32 {
+	if pf.Cost == nil {
+		return 1
+	}
+	return pf.Cost.MinFactor()
+}
@@ -0,0 +1,61 @@
+package main
+
+import "testing"
+
+const texMud Texture = 3
+
+func buildCostWorld(w, h int32, col func(x, z int32) RichRange) *World {
+	wd := NewWorld()
+	for z := int32(0); z < h; z++ {
+		for x := int32(0); x < w; x++ {
+			wd.SetUniform(x, z, &RichRangeSetData{raw: []RichRange{col(x, z)}})
+		}
+	}
+	return wd
+}
+
+func pathLeavesRow(path [][3]float32, z float32) bool {
+	for _, p := range path {
+		if p[2] != z {
+			return true
+		}
+	}
+	return false
+}
+
+func TestPathfinder_CostModel(t *testing.T) {
+	// z=2 一行中段为泥地
+	mud := buildCostWorld(9, 5, func(x, z int32) RichRange {
+		r := RichRange{Range: Range{Begin: 0, End: 10}}
+		if z == 2 && x >= 2 && x <= 6 {
+			r.Texture = texMud
+		}
+		return r
+	})
+	pf := NewPathfinder(mud, AgentSpec{})
+	if path, ok := pf.FindPath(0, 2, 10, 8, 2); !ok || pathLeavesRow(path, 2.5) {
+		t.Fatalf("plain costs should walk straight: ok=%v %v", ok, path)
+	}
+	pf = NewPathfinder(mud, AgentSpec{})
+	pf.Cost = &TextureCostModel{Textures: []TextureCost{{Mask: 1 << texMud, Factor: 4}}}
+	if path, ok := pf.FindPath(0, 2, 10, 8, 2); !ok || !pathLeavesRow(path, 2.5) {
+		t.Fatalf("mud should be avoided: ok=%v %v", ok, path)
+	}
+
+	// x=4 在 z=1..3 有 0.5m 的坎，z=0 与 z=4 可绕行
+	bump := buildCostWorld(9, 5, func(x, z int32) RichRange {
+		if x == 4 && z >= 1 && z <= 3 {
+			return RichRange{Range: Range{Begin: 0, End: 20}}
+		}
+		return RichRange{Range: Range{Begin: 0, End: 10}}
+	})
+	pf = NewPathfinder(bump, AgentSpec{})
+	if path, ok := pf.FindPath(0, 2, 10, 8, 2); !ok || pathLeavesRow(path, 2.5) {
+		t.Fatalf("plain costs should climb the bump: ok=%v %v", ok, path)
+	}
+	pf = NewPathfinder(bump, AgentSpec{})
+	pf.Cost = &TextureCostModel{Climb: 0.2, Descent: 0.2}
+	if path, ok := pf.FindPath(0, 2, 10, 8, 2); !ok || !pathLeavesRow(path, 2.5) {
+		t.Fatalf("the bump should be walked around: ok=%v %v", ok, path)
+	}
+}
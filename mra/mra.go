@@ -4,6 +4,8 @@ import (
 	"container/heap"
 	"fmt"
 	"math"
+
+	"pathfinding/navtrace"
 )
 
 // ===================== Geometry / Grid =====================
@@ -13,23 +15,52 @@ type Pt struct{ X, Y int }
 type Grid struct {
 	W, H int
 	Occ  [][]bool // Occ[y][x] == true means obstacle
+	// Footprint is the agent edge in cells; 0 means the default 2x2.
+	Footprint int
+
+	epoch uint64 // bumped by SetOcc
+}
+
+// SetOcc sets the obstacle flag of (x, y) and bumps the grid epoch, so
+// in-progress MRAStar.Step searches restart. Edit Occ through SetOcc once a
+// search has started.
+func (g *Grid) SetOcc(x, y int, blocked bool) {
+	if !g.InBounds(x, y) || g.Occ[y][x] == blocked {
+		return
+	}
+	g.Occ[y][x] = blocked
+	g.epoch++
+}
+
+// Epoch returns the number of SetOcc changes so far.
+func (g *Grid) Epoch() uint64 { return g.epoch }
+
+func (g *Grid) footprint() int {
+	if g.Footprint <= 0 {
+		return 2
+	}
+	return g.Footprint
 }
 
 func (g *Grid) InBounds(x, y int) bool { return x >= 0 && x < g.W && y >= 0 && y < g.H }
 func (g *Grid) FreeCell(x, y int) bool { return g.InBounds(x, y) && !g.Occ[y][x] }
 
-// 2x2 footprint: reference point is the bottom-left corner (x,y)
-// Occupied cells: (x,y), (x+1,y), (x,y+1), (x+1,y+1)
+// NxN footprint (N = Footprint, default 2): reference point is the
+// bottom-left corner (x,y); occupied cells are (x..x+N-1, y..y+N-1).
 func (g *Grid) FootprintFree(p Pt) bool {
-	x, y := p.X, p.Y
-	return g.FreeCell(x, y) &&
-		g.FreeCell(x+1, y) &&
-		g.FreeCell(x, y+1) &&
-		g.FreeCell(x+1, y+1)
+	n := g.footprint()
+	for dy := 0; dy < n; dy++ {
+		for dx := 0; dx < n; dx++ {
+			if !g.FreeCell(p.X+dx, p.Y+dy) {
+				return false
+			}
+		}
+	}
+	return true
 }
 
 // Swept collision for a move p -> q:
-// We "sample" along the motion in unit steps (r=1), checking 2x2 footprint each step.
+// We "sample" along the motion in unit steps (r=1), checking the footprint each step.
 // This prevents coarse layers from "jumping through" obstacles.
 func (g *Grid) CollisionFree(p, q Pt) bool {
 	dx := q.X - p.X
@@ -127,6 +158,91 @@ type MRAStar struct {
 
 	Start Pt
 	Goal  Pt
+
+	// Tracer, if set, receives the search events of Plan. Queue is the index
+	// into Searches; X/Z are the grid x/y and Y is 0.
+	Tracer navtrace.Tracer
+
+	// Partial makes PlanOutcome return the path to the expanded node closest
+	// to the goal when the goal is not reached.
+	Partial PartialMode
+
+	// Step state: Expanded counts expansions per queue, Path is the result
+	// once Found and Restarts counts searches dropped after a grid change.
+	Expanded []int
+	Path     []Pt
+	Restarts int
+	status   Status
+	outcome  Outcome
+	closest  *Node // expanded node closest to the goal, see Partial
+	started  bool
+	epoch    uint64
+}
+
+// PartialMode selects how the closest node is measured for partial paths.
+type PartialMode int
+
+const (
+	PartialOff PartialMode = iota
+	// PartialHeuristic keeps the node with the smallest heuristic, the
+	// straight-line distance to the goal on this 2D grid.
+	PartialHeuristic
+)
+
+// Outcome tells how a plan ended.
+type Outcome int
+
+const (
+	Reached Outcome = iota
+	// Unreachable: the open lists ran dry, or the goal footprint is blocked
+	// and Partial is off.
+	Unreachable
+	// BudgetExhausted: maxExpansions ran out first.
+	BudgetExhausted
+	// StartInvalid: the start footprint is blocked.
+	StartInvalid
+)
+
+func (o Outcome) String() string {
+	switch o {
+	case Reached:
+		return "reached"
+	case Unreachable:
+		return "unreachable"
+	case BudgetExhausted:
+		return "budget-exhausted"
+	case StartInvalid:
+		return "start-invalid"
+	}
+	return "unknown"
+}
+
+// Status is the state of a Step search.
+type Status int
+
+const (
+	InProgress Status = iota
+	Found
+	Failed
+)
+
+func (s Status) String() string {
+	switch s {
+	case InProgress:
+		return "in-progress"
+	case Found:
+		return "found"
+	case Failed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+func (m *MRAStar) trace(k navtrace.Kind, r navtrace.Reason, queue int, p Pt, g, f float64) {
+	if m.Tracer == nil {
+		return
+	}
+	m.Tracer.Record(navtrace.Event{Kind: k, Reason: r, Queue: int32(queue), At: navtrace.Coord{X: int32(p.X), Z: int32(p.Y)}, G: float32(g), F: float32(f)})
 }
 
 // key includes step to avoid collisions between spaces
@@ -199,14 +315,18 @@ func (m *MRAStar) neighbors(p Pt, step int) []Pt {
 	out := make([]Pt, 0, 8)
 	for _, d := range dirs {
 		np := Pt{p.X + d.X, p.Y + d.Y}
-		// quick bounds check for footprint (needs x+1,y+1)
-		if m.Grid.InBounds(np.X, np.Y) && m.Grid.InBounds(np.X+1, np.Y+1) {
+		// quick bounds check for footprint (needs x+N-1,y+N-1)
+		if n := m.Grid.footprint(); m.Grid.InBounds(np.X, np.Y) && m.Grid.InBounds(np.X+n-1, np.Y+n-1) {
 			out = append(out, np)
 		}
 	}
 	return out
 }
 
+// moveCost is the plain length of a move. Grid carries occupancy only, with
+// no floor texture, height or config to price, so MRA* has no cost model;
+// use the navgation planners or the root Pathfinder's Cost for weighted
+// terrain.
 func moveCost(step int, diagonal bool) float64 {
 	if diagonal {
 		return float64(step) * math.Sqrt2
@@ -219,6 +339,7 @@ func (m *MRAStar) pushOrUpdate(si int, p Pt, g float64, parent *Node) {
 	s := m.Searches[si]
 	k := m.key(p, s.Step)
 	if old, ok := s.G[k]; ok && g >= old {
+		m.trace(navtrace.Reject, navtrace.Worse, si, p, g, g+s.Weight*m.heuristic(p))
 		return
 	}
 	s.G[k] = g
@@ -232,6 +353,7 @@ func (m *MRAStar) pushOrUpdate(si int, p Pt, g float64, parent *Node) {
 	}
 	s.Best[k] = n
 	heap.Push(&s.Open, n)
+	m.trace(navtrace.Push, 0, si, p, n.G, n.F)
 }
 
 func (m *MRAStar) reconstruct(goalNode *Node) []Pt {
@@ -277,21 +399,78 @@ func (m *MRAStar) chooseQueue() int {
 	return bestIdx
 }
 
+// Plan searches with at most maxExpansions expansions and gives up when
+// they run out, so maxExpansions <= 0 always fails. With Partial set, a
+// failed plan may still return a partial path; see PlanOutcome.
 func (m *MRAStar) Plan(maxExpansions int) ([]Pt, bool) {
-	// Validate start/goal footprints
-	if !m.Grid.FootprintFree(m.Start) || !m.Grid.FootprintFree(m.Goal) {
-		return nil, false
+	path, out := m.PlanOutcome(maxExpansions)
+	return path, out == Reached
+}
+
+// PlanOutcome is Plan with the reason of a failure. When the goal is not
+// reached and Partial is set, the path leads to the expanded node closest
+// to the goal; it is nil for StartInvalid.
+func (m *MRAStar) PlanOutcome(maxExpansions int) ([]Pt, Outcome) {
+	switch m.step(max(maxExpansions, 0)) {
+	case Found:
+		fmt.Println("expanded per queue:", m.Expanded)
+		return m.Path, Reached
+	case InProgress:
+		m.trace(navtrace.Failed, 0, m.AnchorIdx, m.Goal, 0, 0)
+		m.outcome = BudgetExhausted
+	}
+	if m.Partial == PartialOff || m.closest == nil {
+		return nil, m.outcome
 	}
+	return m.reconstruct(m.closest), m.outcome
+}
 
-	// init: put start into every space it coincides with
-	indices := m.getSpaceIndices(m.Start)
-	for _, i := range indices {
-		m.pushOrUpdate(i, m.Start, 0, nil)
+// Step runs at most budget expansions (<= 0 means until done) and keeps the
+// open and closed sets for the next call. A grid change (Grid.Epoch) between
+// calls restarts the search. Once Found or Failed, Step returns the same
+// status.
+func (m *MRAStar) Step(budget int) Status {
+	if budget <= 0 {
+		budget = -1
+	}
+	return m.step(budget)
+}
+
+// step is Step with budget < 0 meaning until done; a zero budget only
+// validates the endpoints.
+func (m *MRAStar) step(budget int) Status {
+	if m.status != InProgress {
+		return m.status
+	}
+	if m.started && m.epoch != m.Grid.Epoch() {
+		m.Restarts++
+		m.reset()
+	}
+	if !m.started {
+		m.started = true
+		m.epoch = m.Grid.Epoch()
+		m.trace(navtrace.Start, 0, m.AnchorIdx, m.Start, 0, m.heuristic(m.Start))
+		m.trace(navtrace.Goal, 0, m.AnchorIdx, m.Goal, 0, 0)
+		// Validate start/goal footprints
+		if !m.Grid.FootprintFree(m.Start) {
+			return m.fail(StartInvalid)
+		}
+		// A blocked goal is never reached, but with Partial set the search
+		// still runs to find the closest reachable node.
+		if !m.Grid.FootprintFree(m.Goal) && m.Partial == PartialOff {
+			return m.fail(Unreachable)
+		}
+
+		// init: put start into every space it coincides with
+		indices := m.getSpaceIndices(m.Start)
+		for _, i := range indices {
+			m.pushOrUpdate(i, m.Start, 0, nil)
+		}
+		m.Expanded = make([]int, len(m.Searches))
 	}
 
-	expanded := make([]int, len(m.Searches))
 	exp := 0
-	for exp < maxExpansions {
+	for budget < 0 || exp < budget {
 		// stop if all opens empty
 		allEmpty := true
 		for _, s := range m.Searches {
@@ -301,7 +480,7 @@ func (m *MRAStar) Plan(maxExpansions int) ([]Pt, bool) {
 			}
 		}
 		if allEmpty {
-			return nil, false
+			return m.fail(Unreachable)
 		}
 
 		i := m.chooseQueue()
@@ -311,24 +490,37 @@ func (m *MRAStar) Plan(maxExpansions int) ([]Pt, bool) {
 			sel = m.Searches[m.AnchorIdx]
 			i = m.AnchorIdx
 			if sel.Open.Len() == 0 {
-				return nil, false
+				return m.fail(Unreachable)
 			}
 		}
 
 		cur := heap.Pop(&sel.Open).(*Node)
+		m.trace(navtrace.Pop, 0, i, cur.P, cur.G, cur.F)
 		ck := m.key(cur.P, sel.Step)
 		if sel.Closed[ck] {
+			m.trace(navtrace.Reject, navtrace.Stale, i, cur.P, cur.G, cur.F)
 			continue
 		}
 		sel.Closed[ck] = true
 		exp++
+		m.trace(navtrace.Expand, 0, i, cur.P, cur.G, cur.F)
 
-		expanded[i]++
+		m.Expanded[i]++
+		if m.Partial != PartialOff && (m.closest == nil || cur.H < m.closest.H || cur.H == m.closest.H && cur.G < m.closest.G) {
+			m.closest = cur
+		}
 		// goal test: goal must coincide with this resolution to be reachable in this space
 		if cur.P == m.Goal && i == m.AnchorIdx {
 			// simplest/safest: return when anchor reaches exact goal reference
-			fmt.Println("expanded per queue:", expanded)
-			return m.reconstruct(cur), true
+			m.Path = m.reconstruct(cur)
+			m.status = Found
+			if m.Tracer != nil {
+				m.trace(navtrace.Found, 0, i, cur.P, cur.G, cur.G)
+				for _, p := range m.Path {
+					m.trace(navtrace.Path, 0, i, p, 0, 0)
+				}
+			}
+			return m.status
 		}
 		// If you want: allow non-anchor to return too (bounded-suboptimal), but then ensure your
 		// termination condition matches your theoretical bound requirements.
@@ -339,11 +531,13 @@ func (m *MRAStar) Plan(maxExpansions int) ([]Pt, bool) {
 
 			// Collision check with 2x2 footprint via swept sampling
 			if !m.Grid.CollisionFree(cur.P, nb) {
+				m.trace(navtrace.Reject, navtrace.Blocked, i, nb, cur.G, cur.F)
 				continue
 			}
 
 			nk := m.key(nb, sel.Step)
 			if sel.Closed[nk] {
+				m.trace(navtrace.Reject, navtrace.Closed, i, nb, cur.G, cur.F)
 				continue
 			}
 
@@ -370,7 +564,30 @@ func (m *MRAStar) Plan(maxExpansions int) ([]Pt, bool) {
 		}
 	}
 
-	return nil, false
+	return m.status
+}
+
+func (m *MRAStar) fail(reason Outcome) Status {
+	m.status = Failed
+	m.outcome = reason
+	m.trace(navtrace.Failed, 0, m.AnchorIdx, m.Goal, 0, 0)
+	return m.status
+}
+
+// reset drops the search state so the next Step starts over.
+func (m *MRAStar) reset() {
+	for _, s := range m.Searches {
+		s.Open = PQ{}
+		s.G = map[int]float64{}
+		s.Closed = map[int]bool{}
+		s.Best = map[int]*Node{}
+	}
+	m.Expanded = nil
+	m.Path = nil
+	m.status = InProgress
+	m.outcome = Reached
+	m.closest = nil
+	m.started = false
 }
 
 // ===================== Demo =====================
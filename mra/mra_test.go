@@ -0,0 +1,214 @@
+package main
+
+import (
+	"slices"
+	"testing"
+
+	"pathfinding/navtrace"
+)
+
+// wallGrid is a 30x20 map split by a wall along y=10 with a 2-cell gap at
+// x=14..15, the only way through for the default 2x2 footprint.
+func wallGrid() *Grid {
+	const W, H = 30, 20
+	occ := make([][]bool, H)
+	for y := range occ {
+		occ[y] = make([]bool, W)
+	}
+	for x := 0; x < W; x++ {
+		occ[10][x] = x != 14 && x != 15
+	}
+	return &Grid{W: W, H: H, Occ: occ}
+}
+
+func newWallSearch(g *Grid) *MRAStar {
+	return NewMRAStar2D(g, Pt{2, 2}, Pt{26, 16}, []int{1, 2}, 1.5, 2)
+}
+
+func TestMRAStar_StepMatchesPlan(t *testing.T) {
+	want, ok := newWallSearch(wallGrid()).Plan(1 << 20)
+	if !ok {
+		t.Fatalf("Plan found no path")
+	}
+
+	m := newWallSearch(wallGrid())
+	calls := 0
+	st := InProgress
+	for st == InProgress {
+		st = m.Step(7)
+		calls++
+	}
+	if st != Found || !slices.Equal(m.Path, want) {
+		t.Fatalf("sliced search: %v, path %v, want %v", st, m.Path, want)
+	}
+	if calls < 2 || m.Restarts != 0 {
+		t.Fatalf("%d calls, %d restarts", calls, m.Restarts)
+	}
+	// A finished search keeps its result.
+	m.Grid.SetOcc(0, 0, true)
+	if m.Step(1) != Found || m.Restarts != 0 {
+		t.Fatalf("finished search ran again")
+	}
+}
+
+func TestMRAStar_StepRestartsOnGridChange(t *testing.T) {
+	g := wallGrid()
+	m := newWallSearch(g)
+	if st := m.Step(20); st != InProgress {
+		t.Fatalf("first slice: %v", st)
+	}
+
+	// Unrelated edits restart the search, which then still finds the path.
+	g.SetOcc(0, 19, true)
+	g.SetOcc(0, 19, true) // no change, no new epoch
+	for m.Step(20) == InProgress {
+	}
+	want, _ := newWallSearch(wallGrid()).Plan(1 << 20)
+	if m.Restarts != 1 || !slices.Equal(m.Path, want) {
+		t.Fatalf("after an unrelated edit: %d restarts, path %v", m.Restarts, m.Path)
+	}
+
+	// Closing the gap mid-search makes the restarted search fail.
+	m = newWallSearch(g)
+	m.Step(20)
+	g.SetOcc(14, 10, true)
+	st := InProgress
+	for st == InProgress {
+		st = m.Step(50)
+	}
+	if st != Failed || m.Restarts != 1 {
+		t.Fatalf("closed gap: %v after %d restarts", st, m.Restarts)
+	}
+	if path, out := m.PlanOutcome(1 << 20); path != nil || out != Unreachable {
+		t.Fatalf("closed gap outcome: %v %v", path, out)
+	}
+}
+
+func TestMRAStar_PlanBudget(t *testing.T) {
+	if path, out := newWallSearch(wallGrid()).PlanOutcome(5); path != nil || out != BudgetExhausted {
+		t.Fatalf("Plan(5): %v %v", path, out)
+	}
+	// Unlike Step, Plan has no unlimited budget.
+	for _, n := range []int{0, -1} {
+		if path, ok := newWallSearch(wallGrid()).Plan(n); path != nil || ok {
+			t.Fatalf("Plan(%d): %v %v", n, path, ok)
+		}
+	}
+	if m := newWallSearch(wallGrid()); m.Step(0) != Found || len(m.Path) == 0 {
+		t.Fatalf("Step(0) did not run to the end")
+	}
+}
+
+func checkMRAPath(t *testing.T, g *Grid, path []Pt, start Pt) {
+	t.Helper()
+	if len(path) == 0 || path[0] != start {
+		t.Fatalf("path %v does not start at %v", path, start)
+	}
+	for i := 1; i < len(path); i++ {
+		if !g.CollisionFree(path[i-1], path[i]) {
+			t.Fatalf("move %v -> %v collides", path[i-1], path[i])
+		}
+	}
+}
+
+func TestMRAStar_PartialPlans(t *testing.T) {
+	// The goal sits in a block on the wall, so its footprint is never free.
+	g := wallGrid()
+	for y := 11; y < 14; y++ {
+		for x := 20; x < 24; x++ {
+			g.SetOcc(x, y, true)
+		}
+	}
+	start, goal := Pt{2, 2}, Pt{21, 11}
+
+	m := NewMRAStar2D(g, start, goal, []int{1, 2}, 1.5, 2)
+	if path, out := m.PlanOutcome(1 << 20); path != nil || out != Unreachable {
+		t.Fatalf("blocked goal without Partial: %v %v", path, out)
+	}
+
+	m = NewMRAStar2D(g, start, goal, []int{1, 2}, 1.5, 2)
+	m.Partial = PartialHeuristic
+	path, out := m.PlanOutcome(1 << 20)
+	if out != Unreachable {
+		t.Fatalf("blocked goal outcome %v", out)
+	}
+	checkMRAPath(t, g, path, start)
+	// Above the wall the closest free footprints are 3 cells off the goal.
+	if end := path[len(path)-1]; m.heuristic(end) != 3 || end.Y < 11 {
+		t.Fatalf("partial path ends at %v, %v from the goal", end, m.heuristic(end))
+	}
+
+	m = NewMRAStar2D(g, start, goal, []int{1, 2}, 1.5, 2)
+	m.Partial = PartialHeuristic
+	path, out = m.PlanOutcome(6)
+	if out != BudgetExhausted {
+		t.Fatalf("budget outcome %v", out)
+	}
+	checkMRAPath(t, g, path, start)
+	if end := path[len(path)-1]; len(path) < 2 || m.heuristic(end) >= m.heuristic(start) {
+		t.Fatalf("budget partial path %v does not get closer", path)
+	}
+
+	g.SetOcc(2, 2, true)
+	m = NewMRAStar2D(g, start, goal, []int{1, 2}, 1.5, 2)
+	m.Partial = PartialHeuristic
+	if path, out := m.PlanOutcome(1 << 20); path != nil || out != StartInvalid {
+		t.Fatalf("blocked start: %v %v", path, out)
+	}
+}
+
+// checkMRATrace checks that tr has one Expand per counted expansion, ends
+// with Found and Path events matching m.Path or with Failed, and has no
+// Path events for a failed search.
+func checkMRATrace(t *testing.T, m *MRAStar, tr *navtrace.Trace) {
+	t.Helper()
+	total := 0
+	for _, n := range m.Expanded {
+		total += n
+	}
+	if got := tr.Count(navtrace.Expand); got != total {
+		t.Fatalf("%d expand events, %d expansions", got, total)
+	}
+	found, _, nodes := tr.Result()
+	last := tr.Events[len(tr.Events)-1].Kind
+	if m.status == Found {
+		if !found || last != navtrace.Path || len(nodes) != len(m.Path) {
+			t.Fatalf("found: trace ends with %v, %d path events for %d points", last, len(nodes), len(m.Path))
+		}
+		for i, c := range nodes {
+			if p := (Pt{int(c.X), int(c.Z)}); p != m.Path[i] {
+				t.Fatalf("path event %d at %v, path point %v", i, p, m.Path[i])
+			}
+		}
+	} else if found || last != navtrace.Failed || len(nodes) != 0 {
+		t.Fatalf("failed search: trace ends with %v, found=%v", last, found)
+	}
+}
+
+func TestMRAStar_Trace(t *testing.T) {
+	tr := navtrace.New(1, 1)
+	m := newWallSearch(wallGrid())
+	m.Tracer = tr
+	if _, ok := m.Plan(1 << 20); !ok {
+		t.Fatalf("no path")
+	}
+	checkMRATrace(t, m, tr)
+
+	tr.Reset()
+	m = newWallSearch(wallGrid())
+	m.Tracer = tr
+	if _, out := m.PlanOutcome(5); out != BudgetExhausted {
+		t.Fatalf("Plan(5): %v", out)
+	}
+	checkMRATrace(t, m, tr)
+
+	g := wallGrid()
+	g.SetOcc(14, 10, true)
+	tr.Reset()
+	m = newWallSearch(g)
+	m.Tracer = tr
+	if _, out := m.PlanOutcome(1 << 20); out != Unreachable {
+		t.Fatalf("closed gap: %v", out)
+	}
+	checkMRATrace(t, m, tr)
+}
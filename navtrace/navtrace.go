@@ -0,0 +1,286 @@
+// Package navtrace records what a path search did, event by event, so a run
+// can be replayed step by step in the 3D visualizer (mra_3d_new.html, "导入轨迹").
+//
+// The planners take an optional Tracer; *Trace records into memory and
+// WriteTo/Read store it in a compact binary file.
+package navtrace
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+)
+
+// Kind is the type of an Event.
+type Kind uint8
+
+const (
+	// Start and Goal give the endpoints the search uses. They come first.
+	Start Kind = iota
+	Goal
+	// Push is a node inserted into or relaxed in an open list.
+	Push
+	// Pop is a node taken from an open list. It is followed by Expand, or by
+	// Reject with Stale when the entry was already closed.
+	Pop
+	// Expand is a node being closed; its neighbours follow as Push or Reject.
+	Expand
+	// Reject is a neighbour or popped entry that was dropped; see Reason.
+	Reject
+	// Path events list the nodes of the result from start to goal. They
+	// follow Found.
+	Path
+	// Found and Failed end the trace. Found carries the goal node and its
+	// cost in G.
+	Found
+	Failed
+	numKinds
+)
+
+var kindNames = [numKinds]string{"start", "goal", "push", "pop", "expand", "reject", "path", "found", "failed"}
+
+func (k Kind) String() string {
+	if k < numKinds {
+		return kindNames[k]
+	}
+	return fmt.Sprintf("Kind(%d)", uint8(k))
+}
+
+// Reason tells why a Reject event dropped a node.
+type Reason uint8
+
+const (
+	NoReason Reason = iota
+	// Blocked: the move is not passable (no stand, wall, step limits).
+	Blocked
+	// CornerCut: a diagonal move next to a blocked orthogonal one.
+	CornerCut
+	// Closed: the node was already expanded.
+	Closed
+	// Worse: the node is known with a g no larger than the new one.
+	Worse
+	// Stale: a popped open-list entry superseded by a better one.
+	Stale
+	// OutOfBounds: the node is outside the searched area.
+	OutOfBounds
+	numReasons
+)
+
+var reasonNames = [numReasons]string{"", "blocked", "corner-cut", "closed", "worse", "stale", "out-of-bounds"}
+
+func (r Reason) String() string {
+	if r < numReasons {
+		return reasonNames[r]
+	}
+	return fmt.Sprintf("Reason(%d)", uint8(r))
+}
+
+// Coord is a search state. X/Z are horizontal cells and Y the height, in the
+// planner's own units (see Trace.CellSize and Trace.HeightScale).
+type Coord struct {
+	X, Y, Z int32
+}
+
+// Event is one step of a search.
+type Event struct {
+	Kind   Kind
+	Reason Reason // Reject only
+	// Queue is the open list the event belongs to, 0 for single-queue
+	// planners and the resolution index for MRA*.
+	Queue int32
+	At    Coord
+	G, F  float32
+}
+
+// Tracer receives the events of a search. Planners call it synchronously;
+// a nil Tracer disables tracing.
+type Tracer interface {
+	Record(e Event)
+}
+
+// Trace is a recorded search. It implements Tracer.
+type Trace struct {
+	// CellSize is the edge of one horizontal cell in meters.
+	CellSize float32
+	// HeightScale is the number of height units per meter.
+	HeightScale float32
+	Events      []Event
+}
+
+// New returns an empty trace for the given units.
+func New(cellSize, heightScale float32) *Trace {
+	return &Trace{CellSize: cellSize, HeightScale: heightScale}
+}
+
+func (t *Trace) Record(e Event) { t.Events = append(t.Events, e) }
+
+// Reset drops the recorded events and keeps the units.
+func (t *Trace) Reset() { t.Events = t.Events[:0] }
+
+// Result returns the outcome at the end of the trace: whether the goal was
+// found, its cost and the path nodes.
+func (t *Trace) Result() (found bool, cost float32, path []Coord) {
+	for _, e := range t.Events {
+		switch e.Kind {
+		case Found:
+			found, cost = true, e.G
+		case Path:
+			path = append(path, e.At)
+		}
+	}
+	return found, cost, path
+}
+
+// Count returns the number of events of kind k.
+func (t *Trace) Count(k Kind) int {
+	n := 0
+	for _, e := range t.Events {
+		if e.Kind == k {
+			n++
+		}
+	}
+	return n
+}
+
+// ---------------------------------------------------------------------------
+// File format, little endian:
+//
+//	"ZTRC" u8 version f32 cellSize f32 heightScale uvarint count
+//	count x { u8 kind<<4|reason, uvarint queue,
+//	          varint dx, varint dy, varint dz, f32 g, f32 f }
+//
+// Coordinates are deltas from the previous event, which keeps neighbouring
+// events to a byte or two per axis.
+
+const (
+	fileMagic   = "ZTRC"
+	fileVersion = 1
+)
+
+// ErrBadTrace is returned by Read for input that is not a trace file.
+var ErrBadTrace = errors.New("navtrace: not a trace file")
+
+// WriteTo writes t in the trace file format.
+func (t *Trace) WriteTo(w io.Writer) (int64, error) {
+	buf := bufio.NewWriter(w)
+	bw := &countWriter{w: buf}
+	bw.write([]byte(fileMagic))
+	bw.write([]byte{fileVersion})
+	bw.f32(t.CellSize)
+	bw.f32(t.HeightScale)
+	bw.uvarint(uint64(len(t.Events)))
+	var prev Coord
+	for _, e := range t.Events {
+		bw.write([]byte{byte(e.Kind)<<4 | byte(e.Reason)&0xF})
+		bw.uvarint(uint64(uint32(e.Queue)))
+		bw.varint(int64(e.At.X) - int64(prev.X))
+		bw.varint(int64(e.At.Y) - int64(prev.Y))
+		bw.varint(int64(e.At.Z) - int64(prev.Z))
+		bw.f32(e.G)
+		bw.f32(e.F)
+		prev = e.At
+	}
+	if bw.err == nil {
+		bw.err = buf.Flush()
+	}
+	return bw.n, bw.err
+}
+
+// Read reads a trace written by WriteTo.
+func Read(r io.Reader) (*Trace, error) {
+	br := bufio.NewReader(r)
+	var head [5]byte
+	if _, err := io.ReadFull(br, head[:]); err != nil || string(head[:4]) != fileMagic {
+		return nil, ErrBadTrace
+	}
+	if head[4] != fileVersion {
+		return nil, fmt.Errorf("navtrace: unsupported version %d", head[4])
+	}
+	t := &Trace{}
+	var err error
+	f32 := func() float32 {
+		var b [4]byte
+		if err == nil {
+			_, err = io.ReadFull(br, b[:])
+		}
+		return math.Float32frombits(binary.LittleEndian.Uint32(b[:]))
+	}
+	uv := func() uint64 {
+		var v uint64
+		if err == nil {
+			v, err = binary.ReadUvarint(br)
+		}
+		return v
+	}
+	sv := func() int64 {
+		var v int64
+		if err == nil {
+			v, err = binary.ReadVarint(br)
+		}
+		return v
+	}
+	t.CellSize = f32()
+	t.HeightScale = f32()
+	n := uv()
+	if err != nil {
+		return nil, fmt.Errorf("navtrace: header: %w", err)
+	}
+	var prev Coord
+	for i := uint64(0); i < n; i++ {
+		var kr byte
+		if kr, err = br.ReadByte(); err != nil {
+			break
+		}
+		e := Event{Kind: Kind(kr >> 4), Reason: Reason(kr & 0xF)}
+		if e.Kind >= numKinds {
+			return nil, fmt.Errorf("navtrace: event %d: bad kind %d", i, e.Kind)
+		}
+		e.Queue = int32(uint32(uv()))
+		e.At.X = prev.X + int32(sv())
+		e.At.Y = prev.Y + int32(sv())
+		e.At.Z = prev.Z + int32(sv())
+		e.G = f32()
+		e.F = f32()
+		if err != nil {
+			break
+		}
+		t.Events = append(t.Events, e)
+		prev = e.At
+	}
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("navtrace: event %d: %w", len(t.Events), err)
+	}
+	return t, nil
+}
+
+// countWriter keeps the first error and the byte count.
+type countWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+	buf [binary.MaxVarintLen64]byte
+}
+
+func (c *countWriter) write(p []byte) {
+	if c.err != nil {
+		return
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+}
+
+func (c *countWriter) f32(v float32) {
+	binary.LittleEndian.PutUint32(c.buf[:4], math.Float32bits(v))
+	c.write(c.buf[:4])
+}
+
+func (c *countWriter) uvarint(v uint64) { c.write(c.buf[:binary.PutUvarint(c.buf[:], v)]) }
+
+func (c *countWriter) varint(v int64) { c.write(c.buf[:binary.PutVarint(c.buf[:], v)]) }
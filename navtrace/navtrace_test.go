@@ -0,0 +1,95 @@
+package navtrace
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func sampleTrace() *Trace {
+	t := New(0.25, 20)
+	t.Record(Event{Kind: Start, At: Coord{10, 200, 10}, F: 12})
+	t.Record(Event{Kind: Goal, At: Coord{2, 0, 30}})
+	t.Record(Event{Kind: Push, Queue: 1, At: Coord{-5, 3, -70000}, G: 1.5, F: 2.25})
+	t.Record(Event{Kind: Reject, Reason: OutOfBounds, Queue: 3, At: Coord{-1 << 31, 1<<31 - 1, 0}})
+	t.Record(Event{Kind: Path, At: Coord{1<<31 - 1, -1 << 31, 7}, G: -1})
+	t.Record(Event{Kind: Failed})
+	return t
+}
+
+func TestTrace_RoundTrip(t *testing.T) {
+	want := sampleTrace()
+	var buf bytes.Buffer
+	n, err := want.WriteTo(&buf)
+	if err != nil || n != int64(buf.Len()) {
+		t.Fatalf("WriteTo: %d bytes, %v (buffer %d)", n, err, buf.Len())
+	}
+	got, err := Read(&buf)
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if got.CellSize != want.CellSize || got.HeightScale != want.HeightScale || !slices.Equal(got.Events, want.Events) {
+		t.Fatalf("read back %+v, want %+v", got, want)
+	}
+
+	var empty bytes.Buffer
+	if _, err := New(1, 20).WriteTo(&empty); err != nil {
+		t.Fatalf("WriteTo empty: %v", err)
+	}
+	if got, err := Read(&empty); err != nil || len(got.Events) != 0 || got.CellSize != 1 {
+		t.Fatalf("empty trace: %+v %v", got, err)
+	}
+}
+
+func TestRead_BadInput(t *testing.T) {
+	var buf bytes.Buffer
+	sampleTrace().WriteTo(&buf)
+	data := buf.Bytes()
+
+	for _, in := range []string{"", "ZTR", "ZTRX\x01", "{\"events\":[]}"} {
+		if _, err := Read(strings.NewReader(in)); err != ErrBadTrace {
+			t.Fatalf("Read(%q): %v, want ErrBadTrace", in, err)
+		}
+	}
+	if _, err := Read(bytes.NewReader(append([]byte("ZTRC\x02"), data[5:]...))); err == nil || err == ErrBadTrace {
+		t.Fatalf("unknown version: %v", err)
+	}
+	// Every cut inside the header or an event is an error, never a shorter trace.
+	for n := 5; n < len(data); n++ {
+		if _, err := Read(bytes.NewReader(data[:n])); err == nil {
+			t.Fatalf("truncated to %d of %d bytes: no error", n, len(data))
+		} else if n > 13 && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Fatalf("truncated to %d of %d bytes: %v", n, len(data), err)
+		}
+	}
+
+	// The first event byte follows the 14-byte header with a one-byte count.
+	bad := slices.Clone(data)
+	bad[14] = byte(numKinds) << 4
+	if _, err := Read(bytes.NewReader(bad)); err == nil || !strings.Contains(err.Error(), "bad kind") {
+		t.Fatalf("bad kind: %v", err)
+	}
+}
+
+func TestTrace_Result(t *testing.T) {
+	tr := New(1, 20)
+	tr.Record(Event{Kind: Expand, At: Coord{0, 0, 0}})
+	tr.Record(Event{Kind: Expand, At: Coord{1, 0, 0}})
+	tr.Record(Event{Kind: Found, At: Coord{1, 0, 0}, G: 1})
+	tr.Record(Event{Kind: Path, At: Coord{0, 0, 0}})
+	tr.Record(Event{Kind: Path, At: Coord{1, 0, 0}})
+	found, cost, path := tr.Result()
+	if !found || cost != 1 || !slices.Equal(path, []Coord{{0, 0, 0}, {1, 0, 0}}) || tr.Count(Expand) != 2 {
+		t.Fatalf("Result: %v %v %v, %d expands", found, cost, path, tr.Count(Expand))
+	}
+	tr.Reset()
+	if len(tr.Events) != 0 || tr.CellSize != 1 {
+		t.Fatalf("Reset: %+v", tr)
+	}
+	if Kind(numKinds).String() != "Kind(9)" || Stale.String() != "stale" {
+		t.Fatalf("names: %v %v", Kind(numKinds), Stale)
+	}
+}
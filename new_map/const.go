@@ -10,3 +10,6 @@ const MaxRangeEnd = 0x_FF00
 const SecondaryAccuracy = 4                                    // 1x1的x,z网格的单边被分成了4等份
 const SecondaryTileNum = SecondaryAccuracy * SecondaryAccuracy // 1x1的x,z网格被分成了16等份
 const SecondaryTileLen = 1 / float32(SecondaryAccuracy)        // 二级tile的边长
+
+// HeightScale Range 高度与真实世界高度的换算系数：真实高度 = value / HeightScale.
+const HeightScale = 20
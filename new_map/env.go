@@ -13,8 +13,16 @@ type Env struct {
 	gridW, gridH uint16
 
 	grids []*GridRBData // len = gridW*gridH
+
+	listeners    map[int]ChangeListener
+	nextListener int
+
+	epoch uint64 // 每次修改地图数据后递增, 见 Epoch
 }
 
+// ChangeListener 在 ApplyRichOperationsExt 修改 Env 之后被调用, lps 为本次受影响的 LP 点（已去重）.
+type ChangeListener func(lps []Point2d)
+
 func NewEnv(rect Rect) *Env {
 	var env = &Env{
 		rect: rect,
@@ -32,6 +40,80 @@ func (e *Env) Destroy() {
 	e.grids = nil
 }
 
+// AddChangeListener 注册修改回调, 返回的 id 用于 RemoveChangeListener.
+func (e *Env) AddChangeListener(l ChangeListener) int {
+	if e.listeners == nil {
+		e.listeners = make(map[int]ChangeListener)
+	}
+	e.nextListener++
+	e.listeners[e.nextListener] = l
+	return e.nextListener
+}
+
+// RemoveChangeListener 注销 AddChangeListener 注册的回调.
+func (e *Env) RemoveChangeListener(id int) {
+	delete(e.listeners, id)
+}
+
+func (e *Env) notifyChanged(lps []Point2d) {
+	if len(lps) == 0 {
+		return
+	}
+	// 按注册顺序回调: 先注册的派生数据 (如 FootprintLayer, ClearanceField)
+	// 先于依赖它们的 planner 结构更新.
+	ids := make([]int, 0, len(e.listeners))
+	for id := range e.listeners {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if l, ok := e.listeners[id]; ok {
+			l(lps)
+		}
+	}
+}
+
+// Epoch 返回地图数据的版本号. 每次有效的 ApplyRichOperationsExt (先于回调 ChangeListener)
+// 和 SetGrid 都会使其加 1, 用于检测长时间运行的查询期间地图是否被改动.
+func (e *Env) Epoch() uint64 {
+	return e.epoch
+}
+
+// GridDims 返回 Env 在 x, y 方向上的 grid 数量.
+func (e *Env) GridDims() (w, h int) {
+	return int(e.gridW), int(e.gridH)
+}
+
+// GridAt 返回第 (gx, gy) 个 grid, 未加载或越界时返回 nil.
+func (e *Env) GridAt(gx, gy int) *GridRBData {
+	if gx < 0 || gy < 0 || gx >= int(e.gridW) || gy >= int(e.gridH) {
+		return nil
+	}
+	return e.grids[gx+gy*int(e.gridW)]
+}
+
+// SetGrid 装入第 (gx, gy) 个 grid, 用于从文件加载地图. g 的 BaseX/BaseY 必须是该 grid
+// 左下角的全局坐标; 传 nil 则卸载. 不通知 ChangeListener, 应在构建派生数据之前调用.
+func (e *Env) SetGrid(gx, gy int, g *GridRBData) bool {
+	if gx < 0 || gy < 0 || gx >= int(e.gridW) || gy >= int(e.gridH) {
+		return false
+	}
+	if g != nil && (int(g.baseX) != int(e.minX)+gx*FastGridSetSize || int(g.baseY) != int(e.minY)+gy*FastGridSetSize) {
+		return false
+	}
+	e.grids[gx+gy*int(e.gridW)] = g
+	e.epoch++
+	return true
+}
+
+// GridCoordOf 返回 p 所在 grid 的坐标 (gx, gy), p 必须在 Env 内.
+func (e *Env) GridCoordOf(p Point2d) (gx, gy int, ok bool) {
+	if !e.Validate2d(p) {
+		return 0, 0, false
+	}
+	return int((p.X - e.minX) >> 5), int((p.Y - e.minY) >> 5), true
+}
+
 func (e *Env) Rect() Rect {
 	return e.rect
 }
@@ -521,11 +603,23 @@ func (e *Env) ApplyRichOperationsExt(addRangePoint, removeRangePoint []Point3d,
 	}
 	ok = true
 
+	// touched：成功修改过的 LP 点（去重），用于通知 ChangeListener；无监听者时不分配
+	changed := false
+	var touched map[Point2d]struct{}
+	if len(e.listeners) > 0 {
+		touched = make(map[Point2d]struct{}, len(addRangePoint)+len(removeRangePoint))
+	}
+
 	// 1) add
 	for _, p := range addRangePoint {
 		succ := e.addRangePoint(p, accessory)
 		if !succ {
 			ok = false
+			continue
+		}
+		changed = true
+		if touched != nil {
+			touched[p.Point2d().LowPrecisionPoint()] = struct{}{}
 		}
 	}
 
@@ -537,6 +631,10 @@ func (e *Env) ApplyRichOperationsExt(addRangePoint, removeRangePoint []Point3d,
 			ok = false
 			continue
 		}
+		changed = true
+		if touched != nil {
+			touched[p.Point2d().LowPrecisionPoint()] = struct{}{}
+		}
 		if isHeightPChange {
 			lpChanged[p.Point2d().LowPrecisionPoint()] = struct{}{}
 		}
@@ -547,6 +645,18 @@ func (e *Env) ApplyRichOperationsExt(addRangePoint, removeRangePoint []Point3d,
 		e.tryFoldHPToLPIfUniform(lp)
 	}
 
+	// 4) 通知监听者
+	if changed {
+		e.epoch++
+	}
+	if len(touched) > 0 {
+		lps := make([]Point2d, 0, len(touched))
+		for lp := range touched {
+			lps = append(lps, lp)
+		}
+		e.notifyChanged(lps)
+	}
+
 	return ok
 }
 
@@ -38,6 +38,40 @@ func (g *GridRBData) InitBase(base BaseStore) {
 	g.base = base
 }
 
+// GridStats grid 的 cell 与存储统计, 供工具排查内存使用.
+type GridStats struct {
+	HPCells      int // 带高精度数据的 cell 数
+	DirtyCells   int // LP root 已物化为 dirty 树的 cell 数
+	BaseSegments int // BaseStore 中的段数
+	BaseRanges   int // BaseStore 段池中的 RichRange 数 (含 header, 不含 0 号占位)
+	DirtyNodes   int // NodePool 中已分配的节点数 (含 free list)
+	FreeNodes    int // NodePool free list 上的节点数
+}
+
+// Stats 统计 grid 的 cell 与存储使用情况.
+func (g *GridRBData) Stats() (s GridStats) {
+	for i := range g.cells {
+		c := &g.cells[i]
+		if cellHasAnyHP(c) {
+			s.HPCells++
+		}
+		if IsDirtyEncodedRoot(c.RootNode) {
+			s.DirtyCells++
+		}
+	}
+	s.BaseSegments = len(g.base.rootCount)
+	if n := len(g.base.initRangeData); n > 0 {
+		s.BaseRanges = n - 1
+	}
+	if g.dirtyPool != nil {
+		s.DirtyNodes = len(g.dirtyPool.nodes)
+		for idx := g.dirtyPool.freeHead; idx != nilIdx && s.FreeNodes < s.DirtyNodes; idx = g.dirtyPool.nodes[idx].left {
+			s.FreeNodes++
+		}
+	}
+	return s
+}
+
 func (g *GridRBData) BaseX() uint16 { return g.baseX }
 func (g *GridRBData) BaseY() uint16 { return g.baseY }
 func (g *GridRBData) Ops() TreeOps  { return g.dirtyOps }
@@ -0,0 +1,305 @@
+package navgation
+
+import (
+	"container/list"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// PathCache remembers the paths found by a Planner for repeated queries,
+// such as patrols and town traffic. Entries are keyed by the start and goal
+// quantized to Quantum sub-cells and HeightQuantum height units, plus the
+// agent Filter, so a hit reuses the path of the first query of its bucket.
+// Its ends are joined to the asked start and goal by short searches (see
+// FindPath).
+//
+// Each entry records the 32m grids its footprints and moves touch, and edits
+// made through ApplyRichOperationsExt or the Planner's links drop only the
+// entries over the changed grids. An edit elsewhere can open a shorter route
+// without dropping the path, which stays walkable. Before a hit is returned
+// after any map change (including SetGrid), its moves are checked again
+// against the Env, one footprint stand each.
+//
+// Failed searches are not cached. A PathCache is not safe for concurrent use.
+type PathCache struct {
+	base *Planner
+	// Quantum is the start/goal bucket edge in sub-cells; 0 means
+	// zmap3base.SecondaryAccuracy (one LP cell).
+	Quantum int32
+	// HeightQuantum is the start/goal bucket height in 1/20m; 0 means 20 (1m).
+	HeightQuantum uint16
+	// MaxEntries bounds the cache, least recently used first out; 0 means
+	// unlimited.
+	MaxEntries int
+
+	entries map[pathCacheKey]*list.Element // of *pathCacheEntry
+	lru     list.List                      // most recently used first
+	byGrid  map[int]map[*pathCacheEntry]struct{}
+	stats   PathCacheStats
+
+	listenerID, linkID int
+}
+
+// PathCacheStats counts the lookups of a PathCache.
+type PathCacheStats struct {
+	Entries int
+	Hits    int64
+	Misses  int64
+	// Invalidated counts entries dropped by edits of their grids, Stale the
+	// hits that failed the re-validation and were searched again.
+	Invalidated int64
+	Stale       int64
+	Evicted     int64
+}
+
+type pathCacheKey struct {
+	sx, sy, gx, gy int32
+	sh, gh         uint16
+	filter         Filter
+}
+
+type pathCacheEntry struct {
+	key   pathCacheKey
+	res   PathResult
+	grids []int
+	epoch uint64 // Env epoch the path was last checked at
+}
+
+// NewPathCache returns an empty cache in front of base. Queries use every
+// setting of base except the Filter. Call Detach when the cache is no longer
+// used.
+func NewPathCache(base *Planner) *PathCache {
+	c := &PathCache{
+		base:    base,
+		entries: make(map[pathCacheKey]*list.Element),
+		byGrid:  make(map[int]map[*pathCacheEntry]struct{}),
+	}
+	c.listenerID = base.Env.AddChangeListener(c.Update)
+	if base.Links != nil {
+		c.linkID = base.Links.AddChangeListener(c.Update)
+	}
+	return c
+}
+
+// Detach stops listening to Env edits and link changes and drops every entry.
+func (c *PathCache) Detach() {
+	if c.listenerID != 0 {
+		c.base.Env.RemoveChangeListener(c.listenerID)
+		c.listenerID = 0
+	}
+	if c.linkID != 0 {
+		c.base.Links.RemoveChangeListener(c.linkID)
+		c.linkID = 0
+	}
+	c.Clear()
+}
+
+// Clear drops every entry and keeps the counters.
+func (c *PathCache) Clear() {
+	clear(c.entries)
+	clear(c.byGrid)
+	c.lru.Init()
+}
+
+// Stats returns the counters.
+func (c *PathCache) Stats() PathCacheStats {
+	st := c.stats
+	st.Entries = len(c.entries)
+	return st
+}
+
+// FindPath returns the cached path from start to goal for an agent with
+// filter f, or searches it with the base Planner and caches it on success.
+//
+// A hit starts and ends at start and goal as a search would resolve them:
+// the cached path is joined to them by searches of at most a few Quantum
+// sub-cells, and Cost and Expanded include the joins. The joined path can
+// be up to about 2*Quantum sub-cells longer than the optimal one. When a
+// join fails, FindPath searches the whole path again.
+func (c *PathCache) FindPath(start, goal zmap3base.Point3d, f Filter) (PathResult, bool) {
+	pl := *c.base
+	pl.Filter = f
+	key := c.key(start, goal, f)
+	if el, ok := c.entries[key]; ok {
+		e := el.Value.(*pathCacheEntry)
+		env := c.base.Env
+		if e.epoch == env.Epoch() || pathStillValid(env, e.res.Waypoints, f) {
+			e.epoch = env.Epoch()
+			c.lru.MoveToFront(el)
+			if res, ok := c.join(&pl, start, goal, e.res); ok {
+				c.stats.Hits++
+				return res, true
+			}
+		} else {
+			c.stats.Stale++
+			c.remove(e)
+		}
+	}
+	c.stats.Misses++
+
+	res, ok := pl.FindPath(start, goal)
+	if !ok || len(res.Waypoints) == 0 {
+		return res, ok
+	}
+	if _, cached := c.entries[key]; !cached {
+		c.insert(&pathCacheEntry{key: key, res: res, grids: pathGrids(pl.Env, res.Waypoints, f), epoch: pl.Env.Epoch()})
+	}
+	return res, true
+}
+
+// join links start and goal to the ends of the cached path cp.
+func (c *PathCache) join(pl *Planner, start, goal zmap3base.Point3d, cp PathResult) (PathResult, bool) {
+	wps := cp.Waypoints
+	first, last := wps[0].Point3d, wps[len(wps)-1].Point3d
+	q := c.quantum()
+	pl.MaxExpansions = int(64 * q * q)
+	head, ok := pl.FindPath(start, first)
+	if !ok {
+		return PathResult{}, false
+	}
+	tail, ok := pl.FindPath(last, goal)
+	if !ok {
+		return PathResult{}, false
+	}
+
+	res := cp
+	res.Waypoints = make([]Waypoint, 0, len(head.Waypoints)+len(wps)+len(tail.Waypoints)-2)
+	res.Waypoints = append(res.Waypoints, head.Waypoints...)
+	res.Waypoints = append(res.Waypoints, wps[1:]...)
+	res.Waypoints = append(res.Waypoints, tail.Waypoints[1:]...)
+	res.Cost = head.Cost + cp.Cost + tail.Cost
+	res.Expanded = head.Expanded + tail.Expanded
+	res.Start, res.StartRelocated = head.Start, head.StartRelocated
+	res.Goal, res.GoalRelocated = tail.Goal, tail.GoalRelocated
+	return res, true
+}
+
+// Update drops the entries whose paths touch the grids of the changed LP
+// points. It is called automatically for ApplyRichOperationsExt and link
+// changes.
+func (c *PathCache) Update(lps []zmap3base.Point2d) {
+	env := c.base.Env
+	w, _ := env.GridDims()
+	for _, lp := range lps {
+		gx, gy, ok := env.GridCoordOf(lp)
+		if !ok {
+			continue
+		}
+		for e := range c.byGrid[gx+gy*w] {
+			c.remove(e)
+			c.stats.Invalidated++
+		}
+	}
+}
+
+func (c *PathCache) quantum() int32 {
+	if c.Quantum <= 0 {
+		return zmap3base.SecondaryAccuracy
+	}
+	return c.Quantum
+}
+
+func (c *PathCache) key(start, goal zmap3base.Point3d, f Filter) pathCacheKey {
+	q := c.quantum()
+	hq := c.HeightQuantum
+	if hq == 0 {
+		hq = zmap3base.HeightScale
+	}
+	sx, sy := subCellOf(start.Point2d())
+	gx, gy := subCellOf(goal.Point2d())
+	return pathCacheKey{
+		sx: sx / q, sy: sy / q, gx: gx / q, gy: gy / q,
+		sh: start.H / hq, gh: goal.H / hq,
+		filter: f,
+	}
+}
+
+func (c *PathCache) insert(e *pathCacheEntry) {
+	if c.MaxEntries > 0 {
+		for len(c.entries) >= c.MaxEntries {
+			c.remove(c.lru.Back().Value.(*pathCacheEntry))
+			c.stats.Evicted++
+		}
+	}
+	c.entries[e.key] = c.lru.PushFront(e)
+	for _, g := range e.grids {
+		set := c.byGrid[g]
+		if set == nil {
+			set = make(map[*pathCacheEntry]struct{})
+			c.byGrid[g] = set
+		}
+		set[e] = struct{}{}
+	}
+}
+
+func (c *PathCache) remove(e *pathCacheEntry) {
+	el, ok := c.entries[e.key]
+	if !ok || el.Value != e {
+		return
+	}
+	c.lru.Remove(el)
+	delete(c.entries, e.key)
+	for _, g := range e.grids {
+		if set := c.byGrid[g]; set != nil {
+			delete(set, e)
+			if len(set) == 0 {
+				delete(c.byGrid, g)
+			}
+		}
+	}
+}
+
+// pathGrids returns the indices (gx + gy*w) of the grids the footprints of
+// path cover, with the one sub-cell margin the corner checks of a move read.
+func pathGrids(env *zmap3base.Env, path []Waypoint, f Filter) []int {
+	w, _ := env.GridDims()
+	n := f.footprint()
+	seen := make(map[int]struct{})
+	var grids []int
+	for _, wp := range path {
+		qx, qy := subCellOf(wp.Point2d())
+		for _, c := range [4][2]int32{{qx - 1, qy - 1}, {qx + n, qy - 1}, {qx - 1, qy + n}, {qx + n, qy + n}} {
+			p, ok := subCellPoint2d(c[0], c[1])
+			if !ok {
+				continue
+			}
+			gx, gy, ok := env.GridCoordOf(p)
+			if !ok {
+				continue
+			}
+			if _, dup := seen[gx+gy*w]; !dup {
+				seen[gx+gy*w] = struct{}{}
+				grids = append(grids, gx+gy*w)
+			}
+		}
+	}
+	return grids
+}
+
+// pathStillValid reports whether every waypoint of path is still reached by
+// the move that produced it: the same footprint stand from the previous floor
+// and, for diagonal steps, both orthogonal neighbours passable. Link landings
+// are checked from their own floor.
+func pathStillValid(env *zmap3base.Env, path []Waypoint, f Filter) bool {
+	for i, wp := range path {
+		qx, qy := subCellOf(wp.Point2d())
+		curY := int32(wp.H)
+		if i > 0 && wp.Link == LinkNone {
+			pqx, pqy := subCellOf(path[i-1].Point2d())
+			curY = int32(path[i-1].H)
+			if dx, dy := qx-pqx, qy-pqy; dx != 0 && dy != 0 {
+				if _, ok := footprintStand(env, pqx+dx, pqy, curY, f); !ok {
+					return false
+				}
+				if _, ok := footprintStand(env, pqx, pqy+dy, curY, f); !ok {
+					return false
+				}
+			}
+		}
+		st, ok := footprintStand(env, qx, qy, curY, f)
+		if !ok || st.h != wp.H {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,101 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestPathCache(t *testing.T) {
+	env := buildGridsEnv(t, 3, 1, func(x, y int) cellFixture {
+		return cellFixture{terrain: rr(0, 10, testTexBase)}
+	})
+	f := testFilter()
+	pl := NewPlanner(env, f)
+	c := NewPathCache(pl)
+	defer c.Detach()
+
+	wall := func(x uint16) []zmap3base.Point3d {
+		var ops []zmap3base.Point3d
+		for y := uint16(0); y < 20; y++ {
+			ops = append(ops, zmap3base.Point3d{X: x, Y: y, H: 10, RangeEnd: 60})
+		}
+		return ops
+	}
+	acc := zmap3base.Accessory{Texture: testTexObs}
+
+	// A stays in grid 0, B in grid 2.
+	aStart, aGoal := subPoint(8, 8, 10), subPoint(100, 8, 10)
+	bStart, bGoal := subPoint(300, 8, 10), subPoint(370, 8, 10)
+
+	first, ok := c.FindPath(aStart, aGoal, f)
+	if !ok {
+		t.Fatalf("no path")
+	}
+	// A hit starts and ends where the caller asked, joined to the cached path.
+	near, nearGoal := subPoint(10, 11, 12), subPoint(102, 9, 10)
+	again, ok := c.FindPath(near, nearGoal, f)
+	if !ok || c.Stats().Hits != 1 {
+		t.Fatalf("same bucket should hit the cache: %+v", c.Stats())
+	}
+	checkPathMoves(t, env, again.Waypoints, f)
+	sqx, sqy := subCellOf(again.Waypoints[0].Point2d())
+	gqx, gqy := subCellOf(again.Waypoints[len(again.Waypoints)-1].Point2d())
+	if sqx != 10 || sqy != 11 || gqx != 102 || gqy != 9 || again.Start != again.Waypoints[0].Point3d {
+		t.Fatalf("hit runs (%d,%d) -> (%d,%d), start %+v", sqx, sqy, gqx, gqy, again.Start)
+	}
+	fresh, _ := pl.FindPath(near, nearGoal)
+	if again.Cost < fresh.Cost || again.Cost > fresh.Cost+4*4*1.5 {
+		t.Fatalf("hit cost %v, fresh search %v", again.Cost, fresh.Cost)
+	}
+	if _, ok := c.FindPath(aStart, aGoal, NewFilter(0, 0, 22, 22, 100)); !ok {
+		t.Fatalf("no path for the second profile")
+	}
+	c.FindPath(bStart, bGoal, f)
+	if st := c.Stats(); st.Hits != 1 || st.Misses != 3 || st.Entries != 3 {
+		t.Fatalf("stats after lookups: %+v", st)
+	}
+
+	// An edit in grid 2 drops B only; A is re-validated and still served.
+	env.ApplyRichOperationsExt(wall(80), nil, acc)
+	if st := c.Stats(); st.Invalidated != 1 || st.Entries != 2 {
+		t.Fatalf("stats after the grid 2 edit: %+v", st)
+	}
+	if _, ok := c.FindPath(aStart, aGoal, f); !ok || c.Stats().Hits != 2 {
+		t.Fatalf("A should still hit: %+v", c.Stats())
+	}
+
+	// An edit the cache does not hear of is caught by the re-validation.
+	env.RemoveChangeListener(c.listenerID)
+	env.ApplyRichOperationsExt(wall(15), nil, acc)
+	got, ok := c.FindPath(aStart, aGoal, f)
+	if !ok {
+		t.Fatalf("no path around the wall")
+	}
+	if st := c.Stats(); st.Stale != 1 || st.Misses != 4 {
+		t.Fatalf("stats after the missed edit: %+v", st)
+	}
+	checkPathMoves(t, env, got.Waypoints, f)
+	want, _ := pl.FindPath(aStart, aGoal)
+	if !sameCost(got.Cost, want.Cost) || got.Cost <= first.Cost {
+		t.Fatalf("cost %v, fresh search %v, before the wall %v", got.Cost, want.Cost, first.Cost)
+	}
+}
+
+func TestPathCache_MaxEntries(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	f := testFilter()
+	c := NewPathCache(NewPlanner(env, f))
+	defer c.Detach()
+	c.MaxEntries = 2
+
+	a, b, d := subPoint(4, 4, 10), subPoint(60, 4, 10), subPoint(4, 60, 10)
+	c.FindPath(a, b, f)
+	c.FindPath(a, d, f)
+	c.FindPath(a, b, f) // a->d is now the least recently used
+	c.FindPath(b, d, f)
+	c.FindPath(a, b, f)
+	if st := c.Stats(); st.Entries != 2 || st.Evicted != 1 || st.Hits != 2 || st.Misses != 3 {
+		t.Fatalf("stats: %+v", st)
+	}
+}
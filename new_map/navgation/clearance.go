@@ -0,0 +1,247 @@
+package navgation
+
+import (
+	zmap3base "pathfinding/new_map"
+)
+
+// defaultClearanceDist is the clearance cap used when NewClearanceField gets 0.
+const defaultClearanceDist = 16
+
+// ClearanceField bakes, for every standable surface (a sub-column gap floor),
+// its distance in sub-cells to the nearest spot the agent cannot step onto at
+// that height: a wall, a ledge beyond the down limit, a forbidden floor or
+// the map edge. Distances are counted in 8-connected moves over the walkable
+// surfaces, so on open ground a value of d means the square of half-width
+// d-1 around the surface is clear. Values are capped at MaxDist.
+//
+// Surfaces are the single sub-column gaps of the filter; its footprint only
+// sets where ClearanceCost samples the field. The field is kept per
+// GridRBData and the grids around an ApplyRichOperationsExt edit are rebaked.
+// Create it before the planner structures (HPAGraph, FlowField) whose costs
+// read it, so its listener runs first. ClearanceField is not safe for
+// concurrent use with Env edits.
+type ClearanceField struct {
+	env     *zmap3base.Env
+	filter  Filter // single sub-column profile
+	size    int32  // footprint edge of the original filter
+	maxDist int32
+
+	gridW, gridH int
+	grids        []*clearanceGrid // nil for unloaded grids
+
+	listenerID int
+}
+
+// clearanceGrid holds the surfaces of one GridRBData in CSR form: the floors
+// of local sub-cell li are floors[offsets[li]:offsets[li+1]], ascending, and
+// dist holds their clearance.
+type clearanceGrid struct {
+	qx0, qy0 int32
+	offsets  []uint32
+	floors   []uint16
+	dist     []uint8
+}
+
+// NewClearanceField bakes every loaded grid of env for f. maxDist caps the
+// stored distance in sub-cells (0 means 16, at most 127).
+func NewClearanceField(env *zmap3base.Env, f Filter, maxDist int32) *ClearanceField {
+	if maxDist <= 0 {
+		maxDist = defaultClearanceDist
+	}
+	if maxDist > gridSideSub-1 {
+		maxDist = gridSideSub - 1
+	}
+	w, h := env.GridDims()
+	c := &ClearanceField{
+		env:     env,
+		filter:  f.WithFootprint(1),
+		size:    f.footprint(),
+		maxDist: maxDist,
+		gridW:   w,
+		gridH:   h,
+		grids:   make([]*clearanceGrid, w*h),
+	}
+	for gy := 0; gy < h; gy++ {
+		for gx := 0; gx < w; gx++ {
+			c.grids[gx+gy*w] = c.buildGrid(gx, gy)
+		}
+	}
+	c.listenerID = env.AddChangeListener(c.Update)
+	return c
+}
+
+// Detach stops listening to Env edits. Distances are frozen afterwards.
+func (c *ClearanceField) Detach() {
+	if c.listenerID != 0 {
+		c.env.RemoveChangeListener(c.listenerID)
+		c.listenerID = 0
+	}
+}
+
+// MaxDist returns the distance cap in sub-cells.
+func (c *ClearanceField) MaxDist() int32 { return c.maxDist }
+
+// Update rebakes the grids around the changed LP points; distances reach up
+// to MaxDist sub-cells into the neighbour grids. It is called automatically
+// for ApplyRichOperationsExt.
+func (c *ClearanceField) Update(lps []zmap3base.Point2d) {
+	dirty := make(map[int]struct{})
+	for _, p := range lps {
+		gx, gy, ok := c.env.GridCoordOf(p)
+		if !ok {
+			continue
+		}
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				x, y := gx+dx, gy+dy
+				if x >= 0 && y >= 0 && x < c.gridW && y < c.gridH {
+					dirty[x+y*c.gridW] = struct{}{}
+				}
+			}
+		}
+	}
+	for i := range dirty {
+		c.grids[i] = c.buildGrid(i%c.gridW, i/c.gridW)
+	}
+}
+
+// Clearance returns the clearance of the surface an agent standing at p
+// (p.H is its height) resolves to. ok is false when p has no valid stand.
+func (c *ClearanceField) Clearance(p zmap3base.Point3d) (d int32, ok bool) {
+	qx, qy := subCellOf(p.Point2d())
+	st, ok := footprintStand(c.env, qx, qy, int32(p.H), c.filter)
+	if !ok {
+		return 0, false
+	}
+	return c.at(qx, qy, st.h)
+}
+
+// at returns the clearance of the highest surface of sub-column (qx, qy) at
+// or below h.
+func (c *ClearanceField) at(qx, qy int32, h uint16) (int32, bool) {
+	gx, gy, ok := gridOfSubCell(c.env, qx, qy)
+	if !ok {
+		return 0, false
+	}
+	cg := c.grids[gx+gy*c.gridW]
+	if cg == nil {
+		return 0, false
+	}
+	li := (qx - cg.qx0) + (qy-cg.qy0)*gridSideSub
+	lo, hi := cg.offsets[li], cg.offsets[li+1]
+	for i := hi; i > lo; i-- {
+		if cg.floors[i-1] <= h {
+			return int32(cg.dist[i-1]), true
+		}
+	}
+	return 0, false
+}
+
+// buildGrid bakes grid (gx, gy). The distances are exact up to maxDist when
+// the search runs over a window maxDist sub-cells wider than the grid: no
+// shorter route to a blocked spot can leave it.
+func (c *ClearanceField) buildGrid(gx, gy int) *clearanceGrid {
+	if c.env.GridAt(gx, gy) == nil {
+		return nil
+	}
+	cg := &clearanceGrid{offsets: make([]uint32, gridSideSub*gridSideSub+1)}
+	cg.qx0, cg.qy0 = gridOrigin(c.env, gx, gy)
+	m := c.maxDist
+
+	// 1) surfaces of the window.
+	type surface struct {
+		qx, qy int32
+		h      uint16
+	}
+	var nodes []surface
+	index := make(map[uint64]int32)
+	for qy := cg.qy0 - m; qy < cg.qy0+gridSideSub+m; qy++ {
+		for qx := cg.qx0 - m; qx < cg.qx0+gridSideSub+m; qx++ {
+			forEachColumnGap(c.env, qx, qy, c.filter, func(g Gap) bool {
+				index[nodeKey(qx, qy, g.Floor())] = int32(len(nodes))
+				nodes = append(nodes, surface{qx: qx, qy: qy, h: g.Floor()})
+				return true
+			})
+		}
+	}
+
+	// 2) moves; surfaces with a blocked neighbour seed the search at 1.
+	dist := make([]uint8, len(nodes))
+	adj := make([][8]int32, len(nodes))
+	var queue []int32
+	for i, n := range nodes {
+		for d, dir := range stepDirs {
+			adj[i][d] = -1
+			st, ok := footprintStand(c.env, n.qx+dir.dx, n.qy+dir.dy, int32(n.h), c.filter)
+			if !ok {
+				if dist[i] == 0 {
+					dist[i] = 1
+					queue = append(queue, int32(i))
+				}
+				continue
+			}
+			if j, ok := index[nodeKey(n.qx+dir.dx, n.qy+dir.dy, st.h)]; ok {
+				adj[i][d] = j
+			}
+		}
+	}
+
+	// 3) breadth-first growth up to the cap.
+	for k := 0; k < len(queue); k++ {
+		i := queue[k]
+		if int32(dist[i]) >= m {
+			continue
+		}
+		for _, j := range adj[i] {
+			if j >= 0 && dist[j] == 0 {
+				dist[j] = dist[i] + 1
+				queue = append(queue, j)
+			}
+		}
+	}
+
+	// 4) keep the grid's own surfaces.
+	for ly := int32(0); ly < gridSideSub; ly++ {
+		for lx := int32(0); lx < gridSideSub; lx++ {
+			li := lx + ly*gridSideSub
+			forEachColumnGap(c.env, cg.qx0+lx, cg.qy0+ly, c.filter, func(g Gap) bool {
+				d := uint8(m)
+				if i, ok := index[nodeKey(cg.qx0+lx, cg.qy0+ly, g.Floor())]; ok && dist[i] != 0 {
+					d = dist[i]
+				}
+				cg.floors = append(cg.floors, g.Floor())
+				cg.dist = append(cg.dist, d)
+				return true
+			})
+			cg.offsets[li+1] = uint32(len(cg.floors))
+		}
+	}
+	return cg
+}
+
+// ClearanceCost adds a wall-hugging penalty on top of Inner (nil means the
+// plain distance): a step onto a surface whose clearance d is below Near
+// costs Penalty*(Near-d)*Dist more. The field is sampled at the footprint
+// centre. Penalty must be >= 0.
+type ClearanceCost struct {
+	Field   *ClearanceField
+	Inner   CostModel
+	Near    int32
+	Penalty float32
+}
+
+func (m *ClearanceCost) StepCost(s StepInfo) float32 {
+	c := s.Dist
+	if m.Inner != nil {
+		c = m.Inner.StepCost(s)
+	}
+	half := (m.Field.size - 1) / 2
+	if d, ok := m.Field.at(s.QX+half, s.QY+half, s.ToH); ok && d < m.Near {
+		c += m.Penalty * float32(m.Near-d) * s.Dist
+	}
+	return c
+}
+
+func (m *ClearanceCost) MinFactor() float32 { return heuristicScale(m.Inner) }
+
+func (m *ClearanceCost) UsesConfig() bool { return m.Inner != nil && m.Inner.UsesConfig() }
@@ -0,0 +1,117 @@
+package navgation
+
+import (
+	"math/rand"
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// wallCells returns flat ground with a wall along x=16.
+func wallCells() map[int]cellFixture {
+	cells := flatCells(10)
+	for y := 0; y < zmap3base.FastGridSetSize; y++ {
+		cells[cellIndex(16, y)] = cellFixture{terrain: rr(0, 100, testTexBase)}
+	}
+	return cells
+}
+
+func TestClearanceField_Distances(t *testing.T) {
+	env := buildSingleGridEnv(t, wallCells())
+	f := NewFilter(0, 0, 22, 22, 22)
+	cf := NewClearanceField(env, f, 0)
+	defer cf.Detach()
+	if cf.MaxDist() != 16 {
+		t.Fatalf("MaxDist=%d", cf.MaxDist())
+	}
+	for qx := int32(0); qx < 64; qx++ {
+		for _, qy := range []int32{0, 5, 60, 127} {
+			want := min(64-qx, qx+1, qy+1, 128-qy, 16)
+			got, ok := cf.Clearance(subPoint(qx, qy, 10))
+			if !ok || got != want {
+				t.Fatalf("(%d,%d): got %d ok=%v, want %d", qx, qy, got, ok, want)
+			}
+		}
+	}
+	// The wall top is a 1m wide surface bounded by drops beyond the limit.
+	if got, ok := cf.Clearance(subPoint(65, 60, 100)); !ok || got != 2 {
+		t.Fatalf("wall top: got %d ok=%v, want 2", got, ok)
+	}
+	if _, ok := cf.Clearance(subPoint(65, 60, 10)); ok {
+		t.Fatalf("found a stand inside the wall")
+	}
+}
+
+func TestClearanceField_IncrementalMatchesRebuild(t *testing.T) {
+	rnd := rand.New(rand.NewSource(42))
+	env := buildGridsEnv(t, 2, 2, func(x, y int) cellFixture {
+		switch rnd.Intn(12) {
+		case 0:
+			return cellFixture{terrain: rr(0, 60, testTexBase)}
+		case 1:
+			return cellFixture{terrain: rr(0, 20, testTexBase)}
+		case 2:
+			return cellFixture{terrain: rr(0, 10, testTexBase), lpPayload: []zmap3base.RichRange{rr(30, 50, testTexObs)}}
+		}
+		return cellFixture{terrain: rr(0, 10, testTexBase)}
+	})
+	f := NewFilter(0, 0, 22, 22, 30)
+	cf := NewClearanceField(env, f, 8)
+	defer cf.Detach()
+
+	for round := 0; round < 3; round++ {
+		var ops []zmap3base.Point3d
+		for i := 0; i < 6; i++ {
+			x, y := uint16(28+rnd.Intn(8)), uint16(rnd.Intn(64))
+			ops = append(ops, zmap3base.Point3d{X: x, Y: y, H: 10, RangeEnd: 40})
+		}
+		if !env.ApplyRichOperationsExt(ops, nil, zmap3base.Accessory{Texture: testTexObs}) {
+			t.Fatalf("ApplyRichOperationsExt failed")
+		}
+		fresh := NewClearanceField(env, f, 8)
+		fresh.Detach()
+		for i, want := range fresh.grids {
+			got := cf.grids[i]
+			if len(got.floors) != len(want.floors) {
+				t.Fatalf("round %d grid %d: %d surfaces, want %d", round, i, len(got.floors), len(want.floors))
+			}
+			for k := range want.dist {
+				if got.floors[k] != want.floors[k] || got.dist[k] != want.dist[k] {
+					t.Fatalf("round %d grid %d surface %d: got %d@%d, want %d@%d",
+						round, i, k, got.dist[k], got.floors[k], want.dist[k], want.floors[k])
+				}
+			}
+		}
+	}
+}
+
+func TestClearanceCost_KeepsAwayFromWalls(t *testing.T) {
+	env := buildSingleGridEnv(t, wallCells())
+	f := NewFilter(0, 0, 22, 22, 22)
+	cf := NewClearanceField(env, f, 0)
+	defer cf.Detach()
+
+	start, goal := subPoint(60, 4, 10), subPoint(60, 124, 10)
+	minClear := func(path []Waypoint) int32 {
+		m := int32(1 << 30)
+		for _, w := range path[len(path)/4 : 3*len(path)/4] {
+			d, _ := cf.Clearance(w.Point3d)
+			m = min(m, d)
+		}
+		return m
+	}
+	plain, ok := NewPlanner(env, f).FindPath(start, goal)
+	if !ok {
+		t.Fatalf("no plain path")
+	}
+	pl := NewPlanner(env, f)
+	pl.Cost = &ClearanceCost{Field: cf, Near: 8, Penalty: 1}
+	res, ok := pl.FindPath(start, goal)
+	if !ok {
+		t.Fatalf("no path with the clearance cost")
+	}
+	checkPathMoves(t, env, res.Waypoints, f)
+	if minClear(res.Waypoints) < 8 || minClear(plain.Waypoints) >= 8 {
+		t.Fatalf("clearance along the middle: priced %d, plain %d", minClear(res.Waypoints), minClear(plain.Waypoints))
+	}
+}
@@ -0,0 +1,153 @@
+package navgation
+
+import (
+	zmap3base "pathfinding/new_map"
+)
+
+// StepInfo describes one planner move to a CostModel.
+type StepInfo struct {
+	Dist       float32           // horizontal length in sub-cells: 1 or √2
+	FromH, ToH uint16            // floors before and after the move, 1/20m
+	Texture    zmap3base.Texture // floor texture after the move
+	// Config is the Accessory.Config of the floor span after the move. It is
+	// only looked up for models whose UsesConfig returns true.
+	Config uint32
+	// Mode is the locomotion after the move; always LocoWalk unless the
+	// filter swims.
+	Mode Locomotion
+	// QX, QY is the footprint reference sub-cell after the move
+	// (X*4 + Offset-1).
+	QX, QY int32
+}
+
+// CostModel prices the planner steps. Off-mesh links keep their own cost.
+//
+// StepCost must never be below MinFactor()*Dist: the planners scale the
+// octile heuristic by MinFactor, which keeps A* optimal while floors
+// cheaper than 1 (roads) pull paths toward them.
+type CostModel interface {
+	StepCost(s StepInfo) float32
+	MinFactor() float32
+	UsesConfig() bool
+}
+
+// TextureCost scales the steps onto floors whose texture has any bit of Mask.
+type TextureCost struct {
+	Mask   zmap3base.Texture
+	Factor float32
+}
+
+// TextureCostModel multiplies the step length by the factor of the first
+// matching entry of Textures (Base when none matches), then adds Climb per
+// 1/20m up, Descent per 1/20m down and ConfigCost of the floor's Config.
+// Use it to make agents prefer roads or avoid shallow water
+// (TexturePropWater) without forbidding them. All values must be >= 0.
+//
+// Wade and Swim, when non-zero, replace the texture factor of wading and
+// swimming steps.
+//
+// The visualizer's costM/costN settings map to Base = costM and
+// Climb = Descent = costN/5 (sub-cell units are 0.25m, heights 0.05m).
+type TextureCostModel struct {
+	Base           float32 // 0 means 1
+	Textures       []TextureCost
+	Climb, Descent float32
+	ConfigCost     map[uint32]float32
+	Wade, Swim     float32
+}
+
+func (m *TextureCostModel) base() float32 {
+	if m.Base == 0 {
+		return 1
+	}
+	return m.Base
+}
+
+func (m *TextureCostModel) StepCost(s StepInfo) float32 {
+	factor := m.base()
+	for _, tc := range m.Textures {
+		if s.Texture&tc.Mask != 0 {
+			factor = tc.Factor
+			break
+		}
+	}
+	switch {
+	case s.Mode == LocoWade && m.Wade != 0:
+		factor = m.Wade
+	case s.Mode == LocoSwim && m.Swim != 0:
+		factor = m.Swim
+	}
+	c := factor * s.Dist
+	if s.ToH > s.FromH {
+		c += m.Climb * float32(s.ToH-s.FromH)
+	} else {
+		c += m.Descent * float32(s.FromH-s.ToH)
+	}
+	if len(m.ConfigCost) > 0 {
+		c += m.ConfigCost[s.Config]
+	}
+	return c
+}
+
+func (m *TextureCostModel) MinFactor() float32 {
+	min := m.base()
+	for _, tc := range m.Textures {
+		if tc.Factor < min {
+			min = tc.Factor
+		}
+	}
+	for _, v := range []float32{m.Wade, m.Swim} {
+		if v != 0 && v < min {
+			min = v
+		}
+	}
+	return min
+}
+
+func (m *TextureCostModel) UsesConfig() bool { return len(m.ConfigCost) > 0 }
+
+// stepCost prices the move of length dist from floor fromH onto stand to at
+// (qx, qy). A nil model keeps the plain distance.
+func stepCost(m CostModel, env *zmap3base.Env, f Filter, dist float32, fromH uint16, qx, qy int32, to stand) float32 {
+	if m == nil {
+		return dist
+	}
+	s := StepInfo{Dist: dist, FromH: fromH, ToH: to.h, Texture: to.tex, QX: qx, QY: qy}
+	if f.swim {
+		s.Mode = locomotionAt(env, qx, qy, to, f)
+	}
+	if m.UsesConfig() {
+		s.Config = floorConfig(env, qx, qy, to.h, f)
+	}
+	return m.StepCost(s)
+}
+
+// floorConfig returns the Config of the span the footprint at (qx, qy)
+// stands on at floor h.
+func floorConfig(env *zmap3base.Env, qx, qy int32, h uint16, f Filter) uint32 {
+	n := f.footprint()
+	for dx := int32(0); dx < n; dx++ {
+		for dy := int32(0); dy < n; dy++ {
+			var cfg uint32
+			found := false
+			forEachColumnGap(env, qx+dx, qy+dy, f, func(g Gap) bool {
+				if g.Floor() == h {
+					cfg, found = g.Accessory.Config, true
+				}
+				return g.Floor() < h
+			})
+			if found {
+				return cfg
+			}
+		}
+	}
+	return 0
+}
+
+// heuristicScale is the factor the octile heuristic is scaled by.
+func heuristicScale(m CostModel) float32 {
+	if m == nil {
+		return 1
+	}
+	return m.MinFactor()
+}
@@ -0,0 +1,129 @@
+package navgation
+
+import (
+	"math/rand"
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// testTexRoad is an unused property bit standing in for a road material.
+const testTexRoad = zmap3base.TexturePropHuman << 1
+
+func testCostModel() *TextureCostModel {
+	return &TextureCostModel{
+		Textures: []TextureCost{
+			{Mask: testTexWater, Factor: 4},
+			{Mask: testTexRoad, Factor: 0.5},
+		},
+		Climb:      0.1,
+		Descent:    0.05,
+		ConfigCost: map[uint32]float32{9: 3},
+	}
+}
+
+func TestCostModel_AvoidsWaterWithoutForbiddingIt(t *testing.T) {
+	cells := flatCells(10)
+	// Shallow water across x=14..17, dry only at y >= 28. Far from the dry
+	// strip wading is cheaper than the detour.
+	for x := 14; x < 18; x++ {
+		for y := 0; y < 28; y++ {
+			cells[cellIndex(x, y)] = cellFixture{terrain: rr(0, 10, testTexBase|testTexWater)}
+		}
+	}
+	env := buildSingleGridEnv(t, cells)
+	f := testFilter()
+	pl := NewPlanner(env, f)
+	pl.Cost = testCostModel()
+
+	wet := func(path []Waypoint) int {
+		n := 0
+		for _, w := range path {
+			if w.Texture&testTexWater != 0 {
+				n++
+			}
+		}
+		return n
+	}
+	res, ok := pl.FindPath(subPoint(8, 8, 10), subPoint(120, 8, 10))
+	if !ok || wet(res.Waypoints) == 0 {
+		t.Fatalf("short crossing should wade: ok=%v wet=%d", ok, wet(res.Waypoints))
+	}
+	plain, _ := NewPlanner(env, f).FindPath(subPoint(8, 100, 10), subPoint(120, 100, 10))
+	res, ok = pl.FindPath(subPoint(8, 100, 10), subPoint(120, 100, 10))
+	if !ok || wet(res.Waypoints) != 0 {
+		t.Fatalf("path near the dry strip should avoid water: ok=%v wet=%d", ok, wet(res.Waypoints))
+	}
+	if wet(plain.Waypoints) == 0 {
+		t.Fatalf("without a model the straight line should cross the water")
+	}
+}
+
+func TestCostModel_OptimalAgainstFlowField(t *testing.T) {
+	rnd := rand.New(rand.NewSource(38))
+	cells := flatCells(10)
+	for i := 0; i < 160; i++ {
+		idx := cellIndex(rnd.Intn(32), rnd.Intn(32))
+		switch rnd.Intn(5) {
+		case 0:
+			cells[idx] = cellFixture{terrain: rr(0, 60, testTexBase)}
+		case 1:
+			cells[idx] = cellFixture{terrain: rr(0, 18, testTexBase)}
+		case 2:
+			cells[idx] = cellFixture{terrain: rr(0, 10, testTexBase|testTexWater)}
+		case 3:
+			cells[idx] = cellFixture{terrain: zmap3base.RichRange{
+				Range:     zmap3base.Range{Begin: 0, End: 10},
+				Accessory: zmap3base.Accessory{Texture: testTexBase, Config: 9},
+			}}
+		default:
+			cells[idx] = cellFixture{terrain: rr(0, 10, testTexBase|testTexRoad)}
+		}
+	}
+	env := buildSingleGridEnv(t, cells)
+	f := testFilter()
+	model := testCostModel()
+	goal := subPoint(64, 64, 10)
+	if _, ok := footprintStand(env, 64, 64, 10, f); !ok {
+		t.Skip("goal blocked")
+	}
+	ff, ok := NewFlowField(env, f, goal, zmap3base.Rect{})
+	if !ok {
+		t.Fatalf("NewFlowField failed")
+	}
+	defer ff.Detach()
+	ff.SetCostModel(model)
+
+	pl := NewPlanner(env, f)
+	pl.Cost = model
+	pl.JumpPoints = true // ignored with a model
+	for q := 0; q < 40; q++ {
+		start := subPoint(int32(rnd.Intn(124)), int32(rnd.Intn(124)), 10)
+		want, wantOK := ff.Cost(start)
+		got, gotOK := pl.FindPath(start, goal)
+		if gotOK != wantOK || (gotOK && !sameCost(got.Cost, want)) {
+			t.Fatalf("%+v: A* ok=%v cost=%v, field ok=%v cost=%v", start, gotOK, got.Cost, wantOK, want)
+		}
+	}
+}
+
+func TestTextureCostModel_StepCost(t *testing.T) {
+	m := testCostModel()
+	cases := []struct {
+		s    StepInfo
+		want float32
+	}{
+		{StepInfo{Dist: 1, FromH: 10, ToH: 10, Texture: testTexBase}, 1},
+		{StepInfo{Dist: sqrt2, FromH: 10, ToH: 10, Texture: testTexBase | testTexWater}, 4 * sqrt2},
+		{StepInfo{Dist: 1, FromH: 10, ToH: 20, Texture: testTexBase | testTexRoad}, 0.5 + 1},
+		{StepInfo{Dist: 1, FromH: 20, ToH: 10, Texture: testTexBase, Config: 9}, 1 + 0.5 + 3},
+	}
+	for _, c := range cases {
+		if got := m.StepCost(c.s); !sameCost(got, c.want) {
+			t.Fatalf("%+v: got %v, want %v", c.s, got, c.want)
+		}
+	}
+	if m.MinFactor() != 0.5 || !m.UsesConfig() {
+		t.Fatalf("MinFactor=%v UsesConfig=%v", m.MinFactor(), m.UsesConfig())
+	}
+}
@@ -0,0 +1,456 @@
+package navgation
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// DStarLite keeps the path of a moving agent to a fixed goal up to date
+// across Env edits (D* Lite, Koenig & Likhachev 2002). It searches backwards
+// from the goal over the planner states, so g is the cost to the goal and
+// survives agent moves. An edit reported by ApplyRichOperationsExt reopens
+// only the states whose moves it can change, and the next Path call repairs
+// the g/rhs values from there instead of searching again.
+//
+// Moves and costs are those of Planner.FindPath with the planner's Filter,
+// Cost and Links; jump points and Regions are not used. Link edits reopen
+// states like Env edits; set Links before NewDStarLite. MaxExpansions
+// bounds each Path call. A DStarLite is not safe for concurrent use.
+type DStarLite struct {
+	pl     *Planner
+	hscale float32
+
+	nodes       map[uint64]*dstarNode
+	open        dstarQueue
+	km          float32
+	start, goal *dstarNode
+	startSt     stand
+	goalSt      stand
+
+	// changed holds the sub-cells whose moves were changed by edits since
+	// the last Path call.
+	changed    map[uint64]struct{}
+	floors     []uint16
+	listenerID int
+
+	// shortcuts are the links cheaper than the distance they cover, and
+	// shortcutCost the cheapest of them; see heuristic. linksChanged
+	// reloads them in the next Path call.
+	shortcuts    []dstarShortcut
+	shortcutCost float32
+	linksID      int
+	linksChanged bool
+}
+
+type dstarShortcut struct {
+	fqx, fqy, tqx, tqy int32
+}
+
+type dstarNode struct {
+	qx, qy  int32
+	h       uint16
+	g, rhs  float32
+	k1, k2  float32
+	heapIdx int
+}
+
+var dstarInf = float32(math.Inf(1))
+
+// NewDStarLite resolves start and goal like FindPath and prepares a search
+// between them; nothing is searched until Path. Call Detach when done.
+func NewDStarLite(pl *Planner, start, goal zmap3base.Point3d) (*DStarLite, bool) {
+	if pl.Env == nil {
+		return nil, false
+	}
+	gqx, gqy := subCellOf(goal.Point2d())
+	gst, _, ok := pl.resolveEndpoint(&gqx, &gqy, int32(goal.H))
+	if !ok {
+		return nil, false
+	}
+	d := &DStarLite{
+		pl:      pl,
+		hscale:  heuristicScale(pl.Cost),
+		nodes:   make(map[uint64]*dstarNode),
+		changed: make(map[uint64]struct{}),
+		goalSt:  gst,
+	}
+	d.loadShortcuts()
+	d.goal = d.node(gqx, gqy, gst.h)
+	if !d.Move(start) {
+		return nil, false
+	}
+	d.goal.rhs = 0
+	d.updateVertex(d.goal)
+	d.listenerID = pl.Env.AddChangeListener(d.Update)
+	if pl.Links != nil {
+		d.linksID = pl.Links.AddChangeListener(d.onLinks)
+	}
+	return d, true
+}
+
+// Detach stops listening to Env and link edits. Later edits are not seen by
+// Path.
+func (d *DStarLite) Detach() {
+	if d.listenerID != 0 {
+		d.pl.Env.RemoveChangeListener(d.listenerID)
+		d.listenerID = 0
+	}
+	if d.linksID != 0 {
+		d.pl.Links.RemoveChangeListener(d.linksID)
+		d.linksID = 0
+	}
+}
+
+// Move sets the agent position, resolved like a FindPath start. It returns
+// false, keeping the previous position, when p has no valid stand.
+func (d *DStarLite) Move(p zmap3base.Point3d) bool {
+	qx, qy := subCellOf(p.Point2d())
+	st, _, ok := d.pl.resolveEndpoint(&qx, &qy, int32(p.H))
+	if !ok {
+		return false
+	}
+	d.setStart(qx, qy, st)
+	return true
+}
+
+func (d *DStarLite) setStart(qx, qy int32, st stand) {
+	if d.start != nil {
+		d.km += d.heuristic(d.start.qx, d.start.qy, qx, qy)
+	}
+	d.start, d.startSt = d.node(qx, qy, st.h), st
+}
+
+// Update records the sub-cells whose moves the changed LP points can affect:
+// the footprints covering them, the states stepping onto those and the
+// sources of the links landing on them. It is called automatically for
+// ApplyRichOperationsExt and link edits; the repair runs in the next Path
+// call.
+func (d *DStarLite) Update(lps []zmap3base.Point2d) {
+	n := d.pl.Filter.footprint()
+	links := d.pl.Links
+	for _, lp := range lps {
+		qx0, qy0 := int32(lp.X)*zmap3base.SecondaryAccuracy, int32(lp.Y)*zmap3base.SecondaryAccuracy
+		for qx := qx0 - n; qx <= qx0+zmap3base.SecondaryAccuracy; qx++ {
+			for qy := qy0 - n; qy <= qy0+zmap3base.SecondaryAccuracy; qy++ {
+				key := subKey(qx, qy)
+				d.changed[key] = struct{}{}
+				if links == nil {
+					continue
+				}
+				for _, id := range links.to[key] {
+					fqx, fqy := subCellOf(links.links[id].From.Point2d())
+					d.changed[subKey(fqx, fqy)] = struct{}{}
+				}
+			}
+		}
+	}
+}
+
+func (d *DStarLite) onLinks(lps []zmap3base.Point2d) {
+	d.linksChanged = true
+	d.Update(lps)
+}
+
+// loadShortcuts collects the shortcut links of the registry.
+func (d *DStarLite) loadShortcuts() {
+	d.shortcuts, d.shortcutCost = d.shortcuts[:0], dstarInf
+	if d.pl.Links == nil {
+		return
+	}
+	d.pl.Links.ForEach(func(_ LinkID, l Link) bool {
+		fqx, fqy := subCellOf(l.From.Point2d())
+		tqx, tqy := subCellOf(l.To.Point2d())
+		if c := l.cost(); c < d.hscale*octile(tqx-fqx, tqy-fqy) {
+			d.shortcuts = append(d.shortcuts, dstarShortcut{fqx, fqy, tqx, tqy})
+			d.shortcutCost = min(d.shortcutCost, c)
+		}
+		return true
+	})
+}
+
+// Path repairs the search after the edits and moves since the last call and
+// returns the path from the agent to the goal. Expanded counts the states
+// this call expanded.
+func (d *DStarLite) Path() (res PathResult, ok bool) {
+	env, f := d.pl.Env, d.pl.Filter
+	gst, ok := footprintStand(env, d.goal.qx, d.goal.qy, int32(d.goal.h), f)
+	if !ok || gst.h != d.goal.h {
+		return res, false
+	}
+	d.goalSt = gst
+	st, ok := footprintStand(env, d.start.qx, d.start.qy, int32(d.start.h), f)
+	if !ok {
+		return res, false
+	}
+	if st.h != d.start.h {
+		d.setStart(d.start.qx, d.start.qy, st)
+	}
+	d.startSt = st
+	res.Start = point3dAt(d.start.qx, d.start.qy, d.startSt.gap())
+	res.Goal = point3dAt(d.goal.qx, d.goal.qy, d.goalSt.gap())
+
+	if d.linksChanged {
+		// New shortcuts lower the heuristic, so every key is recomputed.
+		d.linksChanged = false
+		d.loadShortcuts()
+		for _, n := range d.open {
+			n.k1, n.k2 = d.key(n)
+		}
+		heap.Init(&d.open)
+	}
+	d.applyChanges()
+	res.Expanded, ok = d.computeShortestPath()
+	if !ok || d.start.rhs == dstarInf {
+		return res, false
+	}
+
+	res.Waypoints = append(res.Waypoints, waypointAt(env, f, d.start.qx, d.start.qy, d.startSt, LinkNone))
+	for cur := d.start; cur != d.goal; {
+		if len(res.Waypoints) > len(d.nodes) {
+			return res, false
+		}
+		var next *dstarNode
+		var nst stand
+		var lt LinkType
+		best, step := dstarInf, float32(0)
+		forEachStep(env, cur.qx, cur.qy, int32(cur.h), f, func(nx, ny int32, st stand, dist float32) {
+			m := d.nodes[nodeKey(nx, ny, st.h)]
+			if m == nil || m.g == dstarInf {
+				return
+			}
+			c := stepCost(d.pl.Cost, env, f, dist, cur.h, nx, ny, st)
+			if c+m.g < best {
+				best, step, next, nst, lt = c+m.g, c, m, st, LinkNone
+			}
+		})
+		forEachLinkFrom(d.pl.Links, env, cur.qx, cur.qy, cur.h, f, func(_ LinkID, l *Link, tqx, tqy int32, st stand) {
+			m := d.nodes[nodeKey(tqx, tqy, st.h)]
+			if m == nil || m.g == dstarInf {
+				return
+			}
+			if c := l.cost(); c+m.g < best {
+				best, step, next, nst, lt = c+m.g, c, m, st, l.Type
+			}
+		})
+		if next == nil {
+			return res, false
+		}
+		res.Cost += step
+		res.Waypoints = append(res.Waypoints, waypointAt(env, f, next.qx, next.qy, nst, lt))
+		cur = next
+	}
+	return res, true
+}
+
+// computeShortestPath is the main loop of optimized D* Lite. ok is false
+// when MaxExpansions stopped it; the next call resumes.
+func (d *DStarLite) computeShortestPath() (expanded int, ok bool) {
+	for d.open.Len() > 0 {
+		u := d.open[0]
+		// A state whose k1 ties the start's can still lead to it, with a
+		// smaller k2. Rounding may put that k1 just above the start's, so
+		// stop only once k1 is clearly above it.
+		sk1, _ := d.key(d.start)
+		if u.k1 > sk1+1e-5*max(1, sk1) && d.start.rhs <= d.start.g {
+			break
+		}
+		if max := d.pl.MaxExpansions; max > 0 && expanded >= max {
+			return expanded, false
+		}
+		if k1, k2 := d.key(u); keyLess(u.k1, u.k2, k1, k2) {
+			u.k1, u.k2 = k1, k2
+			heap.Fix(&d.open, 0)
+			continue
+		}
+		expanded++
+		if u.g > u.rhs {
+			u.g = u.rhs
+			heap.Remove(&d.open, 0)
+			d.forEachPred(u, true, func(p *dstarNode, c float32) {
+				if p != d.goal && c+u.g < p.rhs {
+					p.rhs = c + u.g
+					d.updateVertex(p)
+				}
+			})
+			continue
+		}
+		old := u.g
+		u.g = dstarInf
+		d.forEachPred(u, false, func(p *dstarNode, c float32) {
+			if p != d.goal && p.rhs == c+old {
+				p.rhs = d.minSucc(p)
+				d.updateVertex(p)
+			}
+		})
+		d.updateVertex(u)
+	}
+	return expanded, true
+}
+
+// applyChanges recomputes rhs for the known and the new states of the
+// changed sub-cells.
+func (d *DStarLite) applyChanges() {
+	if len(d.changed) == 0 {
+		return
+	}
+	for _, n := range d.nodes {
+		if _, ok := d.changed[subKey(n.qx, n.qy)]; ok && n != d.goal {
+			n.rhs = d.minSucc(n)
+			d.updateVertex(n)
+		}
+	}
+	for key := range d.changed {
+		qx, qy := int32(uint32(key>>32)), int32(uint32(key))
+		for _, h := range d.floorsAt(qx, qy) {
+			if d.nodes[nodeKey(qx, qy, h)] != nil {
+				continue
+			}
+			n := &dstarNode{qx: qx, qy: qy, h: h, g: dstarInf, heapIdx: -1}
+			if n.rhs = d.minSucc(n); n.rhs < dstarInf {
+				d.nodes[nodeKey(qx, qy, h)] = n
+				d.updateVertex(n)
+			}
+		}
+	}
+	clear(d.changed)
+}
+
+func (d *DStarLite) key(n *dstarNode) (k1, k2 float32) {
+	m := min(n.g, n.rhs)
+	return m + d.heuristic(d.start.qx, d.start.qy, n.qx, n.qy) + d.km, m
+}
+
+// heuristic is a consistent lower bound of the cost from a to b. Without
+// shortcuts it is the scaled octile distance. A path taking a shortcut
+// costs at least the distance to the nearest shortcut source, the cheapest
+// shortcut and the distance from the nearest landing, so the bound is the
+// smaller of the two. Unlike the planner's linkBounds it does not depend on
+// a fixed target, which here is the moving agent.
+func (d *DStarLite) heuristic(ax, ay, bx, by int32) float32 {
+	h := d.hscale * octile(bx-ax, by-ay)
+	if len(d.shortcuts) == 0 {
+		return h
+	}
+	from, to := dstarInf, dstarInf
+	for _, s := range d.shortcuts {
+		from = min(from, octile(s.fqx-ax, s.fqy-ay))
+		to = min(to, octile(bx-s.tqx, by-s.tqy))
+	}
+	return min(h, d.hscale*(from+to)+d.shortcutCost)
+}
+
+func keyLess(a1, a2, b1, b2 float32) bool {
+	return a1 < b1 || a1 == b1 && a2 < b2
+}
+
+func (d *DStarLite) updateVertex(n *dstarNode) {
+	switch {
+	case n.g != n.rhs && n.heapIdx >= 0:
+		n.k1, n.k2 = d.key(n)
+		heap.Fix(&d.open, n.heapIdx)
+	case n.g != n.rhs:
+		n.k1, n.k2 = d.key(n)
+		heap.Push(&d.open, n)
+	case n.heapIdx >= 0:
+		heap.Remove(&d.open, n.heapIdx)
+	}
+}
+
+// node returns the state (qx, qy, h), added with g = rhs = inf if new.
+func (d *DStarLite) node(qx, qy int32, h uint16) *dstarNode {
+	key := nodeKey(qx, qy, h)
+	n := d.nodes[key]
+	if n == nil {
+		n = &dstarNode{qx: qx, qy: qy, h: h, g: dstarInf, rhs: dstarInf, heapIdx: -1}
+		d.nodes[key] = n
+	}
+	return n
+}
+
+// minSucc returns the best cost to the goal through a move or link from n.
+func (d *DStarLite) minSucc(n *dstarNode) float32 {
+	env, f := d.pl.Env, d.pl.Filter
+	best := dstarInf
+	forEachStep(env, n.qx, n.qy, int32(n.h), f, func(nx, ny int32, st stand, dist float32) {
+		if m := d.nodes[nodeKey(nx, ny, st.h)]; m != nil && m.g < dstarInf {
+			best = min(best, stepCost(d.pl.Cost, env, f, dist, n.h, nx, ny, st)+m.g)
+		}
+	})
+	forEachLinkFrom(d.pl.Links, env, n.qx, n.qy, n.h, f, func(_ LinkID, l *Link, tqx, tqy int32, st stand) {
+		if m := d.nodes[nodeKey(tqx, tqy, st.h)]; m != nil && m.g < dstarInf {
+			best = min(best, l.cost()+m.g)
+		}
+	})
+	return best
+}
+
+// forEachPred visits the states with a move or link onto u and the cost of
+// that move. Unknown states are added when create is set and skipped
+// otherwise.
+func (d *DStarLite) forEachPred(u *dstarNode, create bool, visit func(p *dstarNode, c float32)) {
+	env, f := d.pl.Env, d.pl.Filter
+	for dir := range stepDirs {
+		step := stepDirs[dir]
+		px, py := u.qx-step.dx, u.qy-step.dy
+		for _, h := range d.floorsAt(px, py) {
+			st, ok := stepFrom(env, px, py, int32(h), dir, f)
+			if !ok || st.h != u.h {
+				continue
+			}
+			p := d.nodes[nodeKey(px, py, h)]
+			if p == nil {
+				if !create {
+					continue
+				}
+				p = d.node(px, py, h)
+			}
+			visit(p, stepCost(d.pl.Cost, env, f, step.cost, h, u.qx, u.qy, st))
+		}
+	}
+	forEachLinkTo(d.pl.Links, env, u.qx, u.qy, u.h, f, func(_ LinkID, l *Link, fqx, fqy int32, from stand) {
+		p := d.nodes[nodeKey(fqx, fqy, from.h)]
+		if p == nil {
+			if !create {
+				return
+			}
+			p = d.node(fqx, fqy, from.h)
+		}
+		visit(p, l.cost())
+	})
+}
+
+// floorsAt returns the distinct candidate floors of the footprint at
+// (qx, qy). The slice is reused by the next call.
+func (d *DStarLite) floorsAt(qx, qy int32) []uint16 {
+	fl := appendFootprintFloors(d.floors[:0], d.pl.Env, qx, qy, d.pl.Filter)
+	sort.Slice(fl, func(i, j int) bool { return fl[i] < fl[j] })
+	d.floors = fl
+	return fl[:dedupeSorted(fl)]
+}
+
+// dstarQueue is a binary heap of states ordered by (k1, k2).
+type dstarQueue []*dstarNode
+
+func (q dstarQueue) Len() int { return len(q) }
+func (q dstarQueue) Less(i, j int) bool {
+	return keyLess(q[i].k1, q[i].k2, q[j].k1, q[j].k2)
+}
+func (q dstarQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].heapIdx, q[j].heapIdx = i, j
+}
+func (q *dstarQueue) Push(x any) {
+	n := x.(*dstarNode)
+	n.heapIdx = len(*q)
+	*q = append(*q, n)
+}
+func (q *dstarQueue) Pop() any {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	n.heapIdx = -1
+	return n
+}
@@ -0,0 +1,228 @@
+package navgation
+
+import (
+	"math/rand"
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestDStarLite_MatchesAStarAfterRandomEdits(t *testing.T) {
+	for seed := 1; seed <= 8; seed++ {
+		testDStarLiteRandomEdits(t, seed, false)
+	}
+}
+
+func TestDStarLite_MatchesAStarAfterRandomLinkEdits(t *testing.T) {
+	for seed := 1; seed <= 8; seed++ {
+		testDStarLiteRandomEdits(t, seed, true)
+	}
+}
+
+// testDStarLiteRandomEdits walks an agent to the goal while walls, and with
+// withLinks also doors and teleports, come and go.
+func testDStarLiteRandomEdits(t *testing.T, seed int, withLinks bool) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	f := testFilter()
+	pl := NewPlanner(env, f)
+	if withLinks {
+		pl.Links = NewLinkRegistry()
+	}
+	start, goal := subPoint(4, 4, 10), subPoint(116, 116, 10)
+	d, ok := NewDStarLite(pl, start, goal)
+	if !ok {
+		t.Fatalf("NewDStarLite failed")
+	}
+	defer d.Detach()
+
+	rng := rand.New(rand.NewSource(int64(seed)))
+	acc := zmap3base.Accessory{Texture: testTexObs}
+	var walls []zmap3base.Point3d
+	var linkIDs []LinkID
+	pos := start
+	for round := 0; round < 60; round++ {
+		got, gotOK := d.Path()
+		fresh := NewPlanner(env, f)
+		fresh.Links = pl.Links
+		want, wantOK := fresh.FindPath(pos, goal)
+		if gotOK != wantOK {
+			t.Fatalf("seed %d round %d: D* Lite ok=%v, A* ok=%v", seed, round, gotOK, wantOK)
+		}
+		if gotOK {
+			if !sameCost(got.Cost, want.Cost) {
+				t.Fatalf("seed %d round %d: D* Lite cost %v, A* cost %v", seed, round, got.Cost, want.Cost)
+			}
+			checkLinkPath(t, env, got.Waypoints, f)
+			// Walk a few steps along the path.
+			if k := min(len(got.Waypoints)-1, 3); k > 0 {
+				pos = got.Waypoints[k].Point3d
+				if !d.Move(pos) {
+					t.Fatalf("seed %d round %d: cannot move to %+v", seed, round, pos)
+				}
+			}
+		}
+
+		if withLinks && rng.Intn(2) == 0 {
+			if len(linkIDs) > 0 && rng.Intn(3) == 0 {
+				i := rng.Intn(len(linkIDs))
+				pl.Links.Remove(linkIDs[i])
+				linkIDs = append(linkIDs[:i], linkIDs[i+1:]...)
+				continue
+			}
+			l := Link{
+				From: subPoint(int32(4+rng.Intn(112)), int32(4+rng.Intn(112)), 10),
+				To:   subPoint(int32(4+rng.Intn(112)), int32(4+rng.Intn(112)), 10),
+				Type: LinkDoor,
+			}
+			if rng.Intn(2) == 0 {
+				l.Type, l.Cost = LinkTeleport, float32(1+rng.Intn(20))
+			}
+			linkIDs = append(linkIDs, pl.Links.Add(l))
+			continue
+		}
+
+		// Build a short wall or knock one down, away from the agent and goal.
+		if len(walls) > 0 && rng.Intn(3) == 0 {
+			i := rng.Intn(len(walls))
+			env.ApplyRichOperationsExt(nil, walls[i:i+1], acc)
+			walls = append(walls[:i], walls[i+1:]...)
+			continue
+		}
+		x, y := uint16(2+rng.Intn(26)), uint16(2+rng.Intn(26))
+		if rng.Intn(2) == 0 {
+			// Across the remaining route.
+			px, py := subCellOf(pos.Point2d())
+			x, y = uint16((px/4+29)/2)-2+uint16(rng.Intn(4)), uint16((py/4+29)/2)-2+uint16(rng.Intn(4))
+		}
+		var ops []zmap3base.Point3d
+		for i := uint16(0); i < 6; i++ {
+			wx, wy := x, y+i
+			if rng.Intn(2) == 0 {
+				wx, wy = x+i, y
+			}
+			if wx > 28 || wy > 28 {
+				continue
+			}
+			px, py := subCellOf(pos.Point2d())
+			if int32(wx)*4+4 >= px-4 && int32(wx)*4 <= px+8 && int32(wy)*4+4 >= py-4 && int32(wy)*4 <= py+8 {
+				continue
+			}
+			ops = append(ops, zmap3base.Point3d{X: wx, Y: wy, H: 10, RangeEnd: 60})
+		}
+		if env.ApplyRichOperationsExt(ops, nil, acc) {
+			walls = append(walls, ops...)
+		}
+	}
+}
+
+func TestDStarLite_Repair(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	pl := NewPlanner(env, testFilter())
+	start, goal := subPoint(4, 8, 10), subPoint(60, 8, 10)
+	d, ok := NewDStarLite(pl, start, goal)
+	if !ok {
+		t.Fatalf("NewDStarLite failed")
+	}
+	defer d.Detach()
+
+	first, ok := d.Path()
+	if !ok || first.Expanded == 0 {
+		t.Fatalf("first path: ok=%v expanded=%d", ok, first.Expanded)
+	}
+	if again, _ := d.Path(); again.Expanded != 0 || again.Cost != first.Cost {
+		t.Fatalf("unchanged map: expanded %d cost %v", again.Expanded, again.Cost)
+	}
+
+	// An edit far from every searched state costs nothing.
+	env.ApplyRichOperationsExt([]zmap3base.Point3d{{X: 28, Y: 28, H: 10, RangeEnd: 60}}, nil, zmap3base.Accessory{Texture: testTexObs})
+	if far, _ := d.Path(); far.Expanded != 0 || far.Cost != first.Cost {
+		t.Fatalf("far edit: expanded %d cost %v", far.Expanded, far.Cost)
+	}
+
+	// A wall across the route is repaired.
+	var ops []zmap3base.Point3d
+	for y := uint16(0); y < 6; y++ {
+		ops = append(ops, zmap3base.Point3d{X: 8, Y: y, H: 10, RangeEnd: 60})
+	}
+	env.ApplyRichOperationsExt(ops, nil, zmap3base.Accessory{Texture: testTexObs})
+	got, ok := d.Path()
+	want, _ := NewPlanner(env, testFilter()).FindPath(start, goal)
+	if !ok || !sameCost(got.Cost, want.Cost) || got.Cost <= first.Cost {
+		t.Fatalf("after the wall: ok=%v cost %v, A* %v, before %v", ok, got.Cost, want.Cost, first.Cost)
+	}
+
+	// Walling the goal in and opening the ring again.
+	var ring []zmap3base.Point3d
+	for x := uint16(13); x <= 17; x++ {
+		for y := uint16(0); y <= 4; y++ {
+			if x == 13 || x == 17 || y == 0 || y == 4 {
+				ring = append(ring, zmap3base.Point3d{X: x, Y: y, H: 10, RangeEnd: 60})
+			}
+		}
+	}
+	env.ApplyRichOperationsExt(ring, nil, zmap3base.Accessory{Texture: testTexObs})
+	if _, ok := d.Path(); ok {
+		t.Fatalf("expected no path into the closed ring")
+	}
+	env.ApplyRichOperationsExt(nil, ring[len(ring)-3:len(ring)-2], zmap3base.Accessory{Texture: testTexObs})
+	got, ok = d.Path()
+	want, wantOK := NewPlanner(env, testFilter()).FindPath(start, goal)
+	if !ok || !wantOK || !sameCost(got.Cost, want.Cost) {
+		t.Fatalf("after opening the ring: ok=%v cost %v, A* ok=%v cost %v", ok, got.Cost, wantOK, want.Cost)
+	}
+}
+
+func TestDStarLite_Links(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	f := testFilter()
+	pl := NewPlanner(env, f)
+	links := NewLinkRegistry()
+	pl.Links = links
+	acc := zmap3base.Accessory{Texture: testTexObs}
+
+	// A wall along LP x=15 splits the grid; only links cross it.
+	var wall []zmap3base.Point3d
+	for y := uint16(0); y < 32; y++ {
+		wall = append(wall, zmap3base.Point3d{X: 15, Y: y, H: 10, RangeEnd: 60})
+	}
+	env.ApplyRichOperationsExt(wall, nil, acc)
+	start, goal := subPoint(4, 8, 10), subPoint(116, 100, 10)
+	d, ok := NewDStarLite(pl, start, goal)
+	if !ok {
+		t.Fatalf("NewDStarLite failed")
+	}
+	defer d.Detach()
+
+	check := func(step string, wantOK bool, lt LinkType) {
+		t.Helper()
+		got, gotOK := d.Path()
+		want, aOK := pl.FindPath(start, goal)
+		if gotOK != wantOK || aOK != wantOK {
+			t.Fatalf("%s: D* Lite ok=%v, A* ok=%v, want %v", step, gotOK, aOK, wantOK)
+		}
+		if !wantOK {
+			return
+		}
+		if !sameCost(got.Cost, want.Cost) || countLinks(got.Waypoints, lt) != 1 {
+			t.Fatalf("%s: D* Lite cost %v with %d %v links, A* cost %v", step, got.Cost, countLinks(got.Waypoints, lt), lt, want.Cost)
+		}
+		checkLinkPath(t, env, got.Waypoints, f)
+	}
+	check("no link", false, LinkNone)
+	door := links.Add(Link{From: subPoint(56, 60, 10), To: subPoint(66, 60, 10), Type: LinkDoor})
+	check("door", true, LinkDoor)
+	tp := links.Add(Link{From: subPoint(8, 12, 10), To: subPoint(112, 96, 10), Type: LinkTeleport, Cost: 4})
+	check("teleport", true, LinkTeleport)
+
+	// A block on the teleport landing makes the door the only way again.
+	block := []zmap3base.Point3d{{X: 28, Y: 24, H: 10, RangeEnd: 60}}
+	env.ApplyRichOperationsExt(block, nil, acc)
+	check("landing blocked", true, LinkDoor)
+	env.ApplyRichOperationsExt(nil, block, acc)
+	check("landing cleared", true, LinkTeleport)
+
+	links.Remove(tp)
+	check("teleport removed", true, LinkDoor)
+	links.Remove(door)
+	check("door removed", false, LinkNone)
+}
@@ -0,0 +1,344 @@
+package navgation
+
+import (
+	"container/heap"
+	"math"
+	"sort"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// FlowField is a Dijkstra integration field toward one goal over the
+// standable surfaces of an Env region, plus the best next step of every
+// node. Nodes are the planner states (footprint sub-cell and floor), so
+// agents on any floor look up their own step. Cost and Next are O(1).
+//
+// Off-mesh links set with SetLinks are followed like steps and SetCostModel
+// prices the steps.
+//
+// Edits inside the region, one LP cell before it or as far past it as the
+// footprints of its last sub-cells reach, mark the field stale;
+// it is recomputed on the next lookup or by Refresh. FlowField is not safe
+// for concurrent use with Env edits.
+type FlowField struct {
+	env    *zmap3base.Env
+	filter Filter
+	region zmap3base.Rect
+
+	goalQX, goalQY int32
+	goalH          uint16
+
+	qx0, qy0, w, h int32 // region in sub-cells
+
+	// CSR nodes: floors of sub-cell li are floors[offsets[li]:offsets[li+1]].
+	offsets []uint32
+	floors  []uint16
+	cost    []float32 // to the goal, +Inf when unreachable
+	dir     []int8    // index into stepDirs, -1 at the goal or when unreachable
+	via     []LinkID  // link taking the best next step, -1 for a step
+
+	links  *LinkRegistry
+	linkID int
+	model  CostModel
+
+	stale      bool
+	listenerID int
+}
+
+// NewFlowField builds the field toward goal (goal.H is the standing height)
+// over region, in LP cells. An empty region means the whole Env. ok is false
+// when the goal has no valid stand.
+func NewFlowField(env *zmap3base.Env, f Filter, goal zmap3base.Point3d, region zmap3base.Rect) (ff *FlowField, ok bool) {
+	if region.Width() == 0 || region.Height() == 0 {
+		region = env.Rect()
+	}
+	gqx, gqy := subCellOf(goal.Point2d())
+	st, ok := footprintStand(env, gqx, gqy, int32(goal.H), f)
+	if !ok {
+		return nil, false
+	}
+	ff = &FlowField{
+		env:    env,
+		filter: f,
+		region: region,
+		goalQX: gqx,
+		goalQY: gqy,
+		goalH:  st.h,
+		qx0:    int32(region.Min.X) * zmap3base.SecondaryAccuracy,
+		qy0:    int32(region.Min.Y) * zmap3base.SecondaryAccuracy,
+		w:      int32(region.Width()) * zmap3base.SecondaryAccuracy,
+		h:      int32(region.Height()) * zmap3base.SecondaryAccuracy,
+	}
+	if !ff.contains(gqx, gqy) {
+		return nil, false
+	}
+	ff.build()
+	ff.listenerID = env.AddChangeListener(ff.Update)
+	return ff, true
+}
+
+// Detach stops listening to Env edits and link changes.
+func (ff *FlowField) Detach() {
+	if ff.listenerID != 0 {
+		ff.env.RemoveChangeListener(ff.listenerID)
+		ff.listenerID = 0
+	}
+	if ff.linkID != 0 {
+		ff.links.RemoveChangeListener(ff.linkID)
+		ff.linkID = 0
+	}
+}
+
+// SetLinks makes the field follow the off-mesh links of r (nil for none) and
+// marks it stale.
+func (ff *FlowField) SetLinks(r *LinkRegistry) {
+	if ff.linkID != 0 {
+		ff.links.RemoveChangeListener(ff.linkID)
+		ff.linkID = 0
+	}
+	ff.links = r
+	if r != nil {
+		ff.linkID = r.AddChangeListener(ff.Update)
+	}
+	ff.stale = true
+}
+
+// SetCostModel prices the steps with m (nil for plain distances) and marks
+// the field stale.
+func (ff *FlowField) SetCostModel(m CostModel) {
+	ff.model = m
+	ff.stale = true
+}
+
+// Update marks the field stale when a changed LP point can affect it.
+// It is called automatically for ApplyRichOperationsExt and link changes.
+func (ff *FlowField) Update(lps []zmap3base.Point2d) {
+	r := ff.region
+	// A footprint covers its reference sub-cell and n-1 more toward Max,
+	// and diagonal moves read one sub-cell on each side of it: one LP cell
+	// before Min, up to (n-1)/SecondaryAccuracy+1 after the last cell.
+	reach := (ff.filter.footprint()-1)/zmap3base.SecondaryAccuracy + 1
+	for _, p := range lps {
+		if int32(p.X)+1 >= int32(r.Min.X) && int32(p.X) < int32(r.Max.X)+reach &&
+			int32(p.Y)+1 >= int32(r.Min.Y) && int32(p.Y) < int32(r.Max.Y)+reach {
+			ff.stale = true
+			return
+		}
+	}
+}
+
+// Stale reports whether an edit invalidated the field since the last build.
+func (ff *FlowField) Stale() bool { return ff.stale }
+
+// Refresh recomputes a stale field.
+func (ff *FlowField) Refresh() {
+	if ff.stale {
+		ff.build()
+	}
+}
+
+// Cost returns the path cost, in sub-cell units, from an agent standing at p
+// to the goal.
+func (ff *FlowField) Cost(p zmap3base.Point3d) (float32, bool) {
+	i, ok := ff.lookup(p)
+	if !ok || math.IsInf(float64(ff.cost[i]), 1) {
+		return 0, false
+	}
+	return ff.cost[i], true
+}
+
+// Next returns the next waypoint of an agent standing at p. ok is false at
+// the goal and where the goal is unreachable.
+func (ff *FlowField) Next(p zmap3base.Point3d) (w Waypoint, ok bool) {
+	i, ok := ff.lookup(p)
+	if !ok {
+		return Waypoint{}, false
+	}
+	if id := ff.via[i]; id >= 0 {
+		l, _ := ff.links.Get(id)
+		tqx, tqy := subCellOf(l.To.Point2d())
+		st, ok := footprintStand(ff.env, tqx, tqy, int32(l.To.H), ff.filter)
+		if !ok {
+			return Waypoint{}, false
+		}
+		return waypointAt(ff.env, ff.filter, tqx, tqy, st, l.Type), true
+	}
+	if ff.dir[i] < 0 {
+		return Waypoint{}, false
+	}
+	qx, qy := subCellOf(p.Point2d())
+	d := stepDirs[ff.dir[i]]
+	st, ok := footprintStand(ff.env, qx+d.dx, qy+d.dy, int32(ff.floors[i]), ff.filter)
+	if !ok {
+		return Waypoint{}, false
+	}
+	return waypointAt(ff.env, ff.filter, qx+d.dx, qy+d.dy, st, LinkNone), true
+}
+
+func (ff *FlowField) lookup(p zmap3base.Point3d) (uint32, bool) {
+	ff.Refresh()
+	qx, qy := subCellOf(p.Point2d())
+	st, ok := footprintStand(ff.env, qx, qy, int32(p.H), ff.filter)
+	if !ok {
+		return 0, false
+	}
+	return ff.node(qx, qy, st.h)
+}
+
+func (ff *FlowField) contains(qx, qy int32) bool {
+	return qx >= ff.qx0 && qy >= ff.qy0 && qx < ff.qx0+ff.w && qy < ff.qy0+ff.h
+}
+
+func (ff *FlowField) node(qx, qy int32, h uint16) (uint32, bool) {
+	if !ff.contains(qx, qy) {
+		return 0, false
+	}
+	li := (qx - ff.qx0) + (qy-ff.qy0)*ff.w
+	for i := ff.offsets[li]; i < ff.offsets[li+1]; i++ {
+		if ff.floors[i] == h {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// build enumerates the nodes and runs Dijkstra backwards from the goal: the
+// predecessors of (q, h) are the states one planner move away that step onto
+// it, including the no corner cutting rule, and the sources of links landing
+// on it.
+func (ff *FlowField) build() {
+	ff.stale = false
+	n := ff.w * ff.h
+	ff.offsets = make([]uint32, n+1)
+	ff.floors = ff.floors[:0]
+	for li := int32(0); li < n; li++ {
+		start := len(ff.floors)
+		ff.floors = appendFootprintFloors(ff.floors, ff.env, ff.qx0+li%ff.w, ff.qy0+li/ff.w, ff.filter)
+		if len(ff.floors)-start > 1 {
+			tail := ff.floors[start:]
+			sort.Slice(tail, func(i, j int) bool { return tail[i] < tail[j] })
+			ff.floors = ff.floors[:start+dedupeSorted(tail)]
+		}
+		ff.offsets[li+1] = uint32(len(ff.floors))
+	}
+	ff.cost = make([]float32, len(ff.floors))
+	ff.dir = make([]int8, len(ff.floors))
+	ff.via = make([]LinkID, len(ff.floors))
+	for i := range ff.cost {
+		ff.cost[i] = float32(math.Inf(1))
+		ff.dir[i] = -1
+		ff.via[i] = -1
+	}
+
+	goal, ok := ff.node(ff.goalQX, ff.goalQY, ff.goalH)
+	if !ok {
+		return
+	}
+
+	type standMemo struct {
+		st stand
+		ok bool
+	}
+	memo := make(map[uint64]standMemo)
+	standAt := func(qx, qy int32, h uint16) (stand, bool) {
+		k := nodeKey(qx, qy, h)
+		if m, ok := memo[k]; ok {
+			return m.st, m.ok
+		}
+		st, ok := footprintStand(ff.env, qx, qy, int32(h), ff.filter)
+		memo[k] = standMemo{st, ok}
+		return st, ok
+	}
+
+	closed := make([]bool, len(ff.floors))
+	var open flowOpen
+	ff.cost[goal] = 0
+	heap.Push(&open, flowItem{node: goal, cost: 0})
+	for open.Len() > 0 {
+		it := heap.Pop(&open).(flowItem)
+		cur := it.node
+		if closed[cur] {
+			continue
+		}
+		closed[cur] = true
+		li := int32(sort.Search(len(ff.offsets), func(i int) bool { return ff.offsets[i] > cur })) - 1
+		qx, qy, h := ff.qx0+li%ff.w, ff.qy0+li/ff.w, ff.floors[cur]
+
+		for d, dir := range stepDirs {
+			px, py := qx-dir.dx, qy-dir.dy
+			if !ff.contains(px, py) {
+				continue
+			}
+			pli := (px - ff.qx0) + (py-ff.qy0)*ff.w
+			for pi := ff.offsets[pli]; pi < ff.offsets[pli+1]; pi++ {
+				if closed[pi] {
+					continue
+				}
+				ph := ff.floors[pi]
+				st, ok := standAt(qx, qy, ph)
+				if !ok || st.h != h {
+					continue
+				}
+				if d >= 4 {
+					if _, ok := standAt(px+dir.dx, py, ph); !ok {
+						continue
+					}
+					if _, ok := standAt(px, py+dir.dy, ph); !ok {
+						continue
+					}
+				}
+				c := ff.cost[cur] + stepCost(ff.model, ff.env, ff.filter, dir.cost, ph, qx, qy, st)
+				if c < ff.cost[pi] {
+					ff.cost[pi] = c
+					ff.dir[pi] = int8(d)
+					ff.via[pi] = -1
+					heap.Push(&open, flowItem{node: pi, cost: c})
+				}
+			}
+		}
+
+		if ff.links == nil {
+			continue
+		}
+		for _, id := range ff.links.to[subKey(qx, qy)] {
+			l := &ff.links.links[id]
+			if st, ok := standAt(qx, qy, l.To.H); !ok || st.h != h {
+				continue
+			}
+			fqx, fqy := subCellOf(l.From.Point2d())
+			from, ok := footprintStand(ff.env, fqx, fqy, int32(l.From.H), ff.filter)
+			if !ok {
+				continue
+			}
+			pi, ok := ff.node(fqx, fqy, from.h)
+			if !ok || closed[pi] {
+				continue
+			}
+			c := ff.cost[cur] + l.cost()
+			if c < ff.cost[pi] {
+				ff.cost[pi] = c
+				ff.dir[pi] = -1
+				ff.via[pi] = id
+				heap.Push(&open, flowItem{node: pi, cost: c})
+			}
+		}
+	}
+}
+
+type flowItem struct {
+	node uint32
+	cost float32
+}
+
+type flowOpen []flowItem
+
+func (o flowOpen) Len() int           { return len(o) }
+func (o flowOpen) Less(i, j int) bool { return o[i].cost < o[j].cost }
+func (o flowOpen) Swap(i, j int)      { o[i], o[j] = o[j], o[i] }
+func (o *flowOpen) Push(x any)        { *o = append(*o, x.(flowItem)) }
+func (o *flowOpen) Pop() any {
+	old := *o
+	it := old[len(old)-1]
+	*o = old[:len(old)-1]
+	return it
+}
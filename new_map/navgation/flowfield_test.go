@@ -0,0 +1,130 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestFlowField(t *testing.T) {
+	cells := flatCells(10)
+	for y := 0; y < 24; y++ {
+		cells[cellIndex(12, y)] = cellFixture{terrain: rr(0, 60, testTexBase)}
+	}
+	// A 0.5m platform reachable by a small step.
+	for x := 20; x < 24; x++ {
+		for y := 20; y < 24; y++ {
+			cells[cellIndex(x, y)] = cellFixture{terrain: rr(0, 20, testTexBase)}
+		}
+	}
+	env := buildSingleGridEnv(t, cells)
+	f := testFilter()
+	goal := subPoint(100, 16, 10)
+	ff, ok := NewFlowField(env, f, goal, zmap3base.Rect{})
+	if !ok {
+		t.Fatalf("NewFlowField failed")
+	}
+	defer ff.Detach()
+	pl := NewPlanner(env, f)
+
+	check := func(start zmap3base.Point3d) {
+		t.Helper()
+		want, wantOK := pl.FindPath(start, goal)
+		cost, ok := ff.Cost(start)
+		if ok != wantOK || (ok && !sameCost(cost, want.Cost)) {
+			t.Fatalf("start %+v: field ok=%v cost=%v, A* ok=%v cost=%v", start, ok, cost, wantOK, want.Cost)
+		}
+		if !ok {
+			return
+		}
+		// Following the field reaches the goal at the same cost.
+		p, walked := start, float32(0)
+		for i := 0; i < 2000; i++ {
+			next, ok := ff.Next(p)
+			if !ok {
+				break
+			}
+			aqx, aqy := subCellOf(p.Point2d())
+			bqx, bqy := subCellOf(next.Point2d())
+			walked += octile(bqx-aqx, bqy-aqy)
+			p = next.Point3d
+		}
+		if qx, qy := subCellOf(p.Point2d()); qx != 100 || qy != 16 || !sameCost(walked, cost) {
+			t.Fatalf("start %+v: field walk ended at %+v after %v, want cost %v", start, p, walked, cost)
+		}
+	}
+
+	check(subPoint(8, 8, 10))
+	check(subPoint(84, 84, 20)) // on the platform
+	check(subPoint(4, 120, 10))
+
+	// Sealing the gap above the wall cuts the west side off.
+	var wall []zmap3base.Point3d
+	for y := 24; y < zmap3base.FastGridSetSize; y++ {
+		wall = append(wall, zmap3base.Point3d{X: 12, Y: uint16(y), H: 10, RangeEnd: 60})
+	}
+	if !env.ApplyRichOperationsExt(wall, nil, zmap3base.Accessory{Texture: testTexObs, Config: 5}) {
+		t.Fatalf("edit failed")
+	}
+	if !ff.Stale() {
+		t.Fatalf("edit did not invalidate the field")
+	}
+	check(subPoint(8, 8, 10))
+	if ff.Stale() {
+		t.Fatalf("lookup did not refresh the field")
+	}
+	check(subPoint(84, 84, 20))
+
+	// Edits outside a sub-region leave it alone.
+	local, ok := NewFlowField(env, f, goal, zmap3base.Rect{
+		Min: zmap3base.Point2d{X: 16, Y: 0},
+		Max: zmap3base.Point2d{X: 32, Y: 16},
+	})
+	if !ok {
+		t.Fatalf("NewFlowField(region) failed")
+	}
+	defer local.Detach()
+	env.ApplyRichOperationsExt([]zmap3base.Point3d{{X: 2, Y: 30, H: 10, RangeEnd: 60}}, nil, zmap3base.Accessory{Texture: testTexObs, Config: 6})
+	if local.Stale() {
+		t.Fatalf("edit outside the region invalidated it")
+	}
+	if _, ok := local.Cost(subPoint(8, 8, 10)); ok {
+		t.Fatalf("lookup outside the region succeeded")
+	}
+}
+
+func TestFlowField_UpdateReachWithLargeFootprint(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	f := testFilter().WithFootprint(12) // reaches 11 sub-cells past its reference
+	ff, ok := NewFlowField(env, f, subPoint(70, 24, 10), zmap3base.Rect{
+		Min: zmap3base.Point2d{X: 16, Y: 4},
+		Max: zmap3base.Point2d{X: 24, Y: 12},
+	})
+	if !ok {
+		t.Fatalf("NewFlowField failed")
+	}
+	defer ff.Detach()
+
+	acc := zmap3base.Accessory{Texture: testTexObs}
+	for _, c := range []struct {
+		x, y  uint16
+		stale bool
+	}{
+		{26, 8, true},  // the footprints of the last column reach LP 26
+		{27, 8, false}, // past them
+		{20, 14, true}, // the same below the region
+		{20, 15, false},
+		{15, 8, true}, // corner checks read one sub-cell before Min
+		{14, 8, false},
+		{20, 3, true},
+		{20, 2, false},
+	} {
+		ff.Refresh()
+		if !env.ApplyRichOperationsExt([]zmap3base.Point3d{{X: c.x, Y: c.y, H: 10, RangeEnd: 60}}, nil, acc) {
+			t.Fatalf("edit at (%d, %d) failed", c.x, c.y)
+		}
+		if ff.Stale() != c.stale {
+			t.Fatalf("edit at (%d, %d): stale=%v, want %v", c.x, c.y, ff.Stale(), c.stale)
+		}
+	}
+}
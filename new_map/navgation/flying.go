@@ -0,0 +1,333 @@
+package navgation
+
+import (
+	"container/heap"
+	"math"
+
+	"pathfinding/navtrace"
+	zmap3base "pathfinding/new_map"
+)
+
+// FlyAgent describes a flying agent. Heights are in 1/20m.
+type FlyAgent struct {
+	// IgnoreTexture spans are passable, as for Filter.
+	IgnoreTexture uint32
+	// Height is the body height; the body spans [y, y+Height).
+	Height int32
+	// Radius is the clearance in sub-cells around the reference sub-column:
+	// the body covers the (2*Radius+1)^2 sub-columns centred on it.
+	Radius int32
+	// MinAlt and MaxAlt bound y above the floor of the gap the agent is in.
+	MinAlt, MaxAlt int32
+	// Layer is the vertical resolution of the search; 0 means 5 (one
+	// sub-cell, 0.25m).
+	Layer int32
+}
+
+func (a FlyAgent) layer() int32 {
+	if a.Layer <= 0 {
+		return 5
+	}
+	return a.Layer
+}
+
+// FlyPlanner runs A* through the free air of an Env: states are
+// (sub-cell, altitude layer) and moves are the 26 neighbours, without
+// cutting corners. Free space comes from the gaps between the column spans,
+// cached per visited sub-column only, so memory follows the explored volume
+// rather than the Env size. The cache follows ApplyRichOperationsExt until
+// Detach is called. FlyPlanner is not safe for concurrent use.
+type FlyPlanner struct {
+	Env   *zmap3base.Env
+	Agent FlyAgent
+	// MaxExpansions bounds the search; 0 means unlimited.
+	MaxExpansions int
+	// Tracer, if set, receives the events of every FindPath search. Heights
+	// are layer bottoms in 1/20m (see NewTrace).
+	Tracer navtrace.Tracer
+
+	cols       map[uint64][]zmap3base.Range // sub-column -> free gaps, ascending
+	listenerID int
+}
+
+func NewFlyPlanner(env *zmap3base.Env, a FlyAgent) *FlyPlanner {
+	p := &FlyPlanner{Env: env, Agent: a, cols: make(map[uint64][]zmap3base.Range)}
+	p.listenerID = env.AddChangeListener(p.Update)
+	return p
+}
+
+// Detach stops listening to Env edits and drops the cache.
+func (p *FlyPlanner) Detach() {
+	if p.listenerID != 0 {
+		p.Env.RemoveChangeListener(p.listenerID)
+		p.listenerID = 0
+	}
+	p.cols = make(map[uint64][]zmap3base.Range)
+}
+
+// Update drops the cached sub-columns of the changed LP points. It is called
+// automatically for ApplyRichOperationsExt.
+func (p *FlyPlanner) Update(lps []zmap3base.Point2d) {
+	for _, lp := range lps {
+		qx0, qy0 := int32(lp.X)*zmap3base.SecondaryAccuracy, int32(lp.Y)*zmap3base.SecondaryAccuracy
+		for dx := int32(0); dx < zmap3base.SecondaryAccuracy; dx++ {
+			for dy := int32(0); dy < zmap3base.SecondaryAccuracy; dy++ {
+				delete(p.cols, subKey(qx0+dx, qy0+dy))
+			}
+		}
+	}
+}
+
+// column returns the free gaps of sub-column (qx, qy).
+func (p *FlyPlanner) column(qx, qy int32) []zmap3base.Range {
+	key := subKey(qx, qy)
+	if gaps, ok := p.cols[key]; ok {
+		return gaps
+	}
+	var gaps []zmap3base.Range
+	if p2d, ok := subCellPoint2d(qx, qy); ok {
+		air := NewFilter(p.Agent.IgnoreTexture, 0, 0, 0, 0)
+		ForEachInterval(p.Env, p2d, air, func(g Gap) bool {
+			gaps = append(gaps, g.Range)
+			return true
+		})
+	}
+	p.cols[key] = gaps
+	return gaps
+}
+
+// free reports whether the body fits at (qx, qy, y): every sub-column of the
+// clearance square has a gap holding [y, y+Height), and y keeps the altitude
+// limits above the floor under the reference sub-column.
+func (p *FlyPlanner) free(qx, qy, y int32) bool {
+	a := p.Agent
+	if y < 0 || y+a.Height > math.MaxUint16 {
+		return false
+	}
+	for dx := -a.Radius; dx <= a.Radius; dx++ {
+		for dy := -a.Radius; dy <= a.Radius; dy++ {
+			found := false
+			for _, g := range p.column(qx+dx, qy+dy) {
+				if int32(g.Begin) <= y && y+a.Height <= int32(g.End) {
+					if dx == 0 && dy == 0 {
+						alt := y - int32(g.Begin)
+						if alt < a.MinAlt || (a.MaxAlt > 0 && alt > a.MaxAlt) {
+							return false
+						}
+					}
+					found = true
+					break
+				}
+			}
+			if !found {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// FindPath searches from start to goal; H is the altitude of the body's
+// bottom. Endpoints snap to the nearest free layer. Waypoints carry the
+// altitude in H, H+Height in RangeEnd and LocoFly as Mode.
+func (p *FlyPlanner) FindPath(start, goal zmap3base.Point3d) (res PathResult, ok bool) {
+	if p.Env == nil {
+		return res, false
+	}
+	sqx, sqy := subCellOf(start.Point2d())
+	gqx, gqy := subCellOf(goal.Point2d())
+	sl, ok1 := p.snapLayer(sqx, sqy, int32(start.H))
+	gl, ok2 := p.snapLayer(gqx, gqy, int32(goal.H))
+	if !ok1 || !ok2 {
+		return res, false
+	}
+	res.Start = p.point(sqx, sqy, sl)
+	res.Goal = p.point(gqx, gqy, gl)
+
+	fs := &flySearch{p: p, gqx: gqx, gqy: gqy, gl: gl, index: make(map[uint64]int32)}
+	fs.trace(navtrace.Start, 0, sqx, sqy, sl, 0, fs.heuristic(sqx, sqy, sl))
+	fs.trace(navtrace.Goal, 0, gqx, gqy, gl, 0, 0)
+	fs.push(sqx, sqy, sl, 0, -1)
+	end, ok := fs.run()
+	res.Expanded = fs.expanded
+	if !ok {
+		fs.trace(navtrace.Failed, 0, gqx, gqy, gl, 0, 0)
+		return res, false
+	}
+	var chain []int32
+	for i := end; i >= 0; i = fs.nodes[i].parent {
+		chain = append(chain, i)
+	}
+	for k := len(chain) - 1; k >= 0; k-- {
+		n := &fs.nodes[chain[k]]
+		res.Waypoints = append(res.Waypoints, Waypoint{Point3d: p.point(n.qx, n.qy, n.l), Mode: LocoFly})
+	}
+	res.Cost = fs.nodes[end].g
+	if p.Tracer != nil {
+		fs.trace(navtrace.Found, 0, gqx, gqy, gl, res.Cost, res.Cost)
+		for k := len(chain) - 1; k >= 0; k-- {
+			n := &fs.nodes[chain[k]]
+			fs.trace(navtrace.Path, 0, n.qx, n.qy, n.l, n.g, n.g)
+		}
+	}
+	return res, true
+}
+
+// snapLayer returns the free layer nearest to altitude y.
+func (p *FlyPlanner) snapLayer(qx, qy, y int32) (int32, bool) {
+	step := p.Agent.layer()
+	l := (y + step/2) / step
+	maxL := int32(math.MaxUint16) / step
+	for d := int32(0); d <= maxL; d++ {
+		if l-d < 0 && l+d > maxL {
+			break
+		}
+		if l-d >= 0 && p.free(qx, qy, (l-d)*step) {
+			return l - d, true
+		}
+		if d > 0 && l+d <= maxL && p.free(qx, qy, (l+d)*step) {
+			return l + d, true
+		}
+	}
+	return 0, false
+}
+
+func (p *FlyPlanner) point(qx, qy, l int32) zmap3base.Point3d {
+	y := l * p.Agent.layer()
+	return point3dAt(qx, qy, zmap3base.Range{Begin: uint16(y), End: uint16(y + p.Agent.Height)})
+}
+
+// ---------------------------------------------------------------------------
+
+type flyNode struct {
+	qx, qy, l int32
+	g         float32
+	parent    int32
+	closed    bool
+}
+
+type flySearch struct {
+	p            *FlyPlanner
+	gqx, gqy, gl int32
+	nodes        []flyNode
+	index        map[uint64]int32
+	open         hpaOpen
+	freeMemo     map[uint64]bool
+	expanded     int
+}
+
+func flyKey(qx, qy, l int32) uint64 {
+	return uint64(uint32(qx))<<40 | uint64(uint32(qy)&0xFFFFFF)<<16 | uint64(uint16(l))
+}
+
+// layerLen is the length of one layer in sub-cell units.
+func (fs *flySearch) layerLen() float32 {
+	return float32(fs.p.Agent.layer()) / (zmap3base.HeightScale * zmap3base.SecondaryTileLen)
+}
+
+func (fs *flySearch) heuristic(qx, qy, l int32) float32 {
+	dx, dy := float32(fs.gqx-qx), float32(fs.gqy-qy)
+	dz := float32(fs.gl-l) * fs.layerLen()
+	return float32(math.Sqrt(float64(dx*dx + dy*dy + dz*dz)))
+}
+
+func (fs *flySearch) free(qx, qy, l int32) bool {
+	if fs.freeMemo == nil {
+		fs.freeMemo = make(map[uint64]bool)
+	}
+	k := flyKey(qx, qy, l)
+	if v, ok := fs.freeMemo[k]; ok {
+		return v
+	}
+	v := fs.p.free(qx, qy, l*fs.p.Agent.layer())
+	fs.freeMemo[k] = v
+	return v
+}
+
+func (fs *flySearch) push(qx, qy, l int32, g float32, parent int32) {
+	k := flyKey(qx, qy, l)
+	f := g + fs.heuristic(qx, qy, l)
+	if i, ok := fs.index[k]; ok {
+		n := &fs.nodes[i]
+		if n.closed || g >= n.g {
+			if n.closed {
+				fs.trace(navtrace.Reject, navtrace.Closed, qx, qy, l, g, f)
+			} else {
+				fs.trace(navtrace.Reject, navtrace.Worse, qx, qy, l, g, f)
+			}
+			return
+		}
+		n.g, n.parent = g, parent
+		heap.Push(&fs.open, hpaOpenItem{id: i, f: f, g: g})
+		fs.trace(navtrace.Push, 0, qx, qy, l, g, f)
+		return
+	}
+	i := int32(len(fs.nodes))
+	fs.nodes = append(fs.nodes, flyNode{qx: qx, qy: qy, l: l, g: g, parent: parent})
+	fs.index[k] = i
+	heap.Push(&fs.open, hpaOpenItem{id: i, f: f, g: g})
+	fs.trace(navtrace.Push, 0, qx, qy, l, g, f)
+}
+
+// trace records an event at layer l when the planner has a tracer.
+func (fs *flySearch) trace(k navtrace.Kind, r navtrace.Reason, qx, qy, l int32, g, f float32) {
+	if fs.p.Tracer == nil {
+		return
+	}
+	fs.p.Tracer.Record(navtrace.Event{Kind: k, Reason: r, At: navtrace.Coord{X: qx, Y: l * fs.p.Agent.layer(), Z: qy}, G: g, F: f})
+}
+
+func (fs *flySearch) run() (int32, bool) {
+	max := fs.p.MaxExpansions
+	for fs.open.Len() > 0 {
+		if max > 0 && fs.expanded >= max {
+			break
+		}
+		it := heap.Pop(&fs.open).(hpaOpenItem)
+		n := &fs.nodes[it.id]
+		fs.trace(navtrace.Pop, 0, n.qx, n.qy, n.l, it.g, it.f)
+		if n.closed || it.g > n.g {
+			fs.trace(navtrace.Reject, navtrace.Stale, n.qx, n.qy, n.l, it.g, it.f)
+			continue
+		}
+		n.closed = true
+		fs.expanded++
+		fs.trace(navtrace.Expand, 0, n.qx, n.qy, n.l, it.g, it.f)
+		if n.qx == fs.gqx && n.qy == fs.gqy && n.l == fs.gl {
+			return it.id, true
+		}
+		fs.expand(it.id)
+	}
+	return -1, false
+}
+
+// expand pushes the 26 neighbours. A move along several axes needs every
+// single-axis move it combines to be free as well.
+func (fs *flySearch) expand(cur int32) {
+	n := fs.nodes[cur]
+	ll := fs.layerLen()
+	for dz := int32(-1); dz <= 1; dz++ {
+		for dy := int32(-1); dy <= 1; dy++ {
+			for dx := int32(-1); dx <= 1; dx++ {
+				if dx == 0 && dy == 0 && dz == 0 {
+					continue
+				}
+				nx, ny, nl := n.qx+dx, n.qy+dy, n.l+dz
+				if !fs.free(nx, ny, nl) {
+					fs.trace(navtrace.Reject, navtrace.Blocked, nx, ny, nl, n.g, n.g)
+					continue
+				}
+				axes := abs32(dx) + abs32(dy) + abs32(dz)
+				if axes > 1 && ((dx != 0 && !fs.free(n.qx+dx, n.qy, n.l)) ||
+					(dy != 0 && !fs.free(n.qx, n.qy+dy, n.l)) ||
+					(dz != 0 && !fs.free(n.qx, n.qy, n.l+dz))) {
+					fs.trace(navtrace.Reject, navtrace.CornerCut, nx, ny, nl, n.g, n.g)
+					continue
+				}
+				fz := float32(dz) * ll
+				cost := float32(math.Sqrt(float64(dx*dx+dy*dy) + float64(fz*fz)))
+				fs.push(nx, ny, nl, n.g+cost, cur)
+			}
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// wallWindowCells returns 0.5m ground cut by a 5m wall along x=16 with a 1m
+// high window at y=14..15, from 2m to 3m.
+func wallWindowCells() map[int]cellFixture {
+	cells := flatCells(10)
+	for y := 0; y < zmap3base.FastGridSetSize; y++ {
+		if y == 14 || y == 15 {
+			cells[cellIndex(16, y)] = cellFixture{
+				terrain:   rr(0, 40, testTexBase),
+				lpPayload: []zmap3base.RichRange{rr(60, 100, testTexObs)},
+			}
+			continue
+		}
+		cells[cellIndex(16, y)] = cellFixture{terrain: rr(0, 100, testTexBase)}
+	}
+	return cells
+}
+
+func TestFlyPlanner_ThroughWindow(t *testing.T) {
+	env := buildSingleGridEnv(t, wallWindowCells())
+	agent := FlyAgent{Height: 8, Radius: 1, MinAlt: 4, MaxAlt: 60}
+	fp := NewFlyPlanner(env, agent)
+	defer fp.Detach()
+
+	start, goal := subPoint(8, 60, 30), subPoint(100, 60, 30)
+	res, ok := fp.FindPath(start, goal)
+	if !ok {
+		t.Fatalf("no flying path")
+	}
+	crossed := false
+	for i, w := range res.Waypoints {
+		if w.Mode != LocoFly {
+			t.Fatalf("waypoint %d mode %v", i, w.Mode)
+		}
+		qx, qy := subCellOf(w.Point2d())
+		if !fp.free(qx, qy, int32(w.H)) {
+			t.Fatalf("waypoint %d %+v is not free", i, w.Point3d)
+		}
+		if i > 0 {
+			px, py := subCellOf(res.Waypoints[i-1].Point2d())
+			dz := int32(w.H) - int32(res.Waypoints[i-1].H)
+			if abs32(qx-px) > 1 || abs32(qy-py) > 1 || abs32(dz) > agent.layer() {
+				t.Fatalf("waypoint %d jumps from the previous one", i)
+			}
+		}
+		if qx >= 64 && qx < 68 {
+			crossed = true
+			if qy < 56 || qy >= 64 || w.H < 44 || w.H+8 > 60 {
+				t.Fatalf("crossed the wall outside the window at %+v", w.Point3d)
+			}
+		}
+	}
+	if !crossed {
+		t.Fatalf("path never crossed the wall")
+	}
+	if res.Cost < 92 {
+		t.Fatalf("cost %v below the straight distance", res.Cost)
+	}
+
+	// Closing the window is picked up through the change listener; an agent
+	// ignoring the new texture still flies through.
+	closing := []zmap3base.Point3d{{X: 16, Y: 14, H: 40, RangeEnd: 60}, {X: 16, Y: 15, H: 40, RangeEnd: 60}}
+	if !env.ApplyRichOperationsExt(closing, nil, zmap3base.Accessory{Texture: testTexCol}) {
+		t.Fatalf("ApplyRichOperationsExt failed")
+	}
+	if _, ok := fp.FindPath(start, goal); ok {
+		t.Fatalf("flew through the closed window")
+	}
+	ghost := NewFlyPlanner(env, FlyAgent{IgnoreTexture: uint32(testTexCol), Height: 8, Radius: 1, MinAlt: 4, MaxAlt: 60})
+	defer ghost.Detach()
+	if _, ok := ghost.FindPath(start, goal); !ok {
+		t.Fatalf("agent ignoring the texture found no path")
+	}
+
+	// Without an altitude ceiling the agent goes over the wall.
+	high := NewFlyPlanner(env, FlyAgent{Height: 8, Radius: 1})
+	defer high.Detach()
+	res, ok = high.FindPath(start, goal)
+	if !ok {
+		t.Fatalf("no path over the wall")
+	}
+	top := uint16(0)
+	for _, w := range res.Waypoints {
+		if w.H > top {
+			top = w.H
+		}
+	}
+	if top < 100 {
+		t.Fatalf("highest waypoint %d, want over the wall top at 100", top)
+	}
+}
+
+func TestFlyPlanner_SnapAndLimits(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	fp := NewFlyPlanner(env, FlyAgent{Height: 8, MinAlt: 20, MaxAlt: 40, Layer: 10})
+	defer fp.Detach()
+	// Start below the minimum altitude snaps up to the first layer above it.
+	res, ok := fp.FindPath(subPoint(8, 8, 10), subPoint(8, 40, 200))
+	if !ok {
+		t.Fatalf("no path")
+	}
+	if res.Start.H != 30 || res.Goal.H != 50 {
+		t.Fatalf("snapped start %d goal %d, want 30 and 50", res.Start.H, res.Goal.H)
+	}
+	fp.MaxExpansions = 3
+	if _, ok := fp.FindPath(subPoint(8, 8, 30), subPoint(100, 100, 30)); ok {
+		t.Fatalf("search ignored MaxExpansions")
+	}
+}
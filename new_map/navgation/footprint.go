@@ -0,0 +1,139 @@
+package navgation
+
+import (
+	"math"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// defaultFootprint is the agent footprint edge in sub-cells. map_data fixes the
+// agent to 2x2 HP cells; the reference sub-cell is the bottom-left one.
+const defaultFootprint = 2
+
+// WithFootprint returns f for an agent covering n x n sub-cells (0.25m each)
+// instead of the default 2x2. The reference sub-cell stays the bottom-left
+// one. Every planner checks the common gap of all n*n sub-columns; pair large
+// footprints with a FootprintLayer to avoid n*n GetInterval calls per step.
+func (f Filter) WithFootprint(n int32) Filter {
+	f.size = n
+	return f
+}
+
+// Footprint returns the footprint edge in sub-cells.
+func (f Filter) Footprint() int32 { return f.footprint() }
+
+func (f Filter) footprint() int32 {
+	if f.size <= 0 {
+		return defaultFootprint
+	}
+	return f.size
+}
+
+// FootprintForRadius returns the footprint edge in sub-cells of the smallest
+// square holding a disc of radius r meters.
+func FootprintForRadius(r float32) int32 {
+	n := int32(math.Ceil(float64(2 * r / zmap3base.SecondaryTileLen)))
+	if n < 1 {
+		n = 1
+	}
+	return n
+}
+
+// stand is where the agent footprint rests: the common gap of every sub-column.
+type stand struct {
+	h, ceil uint16
+	tex     zmap3base.Texture
+}
+
+func (s stand) gap() zmap3base.Range {
+	return zmap3base.Range{Begin: s.h, End: s.ceil}
+}
+
+// footprintStand runs GetInterval on each sub-column of the footprint whose
+// reference sub-cell is (qx, qy) for an agent at curY. The footprint stands on
+// the highest floor; every other column must leave f.height of headroom above it.
+// Filters bound to a FootprintLayer of env read the cached result.
+func footprintStand(env *zmap3base.Env, qx, qy, curY int32, f Filter) (s stand, ok bool) {
+	if l := f.cache(env); l != nil {
+		return l.stand(qx, qy, curY)
+	}
+	return computeFootprintStand(env, qx, qy, curY, f)
+}
+
+func computeFootprintStand(env *zmap3base.Env, qx, qy, curY int32, f Filter) (s stand, ok bool) {
+	n := f.footprint()
+	for dx := int32(0); dx < n; dx++ {
+		for dy := int32(0); dy < n; dy++ {
+			p2d, ok := subCellPoint2d(qx+dx, qy+dy)
+			if !ok {
+				return stand{}, false
+			}
+			snap, ok := f.columnInterval(env, qx+dx, qy+dy, p2d, curY)
+			if !ok {
+				return stand{}, false
+			}
+			if dx == 0 && dy == 0 {
+				s = stand{h: snap.Begin, ceil: snap.End, tex: snap.Texture}
+				continue
+			}
+			if snap.Begin > s.h {
+				s.h = snap.Begin
+				s.tex = snap.Texture
+			}
+			if snap.End < s.ceil {
+				s.ceil = snap.End
+			}
+		}
+	}
+	if int32(s.ceil)-int32(s.h) < f.height {
+		return stand{}, false
+	}
+	return s, true
+}
+
+// appendFootprintFloors appends the gap floors of every sub-column under the
+// footprint at (qx, qy). A stand always rests on one of them, so they bound
+// the planner states of that sub-cell. The result is unsorted.
+func appendFootprintFloors(dst []uint16, env *zmap3base.Env, qx, qy int32, f Filter) []uint16 {
+	n := f.footprint()
+	for dx := int32(0); dx < n; dx++ {
+		for dy := int32(0); dy < n; dy++ {
+			forEachColumnGap(env, qx+dx, qy+dy, f, func(g Gap) bool {
+				dst = append(dst, g.Floor())
+				return true
+			})
+		}
+	}
+	return dst
+}
+
+// forEachColumnGap is ForEachInterval on sub-column (qx, qy), read from the
+// FootprintLayer cache when f has one for env.
+func forEachColumnGap(env *zmap3base.Env, qx, qy int32, f Filter, visit func(g Gap) bool) {
+	if l := f.cache(env); l != nil {
+		for _, g := range l.column(qx, qy) {
+			if !visit(g) {
+				return
+			}
+		}
+		return
+	}
+	if p2d, ok := subCellPoint2d(qx, qy); ok {
+		ForEachInterval(env, p2d, f, visit)
+	}
+}
+
+// columnInterval is f.Interval on sub-column (qx, qy) at p2d, answered from
+// the cached gaps when f has a FootprintLayer for env.
+func (f Filter) columnInterval(env *zmap3base.Env, qx, qy int32, p2d zmap3base.Point2d, curY int32) (zmap3base.SnapRichRange, bool) {
+	if l := f.cache(env); l != nil {
+		return intervalFromGaps(l.column(qx, qy), curY, f)
+	}
+	return f.Interval(env, p2d, curY)
+}
+
+// footprintCenter returns the world x/z of the centre of an n x n footprint.
+func footprintCenter(qx, qy, n int32) (x, z float32) {
+	half := float32(n) / 2
+	return (float32(qx) + half) * zmap3base.SecondaryTileLen, (float32(qy) + half) * zmap3base.SecondaryTileLen
+}
@@ -0,0 +1,115 @@
+package navgation
+
+import (
+	"math/rand"
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// doorCells returns flat ground split by a wall along x=16 with a 1m door at
+// y=14.
+func doorCells() map[int]cellFixture {
+	cells := flatCells(10)
+	for y := 0; y < zmap3base.FastGridSetSize; y++ {
+		if y != 14 {
+			cells[cellIndex(16, y)] = cellFixture{terrain: rr(0, 100, testTexBase)}
+		}
+	}
+	return cells
+}
+
+func TestFootprint_DoorWidth(t *testing.T) {
+	env := buildSingleGridEnv(t, doorCells())
+	start, goal := subPoint(8, 8, 10), subPoint(100, 8, 10)
+	for _, c := range []struct {
+		n      int32
+		passes bool
+	}{{1, true}, {2, true}, {4, true}, {5, false}, {8, false}} {
+		f := testFilter().WithFootprint(c.n)
+		if f.Footprint() != c.n {
+			t.Fatalf("Footprint()=%d, want %d", f.Footprint(), c.n)
+		}
+		res, ok := NewPlanner(env, f).FindPath(start, goal)
+		if ok != c.passes {
+			t.Fatalf("footprint %d: ok=%v, want %v", c.n, ok, c.passes)
+		}
+		if ok {
+			checkPathMoves(t, env, res.Waypoints, f)
+		}
+
+		layer := NewFootprintLayer(env, f)
+		cached, cok := NewPlanner(env, layer.Filter()).FindPath(start, goal)
+		if cok != ok || (ok && !sameCost(cached.Cost, res.Cost)) {
+			t.Fatalf("footprint %d: layer ok=%v cost=%v, plain ok=%v cost=%v", c.n, cok, cached.Cost, ok, res.Cost)
+		}
+		layer.Detach()
+	}
+	if testFilter().Footprint() != 2 {
+		t.Fatalf("default footprint is not 2x2")
+	}
+}
+
+func TestFootprintLayer_MatchesUncached(t *testing.T) {
+	rnd := rand.New(rand.NewSource(41))
+	cells := flatCells(10)
+	for i := 0; i < 200; i++ {
+		idx := cellIndex(rnd.Intn(32), rnd.Intn(32))
+		switch rnd.Intn(4) {
+		case 0:
+			cells[idx] = cellFixture{terrain: rr(0, 60, testTexBase)}
+		case 1:
+			cells[idx] = cellFixture{terrain: rr(0, 18, testTexBase)}
+		case 2:
+			cells[idx] = cellFixture{terrain: rr(0, 10, testTexBase), lpPayload: []zmap3base.RichRange{rr(25, 40, testTexObs)}}
+		default:
+			cells[idx] = cellFixture{terrain: rr(0, 10, testTexBase), lpPayload: []zmap3base.RichRange{rr(40, 45, testTexCol)}}
+		}
+	}
+	env := buildSingleGridEnv(t, cells)
+	f := NewFilter(uint32(testTexCol), 0, 22, 22, 40).WithFootprint(6)
+	layer := NewFootprintLayer(env, f)
+	defer layer.Detach()
+	lf := layer.Filter()
+
+	compare := func(round int) {
+		t.Helper()
+		for i := 0; i < 3000; i++ {
+			qx, qy := int32(rnd.Intn(124)), int32(rnd.Intn(124))
+			curY := int32([]uint16{10, 18, 40, 45, 60}[rnd.Intn(5)])
+			want, wok := footprintStand(env, qx, qy, curY, f)
+			got, gok := footprintStand(env, qx, qy, curY, lf)
+			if wok != gok || want != got {
+				t.Fatalf("round %d (%d,%d,%d): layer %+v %v, plain %+v %v", round, qx, qy, curY, got, gok, want, wok)
+			}
+		}
+	}
+	compare(0)
+
+	// Edits drop the cached grids they touch.
+	var ops []zmap3base.Point3d
+	for i := 0; i < 20; i++ {
+		ops = append(ops, zmap3base.Point3d{X: uint16(rnd.Intn(32)), Y: uint16(rnd.Intn(32)), H: 10, RangeEnd: 30})
+	}
+	if !env.ApplyRichOperationsExt(ops, nil, zmap3base.Accessory{Texture: testTexObs}) {
+		t.Fatalf("ApplyRichOperationsExt failed")
+	}
+	compare(1)
+
+	// A filter derived with other settings must not use the layer.
+	other := lf.WithFootprint(2)
+	if other.cache(env) != nil || lf.cache(env) != layer {
+		t.Fatalf("layer bound to the wrong filters")
+	}
+}
+
+func TestFootprintForRadius(t *testing.T) {
+	for _, c := range []struct {
+		r    float32
+		want int32
+	}{{0, 1}, {0.25, 2}, {0.75, 6}, {1, 8}, {2, 16}} {
+		if got := FootprintForRadius(c.r); got != c.want {
+			t.Fatalf("FootprintForRadius(%v)=%d, want %d", c.r, got, c.want)
+		}
+	}
+}
@@ -0,0 +1,650 @@
+package navgation
+
+import (
+	"container/heap"
+	"sort"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// HPAGraph is a hierarchical (HPA*) abstraction of an Env. Every GridRBData
+// is a cluster; entrances are found on the border between two adjacent
+// clusters per floor height, and the portal-to-portal costs inside each
+// cluster are cached. FindPath searches the abstract graph and then refines
+// each abstract edge with a local search bounded to one cluster.
+//
+// Paths are near-optimal: moves are only allowed across a border at an
+// entrance portal. Off-mesh links of pl.Links that leave a cluster are
+// abstract edges between two portals; the others are used by the local
+// searches. An edit rebuilds only the borders of the edited cluster
+// and the intra-cluster edges of clusters whose portals changed.
+// The graph caches step costs, so pl.Cost must not change after it is built.
+// HPAGraph is not safe for concurrent use with Env edits.
+type HPAGraph struct {
+	pl *Planner
+
+	gridW, gridH int
+	clusters     []*hpaCluster // nil for unloaded grids
+	// vborders[gx+gy*gridW] joins (gx, gy) and (gx+1, gy);
+	// hborders[gx+gy*gridW] joins (gx, gy) and (gx, gy+1).
+	vborders, hborders [][]hpaCrossing
+
+	listenerID int
+	links      *LinkRegistry
+	linkID     int
+	rebuilt    int // intra-cluster rebuilds, for tests
+}
+
+// hpaNode is one planner state: a footprint and the stand it rests on.
+type hpaNode struct {
+	qx, qy int32
+	st     stand
+}
+
+func (n hpaNode) key() uint64 { return nodeKey(n.qx, n.qy, n.st.h) }
+
+// hpaCrossing is one move from a cluster into another: an orthogonal step
+// across a border or an off-mesh link.
+type hpaCrossing struct {
+	from, to hpaNode
+	cost     float32
+	link     LinkType
+}
+
+type hpaCluster struct {
+	gx, gy  int
+	portals []hpaNode
+	index   map[uint64]int32
+	intra   [][]hpaEdge // per portal, to portals of this cluster
+	inter   [][]hpaLink // per portal, to portals of neighbour clusters
+}
+
+type hpaEdge struct {
+	to   int32
+	cost float32
+}
+
+type hpaLink struct {
+	cluster int
+	key     uint64
+	cost    float32
+	link    LinkType
+}
+
+// NewHPAGraph builds the abstraction for pl.Env and pl.Filter. pl also
+// supplies RelocateRadius and MaxExpansions (per local search) to FindPath.
+// The graph follows ApplyRichOperationsExt until Detach is called.
+func NewHPAGraph(pl *Planner) *HPAGraph {
+	w, h := pl.Env.GridDims()
+	g := &HPAGraph{
+		pl:       pl,
+		gridW:    w,
+		gridH:    h,
+		clusters: make([]*hpaCluster, w*h),
+		vborders: make([][]hpaCrossing, w*h),
+		hborders: make([][]hpaCrossing, w*h),
+	}
+	for gy := 0; gy < h; gy++ {
+		for gx := 0; gx < w; gx++ {
+			if pl.Env.GridAt(gx, gy) != nil {
+				g.clusters[gx+gy*w] = &hpaCluster{gx: gx, gy: gy}
+			}
+		}
+	}
+	for i := range g.clusters {
+		g.vborders[i] = g.scanBorder(i%w, i/w, true)
+		g.hborders[i] = g.scanBorder(i%w, i/w, false)
+	}
+	for _, c := range g.clusters {
+		if c != nil {
+			g.rebuildPortals(c)
+			g.rebuildIntra(c)
+		}
+	}
+	g.listenerID = pl.Env.AddChangeListener(g.Update)
+	if pl.Links != nil {
+		g.links = pl.Links
+		g.linkID = pl.Links.AddChangeListener(g.Update)
+	}
+	return g
+}
+
+// Detach stops listening to Env edits and link changes.
+func (g *HPAGraph) Detach() {
+	if g.listenerID != 0 {
+		g.pl.Env.RemoveChangeListener(g.listenerID)
+		g.listenerID = 0
+	}
+	if g.linkID != 0 {
+		g.links.RemoveChangeListener(g.linkID)
+		g.linkID = 0
+	}
+}
+
+// Update rebuilds the abstraction around the changed LP points. It is called
+// automatically for ApplyRichOperationsExt and link changes.
+func (g *HPAGraph) Update(lps []zmap3base.Point2d) {
+	forced := make(map[int]struct{})
+	for _, p := range lps {
+		gx, gy, ok := g.pl.Env.GridCoordOf(p)
+		if !ok {
+			continue
+		}
+		forced[gx+gy*g.gridW] = struct{}{}
+		// Footprints of the left/lower neighbour overlap our first LP columns/rows.
+		reach := (int(g.pl.Filter.footprint()) + 2) / zmap3base.SecondaryAccuracy
+		lx := (int(p.X) - int(g.pl.Env.MinX())) % zmap3base.FastGridSetSize
+		ly := (int(p.Y) - int(g.pl.Env.MinY())) % zmap3base.FastGridSetSize
+		if lx < reach && gx > 0 {
+			forced[gx-1+gy*g.gridW] = struct{}{}
+		}
+		if ly < reach && gy > 0 {
+			forced[gx+(gy-1)*g.gridW] = struct{}{}
+		}
+		if lx < reach && ly < reach && gx > 0 && gy > 0 {
+			forced[gx-1+(gy-1)*g.gridW] = struct{}{}
+		}
+	}
+	if len(forced) == 0 {
+		return
+	}
+
+	touched := make(map[int]struct{})
+	for ci := range forced {
+		gx, gy := ci%g.gridW, ci/g.gridW
+		touched[ci] = struct{}{}
+		if gx > 0 {
+			g.vborders[ci-1] = g.scanBorder(gx-1, gy, true)
+			touched[ci-1] = struct{}{}
+		}
+		if gy > 0 {
+			g.hborders[ci-g.gridW] = g.scanBorder(gx, gy-1, false)
+			touched[ci-g.gridW] = struct{}{}
+		}
+		g.vborders[ci] = g.scanBorder(gx, gy, true)
+		g.hborders[ci] = g.scanBorder(gx, gy, false)
+		if gx+1 < g.gridW {
+			touched[ci+1] = struct{}{}
+		}
+		if gy+1 < g.gridH {
+			touched[ci+g.gridW] = struct{}{}
+		}
+	}
+	for ci := range touched {
+		c := g.clusters[ci]
+		if c == nil {
+			continue
+		}
+		_, force := forced[ci]
+		if g.rebuildPortals(c) || force {
+			g.rebuildIntra(c)
+		}
+	}
+}
+
+func (g *HPAGraph) cluster(gx, gy int) *hpaCluster {
+	if gx < 0 || gy < 0 || gx >= g.gridW || gy >= g.gridH {
+		return nil
+	}
+	return g.clusters[gx+gy*g.gridW]
+}
+
+func (g *HPAGraph) clusterOf(qx, qy int32) (int, bool) {
+	gx, gy, ok := gridOfSubCell(g.pl.Env, qx, qy)
+	if !ok || g.clusters[gx+gy*g.gridW] == nil {
+		return 0, false
+	}
+	return gx + gy*g.gridW, true
+}
+
+// scanBorder finds the entrances between (gx, gy) and its right (vertical) or
+// upper neighbour. Each maximal run of crossings with the same floor heights
+// on both sides becomes one entrance, placed in the middle of the run.
+func (g *HPAGraph) scanBorder(gx, gy int, vertical bool) []hpaCrossing {
+	nx, ny := gx, gy+1
+	if vertical {
+		nx, ny = gx+1, gy
+	}
+	if g.cluster(gx, gy) == nil || g.cluster(nx, ny) == nil {
+		return nil
+	}
+	qx0, qy0 := gridOrigin(g.pl.Env, gx, gy)
+
+	var ab, ba []hpaCrossing
+	var floors []uint16
+	for i := int32(0); i < gridSideSub; i++ {
+		aqx, aqy := qx0+i, qy0+gridSideSub-1
+		bqx, bqy := aqx, aqy+1
+		if vertical {
+			aqx, aqy = qx0+gridSideSub-1, qy0+i
+			bqx, bqy = aqx+1, aqy
+		}
+		ab = g.appendCrossings(ab, &floors, aqx, aqy, bqx, bqy)
+		ba = g.appendCrossings(ba, &floors, bqx, bqy, aqx, aqy)
+	}
+	out := pickEntrances(nil, ab, vertical)
+	return pickEntrances(out, ba, vertical)
+}
+
+// appendCrossings appends every move from a state at (fqx, fqy) to (tqx, tqy).
+func (g *HPAGraph) appendCrossings(dst []hpaCrossing, floors *[]uint16, fqx, fqy, tqx, tqy int32) []hpaCrossing {
+	env, f := g.pl.Env, g.pl.Filter
+	*floors = appendFootprintFloors((*floors)[:0], env, fqx, fqy, f)
+	hs := *floors
+	sort.Slice(hs, func(i, j int) bool { return hs[i] < hs[j] })
+	hs = hs[:dedupeSorted(hs)]
+	for _, h := range hs {
+		from, ok := footprintStand(env, fqx, fqy, int32(h), f)
+		if !ok || from.h != h {
+			continue
+		}
+		to, ok := footprintStand(env, tqx, tqy, int32(h), f)
+		if !ok {
+			continue
+		}
+		cost := stepCost(g.pl.Cost, env, f, 1, h, tqx, tqy, to)
+		dst = append(dst, hpaCrossing{from: hpaNode{fqx, fqy, from}, to: hpaNode{tqx, tqy, to}, cost: cost})
+	}
+	return dst
+}
+
+func pickEntrances(dst, cs []hpaCrossing, vertical bool) []hpaCrossing {
+	along := func(c hpaCrossing) int32 {
+		if vertical {
+			return c.from.qy
+		}
+		return c.from.qx
+	}
+	sort.Slice(cs, func(i, j int) bool {
+		a, b := cs[i], cs[j]
+		if a.from.st.h != b.from.st.h {
+			return a.from.st.h < b.from.st.h
+		}
+		if a.to.st.h != b.to.st.h {
+			return a.to.st.h < b.to.st.h
+		}
+		return along(a) < along(b)
+	})
+	for i := 0; i < len(cs); {
+		j := i + 1
+		for j < len(cs) && cs[j].from.st.h == cs[i].from.st.h && cs[j].to.st.h == cs[i].to.st.h &&
+			along(cs[j]) == along(cs[j-1])+1 {
+			j++
+		}
+		dst = append(dst, cs[(i+j-1)/2])
+		i = j
+	}
+	return dst
+}
+
+// rebuildPortals collects the portals of c from its four borders and reports
+// whether the portal set changed.
+func (g *HPAGraph) rebuildPortals(c *hpaCluster) bool {
+	ci := c.gx + c.gy*g.gridW
+	var borders [][]hpaCrossing
+	if c.gx > 0 {
+		borders = append(borders, g.vborders[ci-1])
+	}
+	if c.gy > 0 {
+		borders = append(borders, g.hborders[ci-g.gridW])
+	}
+	borders = append(borders, g.vborders[ci], g.hborders[ci], g.linkCrossings(ci))
+
+	old := c.portals
+	c.portals = nil
+	c.index = make(map[uint64]int32)
+	var links []hpaCrossing
+	add := func(n hpaNode) {
+		if _, ok := c.index[n.key()]; !ok {
+			c.index[n.key()] = int32(len(c.portals))
+			c.portals = append(c.portals, n)
+		}
+	}
+	for _, b := range borders {
+		for _, x := range b {
+			if fc, _ := g.clusterOf(x.from.qx, x.from.qy); fc == ci {
+				add(x.from)
+				links = append(links, x)
+			} else {
+				add(x.to)
+			}
+		}
+	}
+
+	c.inter = make([][]hpaLink, len(c.portals))
+	for _, x := range links {
+		tc, ok := g.clusterOf(x.to.qx, x.to.qy)
+		if !ok {
+			continue
+		}
+		i := c.index[x.from.key()]
+		c.inter[i] = append(c.inter[i], hpaLink{cluster: tc, key: x.to.key(), cost: x.cost, link: x.link})
+	}
+
+	if len(old) != len(c.portals) {
+		return true
+	}
+	for i := range old {
+		if old[i] != c.portals[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// linkCrossings returns the links of pl.Links that enter or leave cluster ci.
+func (g *HPAGraph) linkCrossings(ci int) []hpaCrossing {
+	if g.pl.Links == nil {
+		return nil
+	}
+	env, f := g.pl.Env, g.pl.Filter
+	var out []hpaCrossing
+	g.pl.Links.ForEach(func(_ LinkID, l Link) bool {
+		fqx, fqy := subCellOf(l.From.Point2d())
+		tqx, tqy := subCellOf(l.To.Point2d())
+		fc, ok1 := g.clusterOf(fqx, fqy)
+		tc, ok2 := g.clusterOf(tqx, tqy)
+		if !ok1 || !ok2 || fc == tc || (fc != ci && tc != ci) {
+			return true
+		}
+		from, ok1 := footprintStand(env, fqx, fqy, int32(l.From.H), f)
+		to, ok2 := footprintStand(env, tqx, tqy, int32(l.To.H), f)
+		if ok1 && ok2 {
+			out = append(out, hpaCrossing{from: hpaNode{fqx, fqy, from}, to: hpaNode{tqx, tqy, to}, cost: l.cost(), link: l.Type})
+		}
+		return true
+	})
+	return out
+}
+
+// rebuildIntra runs one bounded Dijkstra per portal of c.
+func (g *HPAGraph) rebuildIntra(c *hpaCluster) {
+	g.rebuilt++
+	c.intra = make([][]hpaEdge, len(c.portals))
+	for i, p := range c.portals {
+		s := g.localSearch(c, hpaNode{qx: -1, qy: -1})
+		s.push(p.qx, p.qy, p.st, 0, -1)
+		s.run(0)
+		for j, q := range c.portals {
+			if j == i {
+				continue
+			}
+			if cost, ok := s.closedCost(q.qx, q.qy, q.st.h); ok {
+				c.intra[i] = append(c.intra[i], hpaEdge{to: int32(j), cost: cost})
+			}
+		}
+	}
+}
+
+// localSearch prepares a search bounded to c. A goal with qx < 0 runs
+// Dijkstra until every portal of c is closed.
+func (g *HPAGraph) localSearch(c *hpaCluster, goal hpaNode) *search {
+	s := newSearch(g.pl, goal.qx, goal.qy, goal.st.h)
+	if goal.qx < 0 {
+		s.dijkstra = true
+		s.targets = make(map[uint64]struct{}, len(c.portals))
+		for _, p := range c.portals {
+			s.targets[p.key()] = struct{}{}
+		}
+	}
+	qx0, qy0 := gridOrigin(g.pl.Env, c.gx, c.gy)
+	s.bound(qx0, qy0, qx0+gridSideSub, qy0+gridSideSub)
+	return s
+}
+
+// ---------------------------------------------------------------------------
+
+// abstract node ids: 0 = start, 1 = goal, 2+base[cluster]+portal otherwise.
+const (
+	hpaStart = 0
+	hpaGoal  = 1
+)
+
+type hpaState struct {
+	g      float32
+	parent int32
+	inter  bool     // reached from parent by a border crossing
+	link   LinkType // the link of that crossing, if any
+	closed bool
+}
+
+// FindPath answers a query on the abstract graph and refines it into a full
+// sub-cell path. Endpoints are resolved as in Planner.FindPath.
+func (g *HPAGraph) FindPath(start, goal zmap3base.Point3d) (res PathResult, ok bool) {
+	pl := g.pl
+	sqx, sqy := subCellOf(start.Point2d())
+	sst, relocated, ok := pl.resolveEndpoint(&sqx, &sqy, int32(start.H))
+	if !ok {
+		return res, false
+	}
+	res.StartRelocated = relocated
+	res.Start = point3dAt(sqx, sqy, sst.gap())
+
+	gqx, gqy := subCellOf(goal.Point2d())
+	gst, relocated, ok := pl.resolveEndpoint(&gqx, &gqy, int32(goal.H))
+	if !ok {
+		return res, false
+	}
+	res.GoalRelocated = relocated
+	res.Goal = point3dAt(gqx, gqy, gst.gap())
+
+	if pl.Regions != nil && !pl.Regions.connected(sqx, sqy, sst.h, gqx, gqy, gst.h) {
+		return res, false
+	}
+	sc, ok1 := g.clusterOf(sqx, sqy)
+	gc, ok2 := g.clusterOf(gqx, gqy)
+	if !ok1 || !ok2 {
+		return res, false
+	}
+
+	a := newHPAQuery(g, hpaNode{sqx, sqy, sst}, hpaNode{gqx, gqy, gst}, sc, gc)
+	path, ok := a.run()
+	res.Expanded = a.expanded
+	if !ok {
+		return res, false
+	}
+
+	for i := 1; i < len(path); i++ {
+		from, to := a.node(path[i-1]), a.node(path[i])
+		if a.states[path[i]].inter {
+			res.Waypoints = append(res.Waypoints, waypointAt(pl.Env, pl.Filter, to.qx, to.qy, to.st, a.states[path[i]].link))
+			continue
+		}
+		s := g.localSearch(g.clusters[a.clusterOfID(path[i])], to)
+		s.push(from.qx, from.qy, from.st, 0, -1)
+		end, ok := s.run(pl.MaxExpansions)
+		res.Expanded += s.expanded
+		if !ok {
+			return res, false
+		}
+		seg := s.reconstruct(end)
+		if len(res.Waypoints) > 0 {
+			seg = seg[1:]
+		}
+		res.Waypoints = append(res.Waypoints, seg...)
+	}
+	if len(res.Waypoints) == 0 {
+		res.Waypoints = []Waypoint{waypointAt(pl.Env, pl.Filter, sqx, sqy, sst, LinkNone)}
+	}
+	res.Cost = a.states[hpaGoal].g
+	return res, true
+}
+
+// hpaQuery is the abstract A* of one FindPath call.
+type hpaQuery struct {
+	g           *HPAGraph
+	start, goal hpaNode
+	sc, gc      int
+	base        []int32 // first abstract id of each cluster
+	states      map[int32]*hpaState
+	open        hpaOpen
+	startEdges  []hpaEdge // to abstract ids
+	goalCost    map[int32]float32
+	hscale      float32
+	bounds      linkBounds
+	expanded    int
+}
+
+func newHPAQuery(g *HPAGraph, start, goal hpaNode, sc, gc int) *hpaQuery {
+	a := &hpaQuery{
+		g: g, start: start, goal: goal, sc: sc, gc: gc,
+		base:     make([]int32, len(g.clusters)),
+		states:   make(map[int32]*hpaState),
+		goalCost: make(map[int32]float32),
+		hscale:   heuristicScale(g.pl.Cost),
+	}
+	a.bounds = newLinkBounds(g.pl.Links, goal.qx, goal.qy, a.hscale)
+	n := int32(2)
+	for i, c := range g.clusters {
+		a.base[i] = n
+		if c != nil {
+			n += int32(len(c.portals))
+		}
+	}
+
+	// start -> portals of its cluster (and the goal when it shares it).
+	s := g.localSearch(g.clusters[sc], hpaNode{qx: -1, qy: -1})
+	if sc == gc {
+		s.targets[goal.key()] = struct{}{}
+	}
+	s.push(start.qx, start.qy, start.st, 0, -1)
+	s.run(0)
+	a.expanded += s.expanded
+	for i, p := range g.clusters[sc].portals {
+		if cost, ok := s.closedCost(p.qx, p.qy, p.st.h); ok {
+			a.startEdges = append(a.startEdges, hpaEdge{to: a.base[sc] + int32(i), cost: cost})
+		}
+	}
+	if sc == gc {
+		if cost, ok := s.closedCost(goal.qx, goal.qy, goal.st.h); ok {
+			a.startEdges = append(a.startEdges, hpaEdge{to: hpaGoal, cost: cost})
+		}
+	}
+
+	// portals of the goal cluster -> goal, by one Dijkstra backwards from
+	// the goal.
+	s = g.localSearch(g.clusters[gc], hpaNode{qx: -1, qy: -1})
+	s.reverse = true
+	s.push(goal.qx, goal.qy, goal.st, 0, -1)
+	s.run(0)
+	a.expanded += s.expanded
+	for i, p := range g.clusters[gc].portals {
+		if cost, ok := s.closedCost(p.qx, p.qy, p.st.h); ok {
+			a.goalCost[a.base[gc]+int32(i)] = cost
+		}
+	}
+	return a
+}
+
+func (a *hpaQuery) clusterOfID(id int32) int {
+	switch id {
+	case hpaStart:
+		return a.sc
+	case hpaGoal:
+		return a.gc
+	}
+	i := sort.Search(len(a.base), func(i int) bool { return a.base[i] > id }) - 1
+	return i
+}
+
+func (a *hpaQuery) node(id int32) hpaNode {
+	switch id {
+	case hpaStart:
+		return a.start
+	case hpaGoal:
+		return a.goal
+	}
+	ci := a.clusterOfID(id)
+	return a.g.clusters[ci].portals[id-a.base[ci]]
+}
+
+func (a *hpaQuery) heuristic(id int32) float32 {
+	n := a.node(id)
+	return a.bounds.apply(n.qx, n.qy, a.hscale, a.hscale*octile(a.goal.qx-n.qx, a.goal.qy-n.qy))
+}
+
+func (a *hpaQuery) relax(from, to int32, cost float32, inter bool, link LinkType) {
+	g := a.states[from].g + cost
+	st, ok := a.states[to]
+	if !ok {
+		st = &hpaState{g: g, parent: from, inter: inter, link: link}
+		a.states[to] = st
+	} else if st.closed || g >= st.g {
+		return
+	} else {
+		st.g, st.parent, st.inter, st.link = g, from, inter, link
+	}
+	heap.Push(&a.open, hpaOpenItem{id: to, f: g + a.heuristic(to), g: g})
+}
+
+func (a *hpaQuery) run() ([]int32, bool) {
+	a.states[hpaStart] = &hpaState{parent: -1}
+	heap.Push(&a.open, hpaOpenItem{id: hpaStart, f: a.heuristic(hpaStart)})
+	for a.open.Len() > 0 {
+		it := heap.Pop(&a.open).(hpaOpenItem)
+		st := a.states[it.id]
+		if st.closed || it.g > st.g {
+			continue
+		}
+		st.closed = true
+		a.expanded++
+		if it.id == hpaGoal {
+			var path []int32
+			for id := int32(hpaGoal); id >= 0; id = a.states[id].parent {
+				path = append(path, id)
+			}
+			for i, j := 0, len(path)-1; i < j; i, j = i+1, j-1 {
+				path[i], path[j] = path[j], path[i]
+			}
+			return path, true
+		}
+		if it.id == hpaStart {
+			for _, e := range a.startEdges {
+				a.relax(hpaStart, e.to, e.cost, false, LinkNone)
+			}
+			continue
+		}
+		ci := a.clusterOfID(it.id)
+		c := a.g.clusters[ci]
+		pi := it.id - a.base[ci]
+		for _, e := range c.intra[pi] {
+			a.relax(it.id, a.base[ci]+e.to, e.cost, false, LinkNone)
+		}
+		for _, l := range c.inter[pi] {
+			tc := a.g.clusters[l.cluster]
+			if j, ok := tc.index[l.key]; ok {
+				a.relax(it.id, a.base[l.cluster]+j, l.cost, true, l.link)
+			}
+		}
+		if cost, ok := a.goalCost[it.id]; ok {
+			a.relax(it.id, hpaGoal, cost, false, LinkNone)
+		}
+	}
+	return nil, false
+}
+
+type hpaOpenItem struct {
+	id   int32
+	f, g float32
+}
+
+// hpaOpen is a lazy-deletion heap ordered like openList.
+type hpaOpen struct {
+	items []hpaOpenItem
+}
+
+func (o hpaOpen) Len() int { return len(o.items) }
+func (o hpaOpen) Less(i, j int) bool {
+	if o.items[i].f == o.items[j].f {
+		return o.items[i].g > o.items[j].g
+	}
+	return o.items[i].f < o.items[j].f
+}
+func (o hpaOpen) Swap(i, j int) { o.items[i], o.items[j] = o.items[j], o.items[i] }
+func (o *hpaOpen) Push(x any)   { o.items = append(o.items, x.(hpaOpenItem)) }
+func (o *hpaOpen) Pop() any {
+	n := len(o.items)
+	it := o.items[n-1]
+	o.items = o.items[:n-1]
+	return it
+}
@@ -0,0 +1,162 @@
+package navgation
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// buildGridsEnv builds a gw x gh grid Env; fill returns the fixture of the LP
+// cell at world (x, y).
+func buildGridsEnv(t testing.TB, gw, gh int, fill func(x, y int) cellFixture) *zmap3base.Env {
+	t.Helper()
+	const side = zmap3base.FastGridSetSize
+
+	env := zmap3base.NewEnv(zmap3base.Rect{
+		Min: zmap3base.Point2d{X: 0, Y: 0},
+		Max: zmap3base.Point2d{X: uint16(gw * side), Y: uint16(gh * side)},
+	})
+	grids := make([]*zmap3base.GridRBData, gw*gh)
+	for gy := 0; gy < gh; gy++ {
+		for gx := 0; gx < gw; gx++ {
+			lpPerCell := make([][]zmap3base.RichRange, zmap3base.FastGridCellNum)
+			hpPerCell := make([][zmap3base.SecondaryTileNum][]zmap3base.RichRange, zmap3base.FastGridCellNum)
+			for i := 0; i < zmap3base.FastGridCellNum; i++ {
+				c := fill(gx*side+i%side, gy*side+i/side)
+				lpPerCell[i] = append([]zmap3base.RichRange{c.terrain}, c.lpPayload...)
+			}
+			grid, err := zmap3base.BuildGridRBDataFromSlices(uint16(gx*side), uint16(gy*side), lpPerCell, hpPerCell)
+			if err != nil {
+				t.Fatalf("BuildGridRBDataFromSlices failed: %v", err)
+			}
+			grids[gx+gy*gw] = grid
+		}
+	}
+
+	v := reflect.ValueOf(env).Elem().FieldByName("grids")
+	reflect.NewAt(v.Type(), unsafe.Pointer(v.UnsafeAddr())).Elem().Set(reflect.ValueOf(grids))
+	return env
+}
+
+// checkPathMoves verifies every waypoint pair is a single planner move.
+func checkPathMoves(t *testing.T, env *zmap3base.Env, path []Waypoint, f Filter) {
+	t.Helper()
+	for i := 1; i < len(path); i++ {
+		aqx, aqy := subCellOf(path[i-1].Point2d())
+		bqx, bqy := subCellOf(path[i].Point2d())
+		found := false
+		forEachStep(env, aqx, aqy, int32(path[i-1].H), f, func(nx, ny int32, st stand, _ float32) {
+			if nx == bqx && ny == bqy && st.h == path[i].H {
+				found = true
+			}
+		})
+		if !found {
+			t.Fatalf("step %d is not a planner move: %+v -> %+v", i, path[i-1].Point3d, path[i].Point3d)
+		}
+	}
+}
+
+func TestHPAGraph(t *testing.T) {
+	// 3x2 grids. A wall on x=40 leaves a door at y=50..51 only.
+	env := buildGridsEnv(t, 3, 2, func(x, y int) cellFixture {
+		if x == 40 && (y < 50 || y > 51) {
+			return cellFixture{terrain: rr(0, 60, testTexBase)}
+		}
+		return cellFixture{terrain: rr(0, 10, testTexBase)}
+	})
+	f := testFilter()
+	pl := NewPlanner(env, f)
+	hg := NewHPAGraph(pl)
+	defer hg.Detach()
+
+	start, goal := subPoint(8, 8, 10), subPoint(360, 16, 10)
+	want, ok := pl.FindPath(start, goal)
+	if !ok {
+		t.Fatalf("A* found no path")
+	}
+	got, ok := hg.FindPath(start, goal)
+	if !ok {
+		t.Fatalf("HPA* found no path")
+	}
+	checkPathMoves(t, env, got.Waypoints, f)
+	if got.Waypoints[0].Point3d != want.Waypoints[0].Point3d ||
+		got.Waypoints[len(got.Waypoints)-1].Point3d != want.Waypoints[len(want.Waypoints)-1].Point3d {
+		t.Fatalf("endpoints differ")
+	}
+	if got.Cost < want.Cost-1e-3 || got.Cost > want.Cost*1.2 {
+		t.Fatalf("HPA* cost %v too far from A* cost %v", got.Cost, want.Cost)
+	}
+
+	// Same-cluster query.
+	got, ok = hg.FindPath(subPoint(8, 8, 10), subPoint(100, 100, 10))
+	if !ok {
+		t.Fatalf("expected a local path")
+	}
+	checkPathMoves(t, env, got.Waypoints, f)
+
+	// An interior edit only rebuilds its own cluster.
+	hg.rebuilt = 0
+	acc := zmap3base.Accessory{Texture: testTexObs, Config: 3}
+	if !env.ApplyRichOperationsExt([]zmap3base.Point3d{{X: 80, Y: 10, H: 10, RangeEnd: 60}}, nil, acc) {
+		t.Fatalf("edit failed")
+	}
+	if hg.rebuilt != 1 {
+		t.Fatalf("interior edit rebuilt %d clusters", hg.rebuilt)
+	}
+
+	// Closing the door makes the goal unreachable for both planners.
+	door := []zmap3base.Point3d{{X: 40, Y: 50, H: 10, RangeEnd: 60}, {X: 40, Y: 51, H: 10, RangeEnd: 60}}
+	if !env.ApplyRichOperationsExt(door, nil, acc) {
+		t.Fatalf("edit failed")
+	}
+	if _, ok := hg.FindPath(start, goal); ok {
+		t.Fatalf("HPA* found a path through a closed door")
+	}
+	if _, ok := pl.FindPath(start, goal); ok {
+		t.Fatalf("A* found a path through a closed door")
+	}
+
+	// Reopening it restores the route.
+	if !env.ApplyRichOperationsExt(nil, door, acc) {
+		t.Fatalf("edit failed")
+	}
+	got, ok = hg.FindPath(start, goal)
+	if !ok {
+		t.Fatalf("HPA* found no path after reopening")
+	}
+	checkPathMoves(t, env, got.Waypoints, f)
+}
+
+func TestHPAGraph_OneWayGoalCluster(t *testing.T) {
+	// A 3m pit in the third grid: agents drop in but cannot climb out, so
+	// the goal side must be priced portal -> goal, not goal -> portal.
+	env := buildGridsEnv(t, 3, 1, func(x, y int) cellFixture {
+		if x >= 80 && x < 86 && y >= 4 && y < 10 {
+			return cellFixture{terrain: rr(0, 10, testTexBase)}
+		}
+		return cellFixture{terrain: rr(0, 70, testTexBase)}
+	})
+	f := testFilter()
+	pl := NewPlanner(env, f)
+	hg := NewHPAGraph(pl)
+	defer hg.Detach()
+
+	top, pit := subPoint(8, 8, 70), subPoint(330, 28, 10)
+	want, ok := pl.FindPath(top, pit)
+	if !ok {
+		t.Fatalf("A* found no path into the pit")
+	}
+	got, ok := hg.FindPath(top, pit)
+	if !ok {
+		t.Fatalf("HPA* found no path into the pit")
+	}
+	checkPathMoves(t, env, got.Waypoints, f)
+	if got.Cost < want.Cost-1e-3 || got.Cost > want.Cost*1.2 {
+		t.Fatalf("HPA* cost %v too far from A* cost %v", got.Cost, want.Cost)
+	}
+	if _, ok := hg.FindPath(pit, top); ok {
+		t.Fatalf("HPA* climbed out of the pit")
+	}
+}
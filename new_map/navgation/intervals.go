@@ -0,0 +1,157 @@
+package navgation
+
+import (
+	"math"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// Gap is one standable interval of a sub-column.
+// Range is [floor, ceiling); Accessory belongs to the span the agent stands on.
+type Gap struct {
+	zmap3base.Range
+	Accessory zmap3base.Accessory
+}
+
+// Floor returns the standing height in 1/20m.
+func (g Gap) Floor() uint16 { return g.Begin }
+
+// Ceiling returns the first blocked height above the floor in 1/20m.
+func (g Gap) Ceiling() uint16 { return g.End }
+
+// ForEachInterval visits every standable gap of p2d from bottom to top.
+// Unlike GetInterval it ignores up/down limits: every gap that satisfies the
+// height, ignore and forbidden rules of f is reported. visit returning false
+// stops the walk. It does not allocate.
+func ForEachInterval(env *zmap3base.Env, p2d zmap3base.Point2d, f Filter, visit func(g Gap) bool) bool {
+	if env == nil {
+		return false
+	}
+	rc, ok := env.Route(p2d)
+	if !ok {
+		return false
+	}
+	return ForEachIntervalRouted(rc, f, visit)
+}
+
+// ForEachIntervalRouted is ForEachInterval with a precomputed route context.
+func ForEachIntervalRouted(rc zmap3base.RouteCtx, f Filter, visit func(g Gap) bool) bool {
+	buf := fastRichRangeSlicePool.Get().(*[]zmap3base.RichRange)
+	spans := (*buf)[:0]
+
+	terrain, spans, ok := collectRoutedSpans(rc, spans)
+	if ok {
+		if f.swim {
+			spans = f.dropWadeable(spans)
+		}
+		sortSpansByEndBegin(spans)
+		walkGaps(terrain, spans, f, visit)
+	}
+
+	recycleFastSpanBuf(buf, spans)
+	return ok
+}
+
+// AllIntervals returns every standable gap of p2d from bottom to top.
+func AllIntervals(env *zmap3base.Env, p2d zmap3base.Point2d, f Filter) []Gap {
+	var out []Gap
+	ForEachInterval(env, p2d, f, func(g Gap) bool {
+		out = append(out, g)
+		return true
+	})
+	return out
+}
+
+// ColumnSpans returns the terrain of p2d and the spans GetInterval merges
+// above it (the LP spans plus, on high-precision cells, those of the
+// sub-cell), sorted by End then Begin.
+func ColumnSpans(env *zmap3base.Env, p2d zmap3base.Point2d) (terrain zmap3base.RichRange, spans []zmap3base.RichRange, ok bool) {
+	if env == nil {
+		return terrain, nil, false
+	}
+	rc, ok := env.Route(p2d)
+	if !ok {
+		return terrain, nil, false
+	}
+	terrain, spans, ok = collectRoutedSpans(rc, nil)
+	sortSpansByEndBegin(spans)
+	return terrain, spans, ok
+}
+
+// collectRoutedSpans is the RouteCtx flavour of collectTerrainAndSpans.
+func collectRoutedSpans(rc zmap3base.RouteCtx, spans []zmap3base.RichRange) (zmap3base.RichRange, []zmap3base.RichRange, bool) {
+	g := rc.G
+	if g == nil {
+		return zmap3base.RichRange{}, spans, false
+	}
+	d := g.CellByIdx(rc.CellIdx)
+	if d == nil {
+		return zmap3base.RichRange{}, spans, false
+	}
+	terrain, ok := resolveTerrainFast(g, d, rc.CellIdx)
+	if !ok {
+		return zmap3base.RichRange{}, spans, false
+	}
+
+	hasAnyHP := d.HighPrecision != nil && d.HighPrecision.Has != 0
+	effIsHP := rc.IsHP
+	effSubIdx := rc.SubIdx
+	if effIsHP {
+		if !hasAnyHP {
+			effIsHP = false
+			effSubIdx = 0
+		}
+	} else if hasAnyHP {
+		effIsHP = true
+		effSubIdx = 0
+	}
+
+	spans = appendLPSourceRangesFast(g, d, rc.CellIdx, terrain, spans)
+	if effIsHP {
+		spans = appendHPSourceRangesFast(g, d, rc.CellIdx, effSubIdx, terrain, spans)
+	}
+	return terrain, spans, true
+}
+
+// walkGaps mirrors intervalGeneral without the curY window. spans must be
+// sorted by sortSpansByEndBegin.
+func walkGaps(terrain zmap3base.RichRange, spans []zmap3base.RichRange, f Filter, visit func(g Gap) bool) {
+	ignore := zmap3base.Texture(f.ignoreTexture)
+	forbidden := zmap3base.Texture(f.forbiddenTexture)
+	need := f.height
+	if need < 1 {
+		need = 1
+	}
+
+	gapMinY := terrain.End
+	gapAcc := terrain.Accessory
+
+	emit := func(gapMaxY uint16) bool {
+		if int32(gapMaxY)-int32(gapMinY) < need {
+			return true
+		}
+		if forbidden != 0 && (gapAcc.Texture&forbidden) != 0 {
+			return true
+		}
+		return visit(Gap{Range: zmap3base.Range{Begin: gapMinY, End: gapMaxY}, Accessory: gapAcc})
+	}
+
+	for i := 0; i < len(spans); i++ {
+		v := spans[i]
+		if ignore != 0 && (v.Accessory.Texture&ignore) != 0 {
+			continue
+		}
+		if v.End <= gapMinY {
+			continue
+		}
+		if v.Begin >= gapMinY {
+			if !emit(v.Begin) {
+				return
+			}
+		}
+		gapMinY = v.End
+		gapAcc = v.Accessory
+	}
+
+	emit(uint16(math.MaxUint16))
+}
@@ -0,0 +1,131 @@
+package navgation
+
+import (
+	"math"
+	"math/rand"
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestAllIntervals_MultiStorey(t *testing.T) {
+	cellIdx := 2 + 2*zmap3base.FastGridSetSize
+	env := buildSingleGridEnv(t, map[int]cellFixture{
+		cellIdx: {
+			terrain: rr(0, 10, testTexBase),
+			lpPayload: []zmap3base.RichRange{
+				{Range: zmap3base.Range{Begin: 50, End: 55}, Accessory: zmap3base.Accessory{Texture: testTexObs, Config: 7}},
+				rr(100, 104, testTexCol),
+				rr(110, 112, testTexObs),
+			},
+		},
+	})
+
+	got := AllIntervals(env, zmap3base.Point2d{X: 2, Y: 2, XOffset: 1, YOffset: 1}, NewFilter(0, 0, 10, 0, 0))
+	want := []Gap{
+		{Range: zmap3base.Range{Begin: 10, End: 50}, Accessory: zmap3base.Accessory{Texture: testTexBase}},
+		{Range: zmap3base.Range{Begin: 55, End: 100}, Accessory: zmap3base.Accessory{Texture: testTexObs, Config: 7}},
+		{Range: zmap3base.Range{Begin: 112, End: math.MaxUint16}, Accessory: zmap3base.Accessory{Texture: testTexObs}},
+	}
+	if len(got) != len(want) {
+		t.Fatalf("gap count mismatch got=%+v", got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("gap[%d] got=%+v want=%+v", i, got[i], want[i])
+		}
+	}
+
+	forbidden := AllIntervals(env, zmap3base.Point2d{X: 2, Y: 2}, NewFilter(uint32(testTexCol), uint32(testTexBase), 10, 0, 0))
+	if len(forbidden) != 2 || forbidden[0].Begin != 55 || forbidden[1].Begin != 112 {
+		t.Fatalf("unexpected gaps with ignore/forbidden: %+v", forbidden)
+	}
+}
+
+func TestAllIntervals_FirstMatchEqualsGetInterval(t *testing.T) {
+	rng := rand.New(rand.NewSource(20260301))
+	cells := make(map[int]cellFixture, 30)
+	for i := 0; i < 30; i++ {
+		cellIdx := rng.Intn(zmap3base.FastGridCellNum)
+		terrainEnd := uint16(1 + rng.Intn(40))
+		cf := cellFixture{terrain: rr(0, terrainEnd, testTexBase)}
+		for j := rng.Intn(5); j > 0; j-- {
+			b := uint16(rng.Intn(300))
+			cf.lpPayload = append(cf.lpPayload, rr(b, b+uint16(1+rng.Intn(30)), testTexObs))
+		}
+		if rng.Intn(2) == 0 {
+			sub := rng.Intn(zmap3base.SecondaryTileNum)
+			b := uint16(rng.Intn(300))
+			cf.hpPayload = map[int][]zmap3base.RichRange{sub: {rr(b, b+uint16(1+rng.Intn(30)), testTexCol)}}
+		}
+		cells[cellIdx] = cf
+	}
+	env := buildSingleGridEnv(t, cells)
+
+	for i := 0; i < 2000; i++ {
+		p := zmap3base.Point2d{
+			X:       uint16(rng.Intn(zmap3base.FastGridSetSize)),
+			Y:       uint16(rng.Intn(zmap3base.FastGridSetSize)),
+			XOffset: uint8(1 + rng.Intn(zmap3base.SecondaryAccuracy)),
+			YOffset: uint8(1 + rng.Intn(zmap3base.SecondaryAccuracy)),
+		}
+		curY := int32(rng.Intn(320))
+		height := int32(1 + rng.Intn(20))
+		up := int32(rng.Intn(120))
+		down := int32(rng.Intn(120))
+		var ignore, forbidden uint32
+		if rng.Intn(3) == 0 {
+			ignore = uint32(testTexObs)
+		}
+		if rng.Intn(3) == 0 {
+			forbidden = uint32(testTexCol)
+		}
+
+		want, wantOK := GetIntervalFast(env, p, curY, ignore, forbidden, height, up, down)
+
+		var got Gap
+		gotOK := false
+		ForEachInterval(env, p, NewFilter(ignore, forbidden, height, up, down), func(g Gap) bool {
+			floor := int32(g.Begin)
+			if floor < curY-down || floor > curY+up || int32(g.End) < curY+height {
+				return true
+			}
+			got, gotOK = g, true
+			return false
+		})
+
+		if gotOK != wantOK {
+			t.Fatalf("case=%d ok mismatch got=%v want=%v p=%+v", i, gotOK, wantOK, p)
+		}
+		if gotOK && (got.Range != want.Range || got.Accessory.Texture != want.Texture) {
+			t.Fatalf("case=%d result mismatch got=%+v want=%+v p=%+v", i, got, want, p)
+		}
+	}
+}
+
+func TestForEachInterval_NoAlloc(t *testing.T) {
+	cellIdx := 4 + 4*zmap3base.FastGridSetSize
+	env := buildSingleGridEnv(t, map[int]cellFixture{
+		cellIdx: {
+			terrain:   rr(0, 40, testTexBase),
+			lpPayload: []zmap3base.RichRange{rr(80, 90, testTexObs), rr(140, 150, testTexObs)},
+		},
+	})
+	p := zmap3base.Point2d{X: 4, Y: 4, XOffset: 2, YOffset: 3}
+	f := NewFilter(0, 0, 10, 0, 0)
+	n := 0
+	visit := func(g Gap) bool {
+		n++
+		return true
+	}
+
+	allocs := testing.AllocsPerRun(200, func() {
+		ForEachInterval(env, p, f, visit)
+	})
+	if allocs != 0 {
+		t.Fatalf("expected zero allocations, got %v", allocs)
+	}
+	if n == 0 {
+		t.Fatalf("expected gaps to be visited")
+	}
+}
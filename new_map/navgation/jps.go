@@ -0,0 +1,282 @@
+package navgation
+
+import (
+	zmap3base "pathfinding/new_map"
+)
+
+// Jump point search (Planner.JumpPoints).
+//
+// Relative to a floor h every sub-cell is one of:
+//   - free: its footprint only covers LP cells whose column is bare terrain
+//     of height h (no HP data, no overlay spans, terrain texture neither
+//     ignored nor forbidden);
+//   - blocked: the footprint has no stand from h;
+//   - special: anything else (HP cells, overlays, steps, drops).
+//
+// Around a node whose 8 neighbours are free or blocked the graph is a uniform
+// 8-connected grid without corner cutting, so JPS pruning and jumping apply.
+// A node next to a special cell is a jump point and is expanded like plain
+// A*, which keeps the path cost optimal. Link sources are jump points too,
+// and link landings are fully expanded since they have no jump direction.
+
+const (
+	cellBlocked int8 = iota
+	cellFree
+	cellSpecial
+)
+
+// jpsCache holds the per-search classification caches in dense chunks so a
+// jump step costs a few array reads. Entries are stored +1 (0 = unknown).
+type jpsCache struct {
+	floors map[uint32]*[zmap3base.FastGridCellNum]int32 // LP cell -> bare terrain height
+	cells  map[uint64]*[gridSideSub * gridSideSub]int8  // (chunk, floor) -> class
+
+	lastCellKey uint64
+	lastCells   *[gridSideSub * gridSideSub]int8
+}
+
+func newJPSCache() *jpsCache {
+	return &jpsCache{
+		floors: make(map[uint32]*[zmap3base.FastGridCellNum]int32),
+		cells:  make(map[uint64]*[gridSideSub * gridSideSub]int8),
+	}
+}
+
+// uniformFloor returns the terrain height of LP cell (x, y) if its column is
+// bare terrain, or -1.
+func (s *search) uniformFloor(x, y int32) int32 {
+	key := uint32(x>>5)<<16 | uint32(y>>5)
+	chunk, ok := s.jps.floors[key]
+	if !ok {
+		chunk = new([zmap3base.FastGridCellNum]int32)
+		s.jps.floors[key] = chunk
+	}
+	i := x&31 | (y&31)<<5
+	if chunk[i] == 0 {
+		chunk[i] = s.computeUniformFloor(x, y) + 1
+	}
+	return chunk[i] - 1
+}
+
+func (s *search) computeUniformFloor(x, y int32) int32 {
+	if x < 0 || y < 0 || x > 0xFFFF || y > 0xFFFF {
+		return -1
+	}
+	rc, ok := s.pl.Env.Route(zmap3base.Point2d{X: uint16(x), Y: uint16(y)})
+	if !ok {
+		return -1
+	}
+	d := rc.G.CellByIdx(rc.CellIdx)
+	if d == nil || (d.HighPrecision != nil && d.HighPrecision.Has != 0) {
+		return -1
+	}
+
+	buf := fastRichRangeSlicePool.Get().(*[]zmap3base.RichRange)
+	terrain, spans, ok := collectRoutedSpans(rc, (*buf)[:0])
+	empty := len(spans) == 0
+	recycleFastSpanBuf(buf, spans)
+	if !ok || !empty {
+		return -1
+	}
+
+	tex := terrain.Accessory.Texture
+	f := s.pl.Filter
+	if (f.ignoreTexture != 0 && tex&zmap3base.Texture(f.ignoreTexture) != 0) ||
+		(f.forbiddenTexture != 0 && tex&zmap3base.Texture(f.forbiddenTexture) != 0) {
+		return -1
+	}
+	return int32(terrain.End)
+}
+
+// classify returns the class of sub-cell (qx, qy) relative to floor h.
+// Sub-cells outside the search bounds are blocked.
+func (s *search) classify(qx, qy int32, h uint16) int8 {
+	if qx < 0 || qy < 0 || (s.bounded && (qx < s.minQX || qy < s.minQY || qx >= s.maxQX || qy >= s.maxQY)) {
+		return cellBlocked
+	}
+	c := s.jps
+	key := uint64(h)<<32 | uint64(qx>>7)<<16 | uint64(qy>>7)
+	if c.lastCells == nil || c.lastCellKey != key {
+		chunk, ok := c.cells[key]
+		if !ok {
+			chunk = new([gridSideSub * gridSideSub]int8)
+			c.cells[key] = chunk
+		}
+		c.lastCellKey, c.lastCells = key, chunk
+	}
+	i := qx&(gridSideSub-1) | (qy&(gridSideSub-1))<<7
+	if v := c.lastCells[i]; v != 0 {
+		return v - 1
+	}
+	cls := s.computeClass(qx, qy, h)
+	c.lastCells[i] = cls + 1
+	return cls
+}
+
+func (s *search) computeClass(qx, qy int32, h uint16) int8 {
+	n := s.pl.Filter.footprint()
+	for x := qx >> 2; x <= (qx+n-1)>>2; x++ {
+		for y := qy >> 2; y <= (qy+n-1)>>2; y++ {
+			if s.uniformFloor(x, y) != int32(h) {
+				if _, ok := footprintStand(s.pl.Env, qx, qy, int32(h), s.pl.Filter); ok {
+					return cellSpecial
+				}
+				return cellBlocked
+			}
+		}
+	}
+	return cellFree
+}
+
+func (s *search) free(qx, qy int32, h uint16) bool {
+	return s.classify(qx, qy, h) == cellFree
+}
+
+// nearSpecial reports whether any neighbour of (qx, qy) is special.
+func (s *search) nearSpecial(qx, qy int32, h uint16) bool {
+	for _, d := range stepDirs {
+		if s.classify(qx+d.dx, qy+d.dy, h) == cellSpecial {
+			return true
+		}
+	}
+	return false
+}
+
+// isJumpTarget reports whether a jump must stop at (qx, qy, h).
+func (s *search) isJumpTarget(qx, qy int32, h uint16) bool {
+	if qx == s.goalQX && qy == s.goalQY && h == s.goalH {
+		return true
+	}
+	if len(s.targets) > 0 {
+		_, ok := s.targets[nodeKey(qx, qy, h)]
+		return ok
+	}
+	return false
+}
+
+// expandJPS expands a node along its pruned directions and falls back to
+// plain expansion for the start node and next to special cells.
+func (s *search) expandJPS(cur int32) {
+	n := s.nodes[cur]
+	x, y, h := n.qx, n.qy, n.st.h
+	if n.parent < 0 || n.link != LinkNone || s.pl.Links.hasLinksFrom(x, y) || !s.free(x, y, h) || s.nearSpecial(x, y, h) {
+		s.expand(cur)
+		return
+	}
+	p := &s.nodes[n.parent]
+	dx, dy := sign32(x-p.qx), sign32(y-p.qy)
+
+	switch {
+	case dx != 0 && dy != 0:
+		if s.free(x, y+dy, h) {
+			s.pushJump(cur, 0, dy)
+		}
+		if s.free(x+dx, y, h) {
+			s.pushJump(cur, dx, 0)
+		}
+		if s.free(x, y+dy, h) && s.free(x+dx, y, h) {
+			s.pushJump(cur, dx, dy)
+		}
+	case dx != 0:
+		next, up, down := s.free(x+dx, y, h), s.free(x, y+1, h), s.free(x, y-1, h)
+		if next {
+			s.pushJump(cur, dx, 0)
+			if up {
+				s.pushJump(cur, dx, 1)
+			}
+			if down {
+				s.pushJump(cur, dx, -1)
+			}
+		}
+		if up {
+			s.pushJump(cur, 0, 1)
+		}
+		if down {
+			s.pushJump(cur, 0, -1)
+		}
+	default:
+		next, right, left := s.free(x, y+dy, h), s.free(x+1, y, h), s.free(x-1, y, h)
+		if next {
+			s.pushJump(cur, 0, dy)
+			if right {
+				s.pushJump(cur, 1, dy)
+			}
+			if left {
+				s.pushJump(cur, -1, dy)
+			}
+		}
+		if right {
+			s.pushJump(cur, 1, 0)
+		}
+		if left {
+			s.pushJump(cur, -1, 0)
+		}
+	}
+}
+
+func (s *search) pushJump(cur int32, dx, dy int32) {
+	n := s.nodes[cur]
+	jx, jy, ok := s.jump(n.qx+dx, n.qy+dy, dx, dy, n.st.h)
+	if !ok {
+		return
+	}
+	st, ok := footprintStand(s.pl.Env, jx, jy, int32(n.st.h), s.pl.Filter)
+	if !ok {
+		return
+	}
+	s.push(jx, jy, st, n.g+octile(jx-n.qx, jy-n.qy), cur)
+}
+
+// jump walks from (x, y) in direction (dx, dy) and returns the first jump
+// point, if any.
+func (s *search) jump(x, y, dx, dy int32, h uint16) (int32, int32, bool) {
+	for {
+		if !s.free(x, y, h) {
+			return 0, 0, false
+		}
+		if s.isJumpTarget(x, y, h) || s.pl.Links.hasLinksFrom(x, y) || s.nearSpecial(x, y, h) {
+			return x, y, true
+		}
+		switch {
+		case dx != 0 && dy != 0:
+			if _, _, ok := s.jump(x+dx, y, dx, 0, h); ok {
+				return x, y, true
+			}
+			if _, _, ok := s.jump(x, y+dy, 0, dy, h); ok {
+				return x, y, true
+			}
+		case dx != 0:
+			if (s.free(x, y-1, h) && !s.free(x-dx, y-1, h)) || (s.free(x, y+1, h) && !s.free(x-dx, y+1, h)) {
+				return x, y, true
+			}
+		default:
+			if (s.free(x-1, y, h) && !s.free(x-1, y-dy, h)) || (s.free(x+1, y, h) && !s.free(x+1, y-dy, h)) {
+				return x, y, true
+			}
+		}
+		// No corner cutting: both orthogonal moves must be free.
+		if !s.free(x+dx, y, h) || !s.free(x, y+dy, h) {
+			return 0, 0, false
+		}
+		x, y = x+dx, y+dy
+	}
+}
+
+func octile(dx, dy int32) float32 {
+	ax, ay := float32(abs32(dx)), float32(abs32(dy))
+	if ax > ay {
+		ax, ay = ay, ax
+	}
+	return (ay - ax) + ax*sqrt2
+}
+
+// fillJump appends the sub-cells strictly between a and b, which lie on one
+// straight or diagonal line of free cells.
+func (s *search) fillJump(dst []Waypoint, a, b *searchNode) []Waypoint {
+	dx, dy := sign32(b.qx-a.qx), sign32(b.qy-a.qy)
+	for qx, qy := a.qx+dx, a.qy+dy; qx != b.qx || qy != b.qy; qx, qy = qx+dx, qy+dy {
+		st, _ := footprintStand(s.pl.Env, qx, qy, int32(a.st.h), s.pl.Filter)
+		dst = append(dst, waypointAt(s.pl.Env, s.pl.Filter, qx, qy, st, LinkNone))
+	}
+	return dst
+}
@@ -0,0 +1,193 @@
+package navgation
+
+import (
+	"encoding/json"
+	"math"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// scene is a web_world_data export: start/goal x,z are sub-cells, y is 1/20m.
+type scene struct {
+	UI struct {
+		AgentH    float64 `json:"agentH"`
+		StepUp    float64 `json:"stepUp"`
+		StepDown  float64 `json:"stepDown"`
+		BanTex    string  `json:"banTex"`
+		IgnoreTex string  `json:"ignoreTex"`
+	} `json:"ui"`
+	Map struct {
+		Columns []struct {
+			MX      int          `json:"mx"`
+			MZ      int          `json:"mz"`
+			Terrain []uint32     `json:"terrain"`
+			Other   [][]uint32   `json:"other"`
+			HP      [][][]uint32 `json:"hp"`
+		} `json:"columns"`
+	} `json:"map"`
+	Start scenePoint `json:"start"`
+	Goal  scenePoint `json:"goal"`
+}
+
+type scenePoint struct {
+	X int32 `json:"x"`
+	Z int32 `json:"z"`
+	Y int32 `json:"y"`
+}
+
+func sceneRange(v []uint32) zmap3base.RichRange {
+	return rr(uint16(v[0]), uint16(v[1]), zmap3base.Texture(v[2]))
+}
+
+// loadScene loads a 32x32 web_world_data scene into a single grid Env.
+func loadScene(tb testing.TB, path string) (*zmap3base.Env, Filter, zmap3base.Point3d, zmap3base.Point3d) {
+	tb.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		tb.Fatalf("read scene: %v", err)
+	}
+	var sc scene
+	if err := json.Unmarshal(data, &sc); err != nil {
+		tb.Fatalf("parse scene: %v", err)
+	}
+
+	cells := make(map[int]cellFixture, len(sc.Map.Columns))
+	for _, c := range sc.Map.Columns {
+		fx := cellFixture{terrain: sceneRange(c.Terrain)}
+		if len(c.HP) > 0 {
+			fx.hpPayload = make(map[int][]zmap3base.RichRange)
+			for sub, spans := range c.HP {
+				for _, v := range spans {
+					fx.hpPayload[sub] = append(fx.hpPayload[sub], sceneRange(v))
+				}
+			}
+		} else {
+			for _, v := range c.Other {
+				fx.lpPayload = append(fx.lpPayload, sceneRange(v))
+			}
+		}
+		cells[cellIndex(c.MX, c.MZ)] = fx
+	}
+	env := buildSingleGridEnv(tb, cells)
+
+	toH := func(m float64) int32 { return int32(math.Min(m*zmap3base.HeightScale+0.5, math.MaxUint16)) }
+	var ban, ignore uint32
+	json.Unmarshal([]byte(sc.UI.BanTex), &ban)
+	json.Unmarshal([]byte(sc.UI.IgnoreTex), &ignore)
+	f := NewFilter(ignore, ban, toH(sc.UI.AgentH), toH(sc.UI.StepUp), toH(sc.UI.StepDown))
+
+	start := subPoint(sc.Start.X, sc.Start.Z, uint16(sc.Start.Y))
+	goal := subPoint(sc.Goal.X, sc.Goal.Z, uint16(sc.Goal.Y))
+	return env, f, start, goal
+}
+
+func sceneFiles(tb testing.TB) []string {
+	files, _ := filepath.Glob("../../web_world_data/*.json")
+	if len(files) == 0 {
+		tb.Skip("no web_world_data scenes")
+	}
+	return files
+}
+
+func sameCost(a, b float32) bool {
+	return math.Abs(float64(a-b)) <= 1e-3*math.Max(1, float64(a))
+}
+
+func TestJumpPoints_MatchesAStarOnScenes(t *testing.T) {
+	for _, file := range sceneFiles(t) {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			env, f, start, goal := loadScene(t, file)
+			pl := NewPlanner(env, f)
+			pl.RelocateRadius = 8
+			want, wantOK := pl.FindPath(start, goal)
+			pl.JumpPoints = true
+			got, gotOK := pl.FindPath(start, goal)
+			if gotOK != wantOK || !sameCost(got.Cost, want.Cost) {
+				t.Fatalf("JPS ok=%v cost=%v, A* ok=%v cost=%v", gotOK, got.Cost, wantOK, want.Cost)
+			}
+			if gotOK {
+				checkPathMoves(t, env, got.Waypoints, f)
+			}
+		})
+	}
+}
+
+func TestJumpPoints_MatchesAStarRandom(t *testing.T) {
+	rnd := rand.New(rand.NewSource(34))
+	for round := 0; round < 6; round++ {
+		cells := flatCells(10)
+		for i := 0; i < 120; i++ {
+			idx := cellIndex(rnd.Intn(32), rnd.Intn(32))
+			switch rnd.Intn(4) {
+			case 0: // wall
+				cells[idx] = cellFixture{terrain: rr(0, 60, testTexBase)}
+			case 1: // step
+				cells[idx] = cellFixture{terrain: rr(0, 18, testTexBase)}
+			case 2: // overlay bridge
+				cells[idx] = cellFixture{terrain: rr(0, 10, testTexBase), lpPayload: []zmap3base.RichRange{rr(40, 44, testTexObs)}}
+			default: // HP bump
+				cells[idx] = cellFixture{terrain: rr(0, 10, testTexBase), hpPayload: map[int][]zmap3base.RichRange{
+					rnd.Intn(zmap3base.SecondaryTileNum): {rr(10, 60, testTexCol)},
+				}}
+			}
+		}
+		env := buildSingleGridEnv(t, cells)
+		f := testFilter()
+		pl := NewPlanner(env, f)
+		jp := NewPlanner(env, f)
+		jp.JumpPoints = true
+
+		for q := 0; q < 20; q++ {
+			start := subPoint(int32(rnd.Intn(124)), int32(rnd.Intn(124)), 10)
+			goal := subPoint(int32(rnd.Intn(124)), int32(rnd.Intn(124)), 10)
+			want, wantOK := pl.FindPath(start, goal)
+			got, gotOK := jp.FindPath(start, goal)
+			if gotOK != wantOK || (gotOK && !sameCost(got.Cost, want.Cost)) {
+				t.Fatalf("round %d: %+v -> %+v: JPS ok=%v cost=%v, A* ok=%v cost=%v",
+					round, start, goal, gotOK, got.Cost, wantOK, want.Cost)
+			}
+			if gotOK {
+				checkPathMoves(t, env, got.Waypoints, f)
+			}
+		}
+	}
+}
+
+func TestJumpPoints_FewerExpansionsOnOpenGround(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	pl := NewPlanner(env, testFilter())
+	want, _ := pl.FindPath(subPoint(2, 3, 10), subPoint(120, 90, 10))
+	pl.JumpPoints = true
+	got, ok := pl.FindPath(subPoint(2, 3, 10), subPoint(120, 90, 10))
+	if !ok || !sameCost(got.Cost, want.Cost) || len(got.Waypoints) != len(want.Waypoints) {
+		t.Fatalf("JPS mismatch: ok=%v cost=%v/%v len=%d/%d", ok, got.Cost, want.Cost, len(got.Waypoints), len(want.Waypoints))
+	}
+	if got.Expanded*4 > want.Expanded {
+		t.Fatalf("expected far fewer expansions: JPS=%d A*=%d", got.Expanded, want.Expanded)
+	}
+}
+
+func benchmarkScenes(b *testing.B, jump bool) {
+	for _, file := range sceneFiles(b) {
+		b.Run(filepath.Base(file), func(b *testing.B) {
+			env, f, start, goal := loadScene(b, file)
+			pl := NewPlanner(env, f)
+			pl.RelocateRadius = 8
+			pl.JumpPoints = jump
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				benchPath, benchOK = pl.FindPath(start, goal)
+			}
+		})
+	}
+}
+
+var benchPath PathResult
+
+func BenchmarkPlannerScenes_AStar(b *testing.B)      { benchmarkScenes(b, false) }
+func BenchmarkPlannerScenes_JumpPoints(b *testing.B) { benchmarkScenes(b, true) }
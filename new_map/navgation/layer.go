@@ -0,0 +1,169 @@
+package navgation
+
+import (
+	zmap3base "pathfinding/new_map"
+)
+
+// FootprintLayer is a per-profile cache in front of the footprint checks of
+// one Env and Filter. It keeps the standable gaps of each visited sub-column
+// and the footprint stands already resolved for (sub-cell, curY), grouped by
+// 32m grid, so large footprints pay the n*n GetInterval work once instead of
+// on every expansion. Edits made through ApplyRichOperationsExt drop the
+// affected grids until Detach is called.
+//
+// Hand the Filter returned by Filter to any planner; filters derived from it
+// with other settings fall back to the uncached checks. Env listeners run in
+// registration order, so create the layer before any structure built with
+// its Filter (RegionMap, HPAGraph, FlowField, ClearanceField,
+// LedgeGenerator, PathCache, DStarLite): one registered earlier would
+// recompute from stale cached stands during the same edit. A
+// FootprintLayer is not safe for concurrent use.
+type FootprintLayer struct {
+	env        *zmap3base.Env
+	filter     Filter
+	grids      map[uint64]*layerGrid
+	listenerID int
+}
+
+type layerGrid struct {
+	cols   map[int32][]Gap       // local sub-cell -> gaps, bottom to top
+	stands map[uint64]layerStand // local sub-cell << 32 | curY
+}
+
+type layerStand struct {
+	s  stand
+	ok bool
+}
+
+// NewFootprintLayer returns an empty layer for f that follows the edits of
+// env. Create the layer before any structure built with its Filter.
+func NewFootprintLayer(env *zmap3base.Env, f Filter) *FootprintLayer {
+	f.layer = nil
+	l := &FootprintLayer{env: env, filter: f, grids: make(map[uint64]*layerGrid)}
+	l.listenerID = env.AddChangeListener(l.Update)
+	return l
+}
+
+// Filter returns the profile filter bound to the layer.
+func (l *FootprintLayer) Filter() Filter {
+	f := l.filter
+	f.layer = l
+	return f
+}
+
+// Detach stops listening to Env edits and drops the cache.
+func (l *FootprintLayer) Detach() {
+	if l.listenerID != 0 {
+		l.env.RemoveChangeListener(l.listenerID)
+		l.listenerID = 0
+	}
+	l.grids = make(map[uint64]*layerGrid)
+}
+
+// Update drops the cached data the changed LP points can affect: their
+// columns and every stand whose footprint may cover them. It is called
+// automatically for ApplyRichOperationsExt.
+func (l *FootprintLayer) Update(lps []zmap3base.Point2d) {
+	n := l.filter.footprint()
+	for _, lp := range lps {
+		qx0, qy0 := int32(lp.X)*zmap3base.SecondaryAccuracy, int32(lp.Y)*zmap3base.SecondaryAccuracy
+		if g := l.grids[layerGridKey(qx0, qy0)]; g != nil {
+			for dx := int32(0); dx < zmap3base.SecondaryAccuracy; dx++ {
+				for dy := int32(0); dy < zmap3base.SecondaryAccuracy; dy++ {
+					delete(g.cols, layerLocal(qx0+dx, qy0+dy))
+				}
+			}
+		}
+		last := int32(zmap3base.SecondaryAccuracy - 1)
+		for gx := (qx0 - n + 1) >> 7; gx <= (qx0+last)>>7; gx++ {
+			for gy := (qy0 - n + 1) >> 7; gy <= (qy0+last)>>7; gy++ {
+				if g := l.grids[layerGridKey(gx<<7, gy<<7)]; g != nil {
+					g.stands = nil
+				}
+			}
+		}
+	}
+}
+
+// cache returns the layer serving f on env, or nil.
+func (f Filter) cache(env *zmap3base.Env) *FootprintLayer {
+	l := f.layer
+	if l == nil || l.env != env {
+		return nil
+	}
+	f.layer = nil
+	if f != l.filter {
+		return nil
+	}
+	return l
+}
+
+func layerGridKey(qx, qy int32) uint64 {
+	return subKey(qx>>7, qy>>7)
+}
+
+func layerLocal(qx, qy int32) int32 {
+	return qx&(gridSideSub-1) | (qy&(gridSideSub-1))<<7
+}
+
+func (l *FootprintLayer) grid(qx, qy int32) *layerGrid {
+	key := layerGridKey(qx, qy)
+	g := l.grids[key]
+	if g == nil {
+		g = &layerGrid{cols: make(map[int32][]Gap)}
+		l.grids[key] = g
+	}
+	return g
+}
+
+// column returns the standable gaps of sub-column (qx, qy).
+func (l *FootprintLayer) column(qx, qy int32) []Gap {
+	g := l.grid(qx, qy)
+	i := layerLocal(qx, qy)
+	if gaps, ok := g.cols[i]; ok {
+		return gaps
+	}
+	var gaps []Gap
+	if p2d, ok := subCellPoint2d(qx, qy); ok {
+		ForEachInterval(l.env, p2d, l.filter, func(gap Gap) bool {
+			gaps = append(gaps, gap)
+			return true
+		})
+	}
+	g.cols[i] = gaps
+	return gaps
+}
+
+// stand is footprintStand through the cache.
+func (l *FootprintLayer) stand(qx, qy, curY int32) (stand, bool) {
+	g := l.grid(qx, qy)
+	key := uint64(uint32(layerLocal(qx, qy)))<<32 | uint64(uint32(curY))
+	if v, ok := g.stands[key]; ok {
+		return v.s, v.ok
+	}
+	s, ok := computeFootprintStand(l.env, qx, qy, curY, l.Filter())
+	if g.stands == nil {
+		g.stands = make(map[uint64]layerStand)
+	}
+	g.stands[key] = layerStand{s: s, ok: ok}
+	return s, ok
+}
+
+// intervalFromGaps picks what GetInterval returns for curY from the gaps of
+// one column: the lowest one whose floor is within the up/down limits and
+// whose ceiling leaves the agent height above curY.
+func intervalFromGaps(gaps []Gap, curY int32, f Filter) (res zmap3base.SnapRichRange, ok bool) {
+	for _, g := range gaps {
+		floor := int32(g.Begin)
+		if floor > curY+f.upLimit {
+			break
+		}
+		if floor < curY-f.downLimit || int32(g.End) < curY+f.height {
+			continue
+		}
+		res.Range = g.Range
+		res.Texture = g.Accessory.Texture
+		return res, true
+	}
+	return res, false
+}
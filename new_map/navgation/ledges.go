@@ -0,0 +1,268 @@
+package navgation
+
+import (
+	"sort"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// LedgeConfig bounds the links LedgeGenerator emits. Heights are in 1/20m,
+// distances in sub-cells.
+type LedgeConfig struct {
+	// MaxDrop is the deepest one-way drop; only drops deeper than the
+	// filter's down limit need a link.
+	MaxDrop int32
+	// MaxJumpHeight bounds the height difference of a jump in either
+	// direction, so a gap normally gets a link each way.
+	MaxJumpHeight int32
+	// MaxDist is the longest horizontal move of a drop or jump.
+	MaxDist int32
+	// Arc is the apex of a jump above the straight line between its ends.
+	Arc int32
+	// DropCost and JumpCost are added to the horizontal distance.
+	DropCost, JumpCost float32
+}
+
+// LedgeGenerator scans an Env for ledges and keeps drop and jump links for
+// them in a LinkRegistry. A ledge is a stand whose orthogonal neighbour has
+// no stand within the filter's step limits. From it the generator looks up
+// to MaxDist sub-cells ahead for the first landing reachable through a
+// clear ballistic corridor: a lower one beyond the down limit becomes a
+// LinkDrop, one within MaxJumpHeight a LinkJump.
+//
+// Links are stored per grid of their source and regenerated for the grids
+// around every ApplyRichOperationsExt edit. LedgeGenerator is not safe for
+// concurrent use with Env edits.
+type LedgeGenerator struct {
+	env    *zmap3base.Env
+	filter Filter
+	cfg    LedgeConfig
+	links  *LinkRegistry
+
+	gridW, gridH int
+	byGrid       [][]LinkID
+
+	listenerID int
+}
+
+// NewLedgeGenerator generates the links of every loaded grid into links.
+func NewLedgeGenerator(env *zmap3base.Env, f Filter, links *LinkRegistry, cfg LedgeConfig) *LedgeGenerator {
+	w, h := env.GridDims()
+	g := &LedgeGenerator{
+		env:    env,
+		filter: f,
+		cfg:    cfg,
+		links:  links,
+		gridW:  w,
+		gridH:  h,
+		byGrid: make([][]LinkID, w*h),
+	}
+	all := make([]int, w*h)
+	for i := range all {
+		all[i] = i
+	}
+	g.regenerate(all)
+	g.listenerID = env.AddChangeListener(g.Update)
+	return g
+}
+
+// Detach stops following Env edits. Generated links stay registered.
+func (g *LedgeGenerator) Detach() {
+	if g.listenerID != 0 {
+		g.env.RemoveChangeListener(g.listenerID)
+		g.listenerID = 0
+	}
+}
+
+// Update regenerates the grids around the changed LP points; corridors and
+// landings reach into the neighbour grids. The registry listeners hear of
+// all the removed links once and of all the new ones once. It is called
+// automatically for ApplyRichOperationsExt.
+func (g *LedgeGenerator) Update(lps []zmap3base.Point2d) {
+	dirty := make(map[int]struct{})
+	for _, p := range lps {
+		gx, gy, ok := g.env.GridCoordOf(p)
+		if !ok {
+			continue
+		}
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				x, y := gx+dx, gy+dy
+				if x >= 0 && y >= 0 && x < g.gridW && y < g.gridH {
+					dirty[x+y*g.gridW] = struct{}{}
+				}
+			}
+		}
+	}
+	grids := make([]int, 0, len(dirty))
+	for i := range dirty {
+		grids = append(grids, i)
+	}
+	sort.Ints(grids)
+	g.regenerate(grids)
+}
+
+// GridLinks returns the links generated from ledges in grid (gx, gy).
+func (g *LedgeGenerator) GridLinks(gx, gy int) []LinkID {
+	if gx < 0 || gy < 0 || gx >= g.gridW || gy >= g.gridH {
+		return nil
+	}
+	return g.byGrid[gx+gy*g.gridW]
+}
+
+// Regenerate replaces the links of grid (gx, gy). The registry listeners
+// hear of the removed and of the added links once each.
+func (g *LedgeGenerator) Regenerate(gx, gy int) {
+	if gx < 0 || gy < 0 || gx >= g.gridW || gy >= g.gridH {
+		return
+	}
+	g.regenerate([]int{gx + gy*g.gridW})
+}
+
+// regenerate replaces the links of the grids (gx + gy*gridW) with one
+// RemoveBatch and one AddBatch.
+func (g *LedgeGenerator) regenerate(grids []int) {
+	var old []LinkID
+	var found []Link
+	counts := make([]int, len(grids))
+	for k, i := range grids {
+		old = append(old, g.byGrid[i]...)
+		g.byGrid[i] = nil
+		n := len(found)
+		found = g.scanGrid(found, i%g.gridW, i/g.gridW)
+		counts[k] = len(found) - n
+	}
+	g.links.RemoveBatch(old)
+	ids := g.links.AddBatch(found)
+	for k, i := range grids {
+		g.byGrid[i], ids = ids[:counts[k]:counts[k]], ids[counts[k]:]
+	}
+}
+
+// scanGrid appends the links leaving the stands of grid (gx, gy) to out.
+func (g *LedgeGenerator) scanGrid(out []Link, gx, gy int) []Link {
+	if g.env.GridAt(gx, gy) == nil {
+		return out
+	}
+	qx0, qy0 := gridOrigin(g.env, gx, gy)
+	var floors []uint16
+	for ly := int32(0); ly < gridSideSub; ly++ {
+		for lx := int32(0); lx < gridSideSub; lx++ {
+			qx, qy := qx0+lx, qy0+ly
+			floors = appendFootprintFloors(floors[:0], g.env, qx, qy, g.filter)
+			sort.Slice(floors, func(i, j int) bool { return floors[i] < floors[j] })
+			for _, h := range floors[:dedupeSorted(floors)] {
+				st, ok := footprintStand(g.env, qx, qy, int32(h), g.filter)
+				if !ok || st.h != h {
+					continue
+				}
+				for d := 0; d < 4; d++ {
+					if l, ok := g.scan(qx, qy, st, stepDirs[d].dx, stepDirs[d].dy); ok {
+						out = append(out, l)
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// scan looks for the link leaving the stand st at (qx, qy) over the ledge in
+// direction (dx, dy).
+func (g *LedgeGenerator) scan(qx, qy int32, st stand, dx, dy int32) (Link, bool) {
+	f := g.filter
+	if _, ok := footprintStand(g.env, qx+dx, qy+dy, int32(st.h), f); ok {
+		return Link{}, false // an ordinary step
+	}
+	var floors []uint16
+	for d := int32(1); d <= g.cfg.MaxDist; d++ {
+		tqx, tqy := qx+dx*d, qy+dy*d
+		floors = appendFootprintFloors(floors[:0], g.env, tqx, tqy, f)
+		sort.Slice(floors, func(i, j int) bool { return floors[i] > floors[j] })
+		for k, h2 := range floors {
+			if k > 0 && h2 == floors[k-1] {
+				continue
+			}
+			to, ok := footprintStand(g.env, tqx, tqy, int32(h2), f)
+			if !ok || to.h != h2 {
+				continue
+			}
+			diff := int32(h2) - int32(st.h)
+			var l Link
+			switch {
+			case -diff > f.downLimit && -diff <= g.cfg.MaxDrop:
+				if !g.dropClear(qx, qy, dx, dy, d, st.h, h2) {
+					continue
+				}
+				l = Link{Type: LinkDrop, Cost: float32(d) + g.cfg.DropCost}
+			case d > 1 && abs32(diff) <= g.cfg.MaxJumpHeight:
+				if !g.jumpClear(qx, qy, dx, dy, d, st.h, h2) {
+					continue
+				}
+				l = Link{Type: LinkJump, Cost: float32(d) + g.cfg.JumpCost}
+			default:
+				continue
+			}
+			l.From = point3dAt(qx, qy, st.gap())
+			l.To = point3dAt(tqx, tqy, to.gap())
+			return l, true
+		}
+	}
+	return Link{}, false
+}
+
+// dropClear checks the corridor of a drop: the agent walks off at h and
+// falls onto h2 in the landing footprint.
+func (g *LedgeGenerator) dropClear(qx, qy, dx, dy, d int32, h, h2 uint16) bool {
+	top := int32(h) + g.filter.height
+	for i := int32(1); i < d; i++ {
+		if !g.bandClear(qx+dx*i, qy+dy*i, int32(h), top) {
+			return false
+		}
+	}
+	return g.bandClear(qx+dx*d, qy+dy*d, int32(h2), top)
+}
+
+// jumpClear checks the corridor of a jump: at every sub-cell along the way
+// the agent's body, raised by a parabolic arc, must lie in free space.
+func (g *LedgeGenerator) jumpClear(qx, qy, dx, dy, d int32, h, h2 uint16) bool {
+	for i := int32(1); i <= d; i++ {
+		t := float32(i) / float32(d)
+		y := float32(h) + (float32(h2)-float32(h))*t + 4*float32(g.cfg.Arc)*t*(1-t)
+		lo := int32(y)
+		if i == d {
+			lo = int32(h2)
+		}
+		if !g.bandClear(qx+dx*i, qy+dy*i, lo, int32(y+0.999)+g.filter.height) {
+			return false
+		}
+	}
+	return true
+}
+
+// bandClear reports whether [lo, hi) is free in every sub-column of the
+// footprint at (qx, qy). Forbidden floors do not block the air above them.
+func (g *LedgeGenerator) bandClear(qx, qy, lo, hi int32) bool {
+	air := NewFilter(g.filter.ignoreTexture, 0, 0, 0, 0)
+	n := g.filter.footprint()
+	for fx := int32(0); fx < n; fx++ {
+		for fy := int32(0); fy < n; fy++ {
+			p2d, ok := subCellPoint2d(qx+fx, qy+fy)
+			if !ok {
+				return false
+			}
+			clear := false
+			ForEachInterval(g.env, p2d, air, func(gap Gap) bool {
+				if int32(gap.Begin) <= lo && hi <= int32(gap.End) {
+					clear = true
+					return false
+				}
+				return int32(gap.Begin) <= lo
+			})
+			if !clear {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,100 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestLedgeGenerator(t *testing.T) {
+	cells := flatCells(100)
+	// A 2m platform at x=4..7, y=4..7 and a 4.5m deep trench along x=20.
+	for x := 4; x < 8; x++ {
+		for y := 4; y < 8; y++ {
+			cells[cellIndex(x, y)] = cellFixture{terrain: rr(0, 140, testTexBase)}
+		}
+	}
+	for y := 0; y < zmap3base.FastGridSetSize; y++ {
+		cells[cellIndex(20, y)] = cellFixture{terrain: rr(0, 10, testTexBase)}
+	}
+	env := buildSingleGridEnv(t, cells)
+	f := NewFilter(0, 0, 22, 22, 22)
+	links := NewLinkRegistry()
+	gen := NewLedgeGenerator(env, f, links, LedgeConfig{MaxDrop: 60, MaxJumpHeight: 10, MaxDist: 8, Arc: 10, JumpCost: 2})
+	defer gen.Detach()
+	if len(gen.GridLinks(0, 0)) == 0 || len(gen.GridLinks(0, 0)) != links.Len() {
+		t.Fatalf("generated %d links, registry holds %d", len(gen.GridLinks(0, 0)), links.Len())
+	}
+
+	pl := NewPlanner(env, f)
+	pl.Links = links
+	platform, ground := subPoint(20, 20, 140), subPoint(40, 40, 100)
+	res, ok := pl.FindPath(platform, ground)
+	if !ok || countLinks(res.Waypoints, LinkDrop) != 1 {
+		t.Fatalf("drop: ok=%v drops=%d", ok, countLinks(res.Waypoints, LinkDrop))
+	}
+	checkLinkPath(t, env, res.Waypoints, f)
+	if _, ok := pl.FindPath(ground, platform); ok {
+		t.Fatalf("climbed back onto the platform over a one-way drop")
+	}
+
+	west, east := subPoint(60, 60, 100), subPoint(100, 60, 100)
+	if _, ok := NewPlanner(env, f).FindPath(west, east); ok {
+		t.Fatalf("crossed the trench without links")
+	}
+	for _, q := range [][2]zmap3base.Point3d{{west, east}, {east, west}} {
+		res, ok := pl.FindPath(q[0], q[1])
+		if !ok || countLinks(res.Waypoints, LinkJump) != 1 {
+			t.Fatalf("jump %+v -> %+v: ok=%v jumps=%d", q[0], q[1], ok, countLinks(res.Waypoints, LinkJump))
+		}
+		checkLinkPath(t, env, res.Waypoints, f)
+	}
+
+	// A beam over the trench blocks every jump corridor; the grid is
+	// regenerated on the edit.
+	beam := make([]zmap3base.Point3d, 0, zmap3base.FastGridSetSize)
+	for y := 0; y < zmap3base.FastGridSetSize; y++ {
+		beam = append(beam, zmap3base.Point3d{X: 20, Y: uint16(y), H: 120, RangeEnd: 200})
+	}
+	if !env.ApplyRichOperationsExt(beam, nil, zmap3base.Accessory{Texture: testTexObs, Config: 1}) {
+		t.Fatalf("add beam failed")
+	}
+	if _, ok := pl.FindPath(west, east); ok {
+		t.Fatalf("jumped through the beam")
+	}
+	if _, ok := pl.FindPath(platform, ground); !ok {
+		t.Fatalf("drop lost after an unrelated edit")
+	}
+	if len(gen.GridLinks(0, 0)) != links.Len() {
+		t.Fatalf("stale links kept: grid %d, registry %d", len(gen.GridLinks(0, 0)), links.Len())
+	}
+}
+
+func TestLedgeGenerator_OneNotificationPerEdit(t *testing.T) {
+	// A trench in each grid; an edit dirties both.
+	env := buildGridsEnv(t, 2, 1, func(x, y int) cellFixture {
+		if x%zmap3base.FastGridSetSize == 20 {
+			return cellFixture{terrain: rr(0, 10, testTexBase)}
+		}
+		return cellFixture{terrain: rr(0, 100, testTexBase)}
+	})
+	links := NewLinkRegistry()
+	gen := NewLedgeGenerator(env, NewFilter(0, 0, 22, 22, 22), links, LedgeConfig{MaxDrop: 60, MaxJumpHeight: 10, MaxDist: 8, Arc: 10, JumpCost: 2})
+	defer gen.Detach()
+	if len(gen.GridLinks(0, 0)) == 0 || len(gen.GridLinks(1, 0)) == 0 {
+		t.Fatalf("no links generated: %d, %d", len(gen.GridLinks(0, 0)), len(gen.GridLinks(1, 0)))
+	}
+	before := links.Len()
+
+	calls := 0
+	links.AddChangeListener(func([]zmap3base.Point2d) { calls++ })
+	if !env.ApplyRichOperationsExt([]zmap3base.Point3d{{X: 2, Y: 2, H: 100, RangeEnd: 120}}, nil, zmap3base.Accessory{Texture: testTexObs}) {
+		t.Fatalf("edit failed")
+	}
+	if calls != 2 {
+		t.Fatalf("edit sent %d link notifications, want 2", calls)
+	}
+	if links.Len() != before || len(gen.GridLinks(0, 0))+len(gen.GridLinks(1, 0)) != before {
+		t.Fatalf("links after the edit: registry %d, grids %d+%d, before %d", links.Len(), len(gen.GridLinks(0, 0)), len(gen.GridLinks(1, 0)), before)
+	}
+}
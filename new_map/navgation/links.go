@@ -0,0 +1,398 @@
+package navgation
+
+import (
+	"math"
+	"sort"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// LinkType is the traversal type of an off-mesh link.
+type LinkType uint8
+
+const (
+	LinkNone LinkType = iota // an ordinary step
+	LinkLadder
+	LinkJump
+	LinkTeleport
+	LinkDoor
+	LinkDrop // one-way drop off a ledge
+)
+
+func (t LinkType) String() string {
+	switch t {
+	case LinkNone:
+		return "none"
+	case LinkLadder:
+		return "ladder"
+	case LinkJump:
+		return "jump"
+	case LinkTeleport:
+		return "teleport"
+	case LinkDoor:
+		return "door"
+	case LinkDrop:
+		return "drop"
+	}
+	return "unknown"
+}
+
+// Link is a directed off-mesh connection. From and To address footprint
+// reference sub-cells; From.H must resolve to the stand the link starts from
+// and the agent lands on the stand found from To.H at To.
+type Link struct {
+	From, To zmap3base.Point3d
+	Type     LinkType
+	// Cost in sub-cell units; <= 0 means the horizontal octile distance.
+	Cost float32
+}
+
+func (l *Link) cost() float32 {
+	if l.Cost > 0 {
+		return l.Cost
+	}
+	fqx, fqy := subCellOf(l.From.Point2d())
+	tqx, tqy := subCellOf(l.To.Point2d())
+	return float32(math.Max(1, float64(octile(tqx-fqx, tqy-fqy))))
+}
+
+// LinkID identifies a link in its registry.
+type LinkID int32
+
+// LinkRegistry stores off-mesh links for the planners of one Env. Planners
+// read it during expansion; RegionMap, FlowField and HPAGraph listen to its
+// changes like they listen to Env edits. It is not safe for concurrent use.
+type LinkRegistry struct {
+	links []Link
+	alive []bool
+	free  []LinkID
+
+	from map[uint64][]LinkID // source sub-cell -> links
+	to   map[uint64][]LinkID // landing sub-cell -> links
+
+	listeners    map[int]zmap3base.ChangeListener
+	nextListener int
+}
+
+func NewLinkRegistry() *LinkRegistry {
+	return &LinkRegistry{
+		from: make(map[uint64][]LinkID),
+		to:   make(map[uint64][]LinkID),
+	}
+}
+
+func subKey(qx, qy int32) uint64 {
+	return uint64(uint32(qx))<<32 | uint64(uint32(qy))
+}
+
+// Add registers l and returns its id.
+func (r *LinkRegistry) Add(l Link) LinkID {
+	id := r.add(l)
+	r.notify(linkPoints(nil, l))
+	return id
+}
+
+// AddBatch registers ls and returns their ids in order. Listeners are called
+// once with the ends of every link.
+func (r *LinkRegistry) AddBatch(ls []Link) []LinkID {
+	if len(ls) == 0 {
+		return nil
+	}
+	ids := make([]LinkID, len(ls))
+	var lps []zmap3base.Point2d
+	for i, l := range ls {
+		ids[i] = r.add(l)
+		lps = linkPoints(lps, l)
+	}
+	r.notify(lps)
+	return ids
+}
+
+func (r *LinkRegistry) add(l Link) LinkID {
+	var id LinkID
+	if n := len(r.free); n > 0 {
+		id = r.free[n-1]
+		r.free = r.free[:n-1]
+		r.links[id], r.alive[id] = l, true
+	} else {
+		id = LinkID(len(r.links))
+		r.links = append(r.links, l)
+		r.alive = append(r.alive, true)
+	}
+	fqx, fqy := subCellOf(l.From.Point2d())
+	tqx, tqy := subCellOf(l.To.Point2d())
+	r.from[subKey(fqx, fqy)] = append(r.from[subKey(fqx, fqy)], id)
+	r.to[subKey(tqx, tqy)] = append(r.to[subKey(tqx, tqy)], id)
+	return id
+}
+
+// Remove deletes the link id.
+func (r *LinkRegistry) Remove(id LinkID) bool {
+	l, ok := r.remove(id)
+	if ok {
+		r.notify(linkPoints(nil, l))
+	}
+	return ok
+}
+
+// RemoveBatch deletes the links ids and returns how many existed. Listeners
+// are called once with the ends of every removed link.
+func (r *LinkRegistry) RemoveBatch(ids []LinkID) int {
+	var lps []zmap3base.Point2d
+	n := 0
+	for _, id := range ids {
+		if l, ok := r.remove(id); ok {
+			lps = linkPoints(lps, l)
+			n++
+		}
+	}
+	r.notify(lps)
+	return n
+}
+
+func (r *LinkRegistry) remove(id LinkID) (Link, bool) {
+	if id < 0 || int(id) >= len(r.links) || !r.alive[id] {
+		return Link{}, false
+	}
+	l := r.links[id]
+	fqx, fqy := subCellOf(l.From.Point2d())
+	tqx, tqy := subCellOf(l.To.Point2d())
+	removeLinkID(r.from, subKey(fqx, fqy), id)
+	removeLinkID(r.to, subKey(tqx, tqy), id)
+	r.alive[id] = false
+	r.free = append(r.free, id)
+	return l, true
+}
+
+func removeLinkID(m map[uint64][]LinkID, key uint64, id LinkID) {
+	ids := m[key]
+	for i, v := range ids {
+		if v == id {
+			ids = append(ids[:i], ids[i+1:]...)
+			break
+		}
+	}
+	if len(ids) == 0 {
+		delete(m, key)
+	} else {
+		m[key] = ids
+	}
+}
+
+// Get returns the link id.
+func (r *LinkRegistry) Get(id LinkID) (Link, bool) {
+	if id < 0 || int(id) >= len(r.links) || !r.alive[id] {
+		return Link{}, false
+	}
+	return r.links[id], true
+}
+
+// Len returns the number of links.
+func (r *LinkRegistry) Len() int {
+	return len(r.links) - len(r.free)
+}
+
+// ForEach visits every link; visit returning false stops.
+func (r *LinkRegistry) ForEach(visit func(id LinkID, l Link) bool) {
+	for i := range r.links {
+		if r.alive[i] && !visit(LinkID(i), r.links[i]) {
+			return
+		}
+	}
+}
+
+// AddChangeListener registers l, called with the LP points of both ends of
+// every added or removed link, once per AddBatch or RemoveBatch.
+func (r *LinkRegistry) AddChangeListener(l zmap3base.ChangeListener) int {
+	if r.listeners == nil {
+		r.listeners = make(map[int]zmap3base.ChangeListener)
+	}
+	r.nextListener++
+	r.listeners[r.nextListener] = l
+	return r.nextListener
+}
+
+// RemoveChangeListener removes a listener added by AddChangeListener.
+func (r *LinkRegistry) RemoveChangeListener(id int) {
+	delete(r.listeners, id)
+}
+
+// linkPoints appends the LP points of both ends of l to lps.
+func linkPoints(lps []zmap3base.Point2d, l Link) []zmap3base.Point2d {
+	return append(lps, l.From.Point2d().LowPrecisionPoint(), l.To.Point2d().LowPrecisionPoint())
+}
+
+// notify calls the listeners in registration order, like Env does.
+func (r *LinkRegistry) notify(lps []zmap3base.Point2d) {
+	if len(lps) == 0 || len(r.listeners) == 0 {
+		return
+	}
+	ids := make([]int, 0, len(r.listeners))
+	for id := range r.listeners {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		if fn, ok := r.listeners[id]; ok {
+			fn(lps)
+		}
+	}
+}
+
+// AddFromConfig scans the columns of rect (LP cells) and registers the links
+// decode derives from spans (terrain included) with a non-zero
+// Accessory.Config. decode gets the column position (H = span.Begin) and the
+// span; cells with HP data are scanned per sub-column, others once as LP.
+// The links are added with AddBatch.
+func (r *LinkRegistry) AddFromConfig(env *zmap3base.Env, rect zmap3base.Rect, decode func(at zmap3base.Point3d, span zmap3base.RichRange) (Link, bool)) []LinkID {
+	var found []Link
+	scan := func(p zmap3base.Point2d) {
+		rc, ok := env.Route(p)
+		if !ok {
+			return
+		}
+		buf := fastRichRangeSlicePool.Get().(*[]zmap3base.RichRange)
+		terrain, spans, ok := collectRoutedSpans(rc, (*buf)[:0])
+		if ok {
+			visit := func(s zmap3base.RichRange) {
+				if s.Accessory.Config == 0 {
+					return
+				}
+				at := zmap3base.Point3d{X: p.X, Y: p.Y, XOffset: p.XOffset, YOffset: p.YOffset, H: s.Begin, RangeEnd: s.End}
+				if l, ok := decode(at, s); ok {
+					found = append(found, l)
+				}
+			}
+			visit(terrain)
+			for _, s := range spans {
+				visit(s)
+			}
+		}
+		recycleFastSpanBuf(buf, spans)
+	}
+	for y := rect.Min.Y; y < rect.Max.Y; y++ {
+		for x := rect.Min.X; x < rect.Max.X; x++ {
+			lp := zmap3base.Point2d{X: x, Y: y}
+			rc, ok := env.Route(lp)
+			if !ok {
+				continue
+			}
+			d := rc.G.CellByIdx(rc.CellIdx)
+			if d == nil || d.HighPrecision == nil || d.HighPrecision.Has == 0 {
+				scan(lp)
+				continue
+			}
+			for sx := uint8(1); sx <= zmap3base.SecondaryAccuracy; sx++ {
+				for sy := uint8(1); sy <= zmap3base.SecondaryAccuracy; sy++ {
+					scan(zmap3base.Point2d{X: x, Y: y, XOffset: sx, YOffset: sy})
+				}
+			}
+		}
+	}
+	return r.AddBatch(found)
+}
+
+// forEachLinkFrom visits the links leaving the planner state (qx, qy, h) with
+// the stand each one lands on.
+func forEachLinkFrom(r *LinkRegistry, env *zmap3base.Env, qx, qy int32, h uint16, f Filter, visit func(id LinkID, l *Link, tqx, tqy int32, st stand)) {
+	if r == nil {
+		return
+	}
+	for _, id := range r.from[subKey(qx, qy)] {
+		l := &r.links[id]
+		if from, ok := footprintStand(env, qx, qy, int32(l.From.H), f); !ok || from.h != h {
+			continue
+		}
+		tqx, tqy := subCellOf(l.To.Point2d())
+		st, ok := footprintStand(env, tqx, tqy, int32(l.To.H), f)
+		if !ok {
+			continue
+		}
+		visit(id, l, tqx, tqy, st)
+	}
+}
+
+// forEachLinkTo is forEachLinkFrom backwards: it visits the links landing on
+// the planner state (qx, qy, h) with the stand each one leaves from.
+func forEachLinkTo(r *LinkRegistry, env *zmap3base.Env, qx, qy int32, h uint16, f Filter, visit func(id LinkID, l *Link, fqx, fqy int32, from stand)) {
+	if r == nil {
+		return
+	}
+	for _, id := range r.to[subKey(qx, qy)] {
+		l := &r.links[id]
+		if to, ok := footprintStand(env, qx, qy, int32(l.To.H), f); !ok || to.h != h {
+			continue
+		}
+		fqx, fqy := subCellOf(l.From.Point2d())
+		from, ok := footprintStand(env, fqx, fqy, int32(l.From.H), f)
+		if !ok {
+			continue
+		}
+		visit(id, l, fqx, fqy, from)
+	}
+}
+
+func (r *LinkRegistry) hasLinksFrom(qx, qy int32) bool {
+	return r != nil && len(r.from[subKey(qx, qy)]) > 0
+}
+
+// linkBound is a lower bound on reaching the goal through a shortcut link
+// whose source sub-cell is (qx, qy).
+type linkBound struct {
+	qx, qy int32
+	cost   float32
+}
+
+// linkBounds keeps an octile heuristic admissible when links are shorter
+// than the distance they cover (teleports).
+type linkBounds []linkBound
+
+// newLinkBounds collects the shortcut links of r toward the goal sub-cell for
+// a heuristic of scale*octile. Chains of shortcuts are relaxed until stable.
+func newLinkBounds(r *LinkRegistry, gqx, gqy int32, scale float32) linkBounds {
+	if r == nil {
+		return nil
+	}
+	type shortcut struct {
+		fqx, fqy, tqx, tqy int32
+		cost               float32
+	}
+	var sc []shortcut
+	r.ForEach(func(_ LinkID, l Link) bool {
+		fqx, fqy := subCellOf(l.From.Point2d())
+		tqx, tqy := subCellOf(l.To.Point2d())
+		if c := l.cost(); c < scale*octile(tqx-fqx, tqy-fqy) {
+			sc = append(sc, shortcut{fqx, fqy, tqx, tqy, c})
+		}
+		return true
+	})
+	if len(sc) == 0 {
+		return nil
+	}
+	out := make(linkBounds, len(sc))
+	for i, s := range sc {
+		out[i] = linkBound{s.fqx, s.fqy, s.cost + scale*octile(gqx-s.tqx, gqy-s.tqy)}
+	}
+	for changed := true; changed; {
+		changed = false
+		for i, s := range sc {
+			for j := range out {
+				if c := s.cost + scale*octile(out[j].qx-s.tqx, out[j].qy-s.tqy) + out[j].cost; c < out[i].cost-1e-6 {
+					out[i].cost = c
+					changed = true
+				}
+			}
+		}
+	}
+	return out
+}
+
+// apply lowers the estimate h of (qx, qy) by every shortcut bound.
+func (b linkBounds) apply(qx, qy int32, scale, h float32) float32 {
+	for _, lb := range b {
+		if c := scale*octile(lb.qx-qx, lb.qy-qy) + lb.cost; c < h {
+			h = c
+		}
+	}
+	return h
+}
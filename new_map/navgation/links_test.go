@@ -0,0 +1,236 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// checkLinkPath is checkPathMoves that also accepts link hops.
+func checkLinkPath(t *testing.T, env *zmap3base.Env, path []Waypoint, f Filter) {
+	t.Helper()
+	for i := 1; i < len(path); i++ {
+		if path[i].Link == LinkNone {
+			checkPathMoves(t, env, path[i-1:i+1], f)
+		}
+	}
+}
+
+func countLinks(path []Waypoint, lt LinkType) int {
+	n := 0
+	for _, w := range path {
+		if w.Link == lt {
+			n++
+		}
+	}
+	return n
+}
+
+func TestLinks_LadderOverWall(t *testing.T) {
+	cells := flatCells(10)
+	for y := 0; y < zmap3base.FastGridSetSize; y++ {
+		cells[cellIndex(16, y)] = cellFixture{terrain: rr(0, 60, testTexBase)}
+	}
+	env := buildSingleGridEnv(t, cells)
+	// Regions are weakly connected; a low drop limit keeps the wall top
+	// from joining both sides.
+	f := NewFilter(0, 0, 22, 22, 22)
+	west, east := subPoint(8, 40, 10), subPoint(110, 40, 10)
+
+	links := NewLinkRegistry()
+	rm := NewRegionMap(env, f)
+	defer rm.Detach()
+	rm.SetLinks(links)
+	if rm.SameRegion(west, east) {
+		t.Fatalf("wall should split the floor")
+	}
+
+	ladder := links.Add(Link{From: subPoint(60, 40, 10), To: subPoint(72, 40, 10), Type: LinkLadder, Cost: 20})
+	if !rm.SameRegion(west, east) {
+		t.Fatalf("ladder should join the regions")
+	}
+
+	pl := NewPlanner(env, f)
+	pl.Links = links
+	pl.Regions = rm
+	res, ok := pl.FindPath(west, east)
+	if !ok {
+		t.Fatalf("no path over the ladder")
+	}
+	if want := float32(52 + 20 + 38); !sameCost(res.Cost, want) {
+		t.Fatalf("cost %v, want %v", res.Cost, want)
+	}
+	if countLinks(res.Waypoints, LinkLadder) != 1 {
+		t.Fatalf("ladder segment not reported: %+v", res.Waypoints)
+	}
+	checkLinkPath(t, env, res.Waypoints, f)
+
+	smoothed := SmoothPath(env, res.Waypoints, f)
+	if countLinks(smoothed, LinkLadder) != 1 {
+		t.Fatalf("smoothing dropped the ladder")
+	}
+
+	pl.JumpPoints = true
+	jres, ok := pl.FindPath(west, east)
+	if !ok || !sameCost(jres.Cost, res.Cost) || countLinks(jres.Waypoints, LinkLadder) != 1 {
+		t.Fatalf("JPS ok=%v cost=%v, want %v", ok, jres.Cost, res.Cost)
+	}
+	checkLinkPath(t, env, jres.Waypoints, f)
+
+	ff, ok := NewFlowField(env, f, east, zmap3base.Rect{})
+	if !ok {
+		t.Fatalf("NewFlowField failed")
+	}
+	defer ff.Detach()
+	ff.SetLinks(links)
+	if cost, ok := ff.Cost(west); !ok || !sameCost(cost, res.Cost) {
+		t.Fatalf("field cost ok=%v %v, want %v", ok, cost, res.Cost)
+	}
+	p, hops := west, 0
+	for i := 0; i < 500; i++ {
+		next, ok := ff.Next(p)
+		if !ok {
+			break
+		}
+		if next.Link == LinkLadder {
+			hops++
+		}
+		p = next.Point3d
+	}
+	if qx, qy := subCellOf(p.Point2d()); qx != 110 || qy != 40 || hops != 1 {
+		t.Fatalf("field walk ended at %+v with %d ladder hops", p, hops)
+	}
+
+	// The link is one-way.
+	if _, ok := pl.FindPath(east, west); ok {
+		t.Fatalf("found a path against the link direction")
+	}
+
+	links.Remove(ladder)
+	if rm.SameRegion(west, east) {
+		t.Fatalf("removing the ladder should split the regions again")
+	}
+	if !ff.Stale() {
+		t.Fatalf("link removal did not invalidate the field")
+	}
+	if _, ok := ff.Cost(west); ok {
+		t.Fatalf("field still crosses the removed ladder")
+	}
+	pl.Regions = nil
+	if _, ok := pl.FindPath(west, east); ok {
+		t.Fatalf("found a path without the ladder")
+	}
+}
+
+func TestLinks_TeleportKeepsOptimality(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	f := testFilter()
+	links := NewLinkRegistry()
+	links.Add(Link{From: subPoint(4, 4, 10), To: subPoint(120, 120, 10), Type: LinkTeleport, Cost: 1})
+
+	pl := NewPlanner(env, f)
+	pl.Links = links
+	for _, jump := range []bool{false, true} {
+		pl.JumpPoints = jump
+		res, ok := pl.FindPath(subPoint(2, 2, 10), subPoint(122, 122, 10))
+		if want := float32(1 + 4*sqrt2); !ok || !sameCost(res.Cost, want) {
+			t.Fatalf("jump=%v: ok=%v cost=%v, want %v", jump, ok, res.Cost, want)
+		}
+		if countLinks(res.Waypoints, LinkTeleport) != 1 {
+			t.Fatalf("jump=%v: teleport not reported", jump)
+		}
+	}
+}
+
+func TestLinks_HPA(t *testing.T) {
+	// 3x1 grids; the third grid is walled off and only a teleport enters it.
+	env := buildGridsEnv(t, 3, 1, func(x, y int) cellFixture {
+		if x == 70 {
+			return cellFixture{terrain: rr(0, 60, testTexBase)}
+		}
+		return cellFixture{terrain: rr(0, 10, testTexBase)}
+	})
+	f := testFilter()
+	links := NewLinkRegistry()
+	pl := NewPlanner(env, f)
+	pl.Links = links
+	hg := NewHPAGraph(pl)
+	defer hg.Detach()
+
+	start, goal := subPoint(8, 8, 10), subPoint(360, 16, 10)
+	if _, ok := hg.FindPath(start, goal); ok {
+		t.Fatalf("found a path into the walled grid")
+	}
+	links.Add(Link{From: subPoint(100, 60, 10), To: subPoint(330, 60, 10), Type: LinkTeleport, Cost: 5})
+	want, ok := pl.FindPath(start, goal)
+	if !ok {
+		t.Fatalf("planner found no path over the teleport")
+	}
+	got, ok := hg.FindPath(start, goal)
+	if !ok || countLinks(got.Waypoints, LinkTeleport) != 1 {
+		t.Fatalf("HPA ok=%v, teleport hops=%d", ok, countLinks(got.Waypoints, LinkTeleport))
+	}
+	if got.Cost < want.Cost-1e-3 || got.Cost > want.Cost*1.2 {
+		t.Fatalf("HPA cost %v, A* %v", got.Cost, want.Cost)
+	}
+	checkLinkPath(t, env, got.Waypoints, f)
+}
+
+func TestLinks_AddFromConfig(t *testing.T) {
+	cells := flatCells(10)
+	cells[cellIndex(5, 5)] = cellFixture{terrain: zmap3base.RichRange{
+		Range:     zmap3base.Range{Begin: 0, End: 10},
+		Accessory: zmap3base.Accessory{Texture: testTexBase, Config: 12},
+	}}
+	env := buildSingleGridEnv(t, cells)
+	links := NewLinkRegistry()
+	ids := links.AddFromConfig(env, env.Rect(), func(at zmap3base.Point3d, span zmap3base.RichRange) (Link, bool) {
+		// Config holds the LP x of the door exit on the same row.
+		to := at
+		to.X = uint16(span.Accessory.Config)
+		to.H = span.End
+		return Link{From: zmap3base.Point3d{X: at.X, Y: at.Y, H: span.End}, To: to, Type: LinkDoor}, true
+	})
+	if len(ids) != 1 || links.Len() != 1 {
+		t.Fatalf("derived %d links, want 1", len(ids))
+	}
+	l, _ := links.Get(ids[0])
+	if l.From.X != 5 || l.To.X != 12 || l.Type != LinkDoor || l.Type.String() != "door" {
+		t.Fatalf("unexpected link %+v", l)
+	}
+}
+
+func TestLinks_BatchNotify(t *testing.T) {
+	links := NewLinkRegistry()
+	var calls []int
+	var got [][]zmap3base.Point2d
+	for i := 1; i <= 5; i++ {
+		id := i
+		links.AddChangeListener(func(lps []zmap3base.Point2d) {
+			calls = append(calls, id)
+			if id == 1 {
+				got = append(got, lps)
+			}
+		})
+	}
+
+	ls := []Link{
+		{From: zmap3base.Point3d{X: 1, Y: 1, H: 10}, To: zmap3base.Point3d{X: 2, Y: 1, H: 10}, Type: LinkDoor},
+		{From: zmap3base.Point3d{X: 5, Y: 5, H: 10}, To: zmap3base.Point3d{X: 9, Y: 5, H: 10}, Type: LinkTeleport},
+	}
+	ids := links.AddBatch(ls)
+	if len(ids) != 2 || links.Len() != 2 || len(got) != 1 || len(got[0]) != 4 {
+		t.Fatalf("AddBatch: ids %v, %d calls with %v", ids, len(got), got)
+	}
+	if n := links.RemoveBatch(append(ids, 7)); n != 2 || links.Len() != 0 || len(got) != 2 || len(got[1]) != 4 {
+		t.Fatalf("RemoveBatch: removed %d, %d calls with %v", n, len(got), got)
+	}
+	if links.RemoveBatch(ids) != 0 || links.AddBatch(nil) != nil || len(got) != 2 {
+		t.Fatalf("empty batches notified: %d calls", len(got))
+	}
+	for i, id := range calls {
+		if want := i%5 + 1; id != want {
+			t.Fatalf("listener order %v", calls)
+		}
+	}
+}
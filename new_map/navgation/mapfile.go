@@ -0,0 +1,282 @@
+package navgation
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"unicode"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// CellData is the content of one LP cell: its terrain, the low-precision
+// spans above it and, for high-precision cells, the spans of each sub-cell.
+type CellData struct {
+	Terrain zmap3base.RichRange
+	LP      []zmap3base.RichRange
+	HP      [][]zmap3base.RichRange // nil or SecondaryTileNum lists
+}
+
+// ReadCell returns the content of LP cell lp. ok is false outside the loaded
+// grids and for empty cells.
+func ReadCell(env *zmap3base.Env, lp zmap3base.Point2d) (c CellData, ok bool) {
+	lp.XOffset, lp.YOffset = 0, 0
+	rc, ok := env.Route(lp)
+	if !ok {
+		return c, false
+	}
+	d := rc.G.CellByIdx(rc.CellIdx)
+	if d == nil {
+		return c, false
+	}
+	c.Terrain, ok = resolveTerrainFast(rc.G, d, rc.CellIdx)
+	if !ok {
+		return c, false
+	}
+	c.LP = appendLPSourceRangesFast(rc.G, d, rc.CellIdx, c.Terrain, nil)
+	if d.HighPrecision != nil && d.HighPrecision.Has != 0 {
+		c.HP = make([][]zmap3base.RichRange, zmap3base.SecondaryTileNum)
+		for sub := range c.HP {
+			c.HP[sub] = appendHPSourceRangesFast(rc.G, d, rc.CellIdx, sub, c.Terrain, nil)
+		}
+	}
+	return c, true
+}
+
+// NewEnvFromCells builds an Env over rect from the cells returned by cell.
+// Grids without any cell stay unloaded.
+func NewEnvFromCells(rect zmap3base.Rect, cell func(lp zmap3base.Point2d) (CellData, bool)) (*zmap3base.Env, error) {
+	env := zmap3base.NewEnv(rect)
+	w, h := env.GridDims()
+	for gy := 0; gy < h; gy++ {
+		for gx := 0; gx < w; gx++ {
+			bx := rect.Min.X + uint16(gx*zmap3base.FastGridSetSize)
+			by := rect.Min.Y + uint16(gy*zmap3base.FastGridSetSize)
+			lpPerCell := make([][]zmap3base.RichRange, zmap3base.FastGridCellNum)
+			hpPerCell := make([][zmap3base.SecondaryTileNum][]zmap3base.RichRange, zmap3base.FastGridCellNum)
+			loaded := false
+			for ly := 0; ly < zmap3base.FastGridSetSize; ly++ {
+				for lx := 0; lx < zmap3base.FastGridSetSize; lx++ {
+					p := zmap3base.Point2d{X: bx + uint16(lx), Y: by + uint16(ly)}
+					if !env.Validate2d(p) {
+						continue
+					}
+					c, ok := cell(p)
+					if !ok {
+						continue
+					}
+					loaded = true
+					i := lx + ly*zmap3base.FastGridSetSize
+					lpPerCell[i] = append([]zmap3base.RichRange{c.Terrain}, c.LP...)
+					for sub := 0; sub < len(c.HP) && sub < zmap3base.SecondaryTileNum; sub++ {
+						hpPerCell[i][sub] = c.HP[sub]
+					}
+				}
+			}
+			if !loaded {
+				continue
+			}
+			grid, err := zmap3base.BuildGridRBDataFromSlices(bx, by, lpPerCell, hpPerCell)
+			if err != nil {
+				return nil, fmt.Errorf("grid (%d,%d): %w", gx, gy, err)
+			}
+			env.SetGrid(gx, gy, grid)
+		}
+	}
+	return env, nil
+}
+
+// LoadFile reads a map file or a web_world_data scene, told apart by their
+// first byte. Map files carry no agent settings, so isScene reports whether
+// the Filter, Start and Goal of sc are meaningful.
+func LoadFile(path string) (sc *Scene, isScene bool, err error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, err
+	}
+	if i := bytes.IndexFunc(data, func(r rune) bool { return !unicode.IsSpace(r) }); i >= 0 && data[i] == '{' {
+		sc, err = LoadScene(bytes.NewReader(data))
+		return sc, err == nil, err
+	}
+	env, err := ReadMap(bytes.NewReader(data))
+	if err != nil {
+		return nil, false, err
+	}
+	return &Scene{Env: env}, false, nil
+}
+
+// Map files store the cells of every loaded grid, little endian:
+//
+//	"ZNAV" version:u32 rect:4*u16 grids:u32
+//	per grid: gx:u16 gy:u16, then FastGridCellNum cells
+//	per cell: kind:u8 (0 empty, 1 LP, 2 LP+HP), terrain, LP list[, 16 HP lists]
+//	list: n:u16 then n ranges; range: begin:u16 end:u16 texture:u32 config:u32
+const (
+	mapFileMagic   = "ZNAV"
+	mapFileVersion = 1
+)
+
+var ErrBadMapFile = errors.New("navgation: not a map file")
+
+// WriteMap writes the loaded grids of env to w in the map file format.
+func WriteMap(w io.Writer, env *zmap3base.Env) error {
+	ew := &errWriter{w: w}
+	bw := zmap3base.NewBinWriter(ew, true)
+	bw.Write([]byte(mapFileMagic))
+	bw.WriteUint32(mapFileVersion)
+	r := env.Rect()
+	bw.WriteUint16(r.Min.X)
+	bw.WriteUint16(r.Min.Y)
+	bw.WriteUint16(r.Max.X)
+	bw.WriteUint16(r.Max.Y)
+
+	gw, gh := env.GridDims()
+	var grids [][2]int
+	for gy := 0; gy < gh; gy++ {
+		for gx := 0; gx < gw; gx++ {
+			if env.GridAt(gx, gy) != nil {
+				grids = append(grids, [2]int{gx, gy})
+			}
+		}
+	}
+	bw.WriteUint32(uint32(len(grids)))
+	for _, g := range grids {
+		bw.WriteUint16(uint16(g[0]))
+		bw.WriteUint16(uint16(g[1]))
+		grid := env.GridAt(g[0], g[1])
+		for ly := 0; ly < zmap3base.FastGridSetSize; ly++ {
+			for lx := 0; lx < zmap3base.FastGridSetSize; lx++ {
+				c, ok := ReadCell(env, zmap3base.Point2d{X: grid.BaseX() + uint16(lx), Y: grid.BaseY() + uint16(ly)})
+				switch {
+				case !ok:
+					bw.WriteUint8(0)
+					continue
+				case c.HP == nil:
+					bw.WriteUint8(1)
+				default:
+					bw.WriteUint8(2)
+				}
+				writeRange(bw, c.Terrain)
+				writeRanges(bw, c.LP)
+				for _, hp := range c.HP {
+					writeRanges(bw, hp)
+				}
+			}
+		}
+	}
+	bw.Flush()
+	return ew.err
+}
+
+// ReadMap reads a map file written by WriteMap.
+func ReadMap(r io.Reader) (env *zmap3base.Env, err error) {
+	br := zmap3base.NewBinReader(r, true)
+	defer func() {
+		// BinReader panics on short reads.
+		if p := recover(); p != nil {
+			env, err = nil, fmt.Errorf("%w: %v", ErrBadMapFile, p)
+		}
+	}()
+	magic := make([]byte, len(mapFileMagic))
+	if !br.Read(magic) || string(magic) != mapFileMagic {
+		return nil, ErrBadMapFile
+	}
+	if v := br.ReadUint32(); v != mapFileVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrBadMapFile, v)
+	}
+	var rect zmap3base.Rect
+	rect.Min.X, rect.Min.Y = br.ReadUint16(), br.ReadUint16()
+	rect.Max.X, rect.Max.Y = br.ReadUint16(), br.ReadUint16()
+	if rect.Max.X <= rect.Min.X || rect.Max.Y <= rect.Min.Y {
+		return nil, fmt.Errorf("%w: empty rect", ErrBadMapFile)
+	}
+
+	cells := make(map[zmap3base.Point2d]CellData)
+	gw := (int(rect.Width()) + zmap3base.FastGridSetSize - 1) / zmap3base.FastGridSetSize
+	gh := (int(rect.Height()) + zmap3base.FastGridSetSize - 1) / zmap3base.FastGridSetSize
+	n := br.ReadUint32()
+	for i := uint32(0); i < n; i++ {
+		gx, gy := int(br.ReadUint16()), int(br.ReadUint16())
+		if gx >= gw || gy >= gh {
+			return nil, fmt.Errorf("%w: grid (%d,%d) outside the rect", ErrBadMapFile, gx, gy)
+		}
+		bx := int(rect.Min.X) + gx*zmap3base.FastGridSetSize
+		by := int(rect.Min.Y) + gy*zmap3base.FastGridSetSize
+		for ly := 0; ly < zmap3base.FastGridSetSize; ly++ {
+			for lx := 0; lx < zmap3base.FastGridSetSize; lx++ {
+				kind := br.ReadUint8()
+				if kind == 0 {
+					continue
+				}
+				if kind > 2 {
+					return nil, fmt.Errorf("%w: cell kind %d", ErrBadMapFile, kind)
+				}
+				var c CellData
+				c.Terrain = readRange(br)
+				c.LP = readRanges(br)
+				if kind == 2 {
+					c.HP = make([][]zmap3base.RichRange, zmap3base.SecondaryTileNum)
+					for sub := range c.HP {
+						c.HP[sub] = readRanges(br)
+					}
+				}
+				cells[zmap3base.Point2d{X: uint16(bx + lx), Y: uint16(by + ly)}] = c
+			}
+		}
+	}
+	return NewEnvFromCells(rect, func(lp zmap3base.Point2d) (CellData, bool) {
+		c, ok := cells[lp]
+		return c, ok
+	})
+}
+
+func writeRange(bw *zmap3base.BinWriter, rr zmap3base.RichRange) {
+	bw.WriteUint16(rr.Begin)
+	bw.WriteUint16(rr.End)
+	bw.WriteUint32(uint32(rr.Accessory.Texture))
+	bw.WriteUint32(rr.Accessory.Config)
+}
+
+func writeRanges(bw *zmap3base.BinWriter, rrs []zmap3base.RichRange) {
+	bw.WriteUint16(uint16(len(rrs)))
+	for _, rr := range rrs {
+		writeRange(bw, rr)
+	}
+}
+
+func readRange(br *zmap3base.BinReader) zmap3base.RichRange {
+	var rr zmap3base.RichRange
+	rr.Begin, rr.End = br.ReadUint16(), br.ReadUint16()
+	rr.Accessory.Texture = zmap3base.Texture(br.ReadUint32())
+	rr.Accessory.Config = br.ReadUint32()
+	return rr
+}
+
+func readRanges(br *zmap3base.BinReader) []zmap3base.RichRange {
+	n := int(br.ReadUint16())
+	if n == 0 {
+		return nil
+	}
+	rrs := make([]zmap3base.RichRange, n)
+	for i := range rrs {
+		rrs[i] = readRange(br)
+	}
+	return rrs
+}
+
+// errWriter keeps the first write error; BinWriter drops them.
+type errWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (c *errWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.err = err
+	return n, err
+}
@@ -0,0 +1,113 @@
+package navgation
+
+import (
+	"bytes"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// sameColumns fails unless every sub-column of a and b has the same gaps.
+func sameColumns(t *testing.T, a, b *zmap3base.Env, f Filter) {
+	t.Helper()
+	if a.Rect() != b.Rect() {
+		t.Fatalf("rect %v vs %v", a.Rect(), b.Rect())
+	}
+	r := a.Rect()
+	for qy := int32(r.Min.Y) * 4; qy < int32(r.Max.Y)*4; qy++ {
+		for qx := int32(r.Min.X) * 4; qx < int32(r.Max.X)*4; qx++ {
+			p, _ := subCellPoint2d(qx, qy)
+			ga, gb := AllIntervals(a, p, f), AllIntervals(b, p, f)
+			if len(ga) != len(gb) {
+				t.Fatalf("(%d,%d): %v vs %v", qx, qy, ga, gb)
+			}
+			for i := range ga {
+				if ga[i] != gb[i] {
+					t.Fatalf("(%d,%d): %v vs %v", qx, qy, ga, gb)
+				}
+			}
+		}
+	}
+}
+
+func TestLoadScene_MatchesFixture(t *testing.T) {
+	for _, file := range sceneFiles(t) {
+		t.Run(filepath.Base(file), func(t *testing.T) {
+			want, wf, ws, wg := loadScene(t, file)
+			data, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sc, err := LoadScene(bytes.NewReader(data))
+			if err != nil {
+				t.Fatalf("LoadScene: %v", err)
+			}
+			if sc.Filter != wf || sc.Start != ws || sc.Goal != wg {
+				t.Fatalf("filter/start/goal %+v %+v %+v, want %+v %+v %+v", sc.Filter, sc.Start, sc.Goal, wf, ws, wg)
+			}
+			sameColumns(t, sc.Env, want, NewFilter(0, 0, 1, 0, 0))
+
+			// WriteScene followed by LoadScene keeps the map and settings.
+			var buf bytes.Buffer
+			if err := WriteScene(&buf, sc.Env, sc.Filter, sc.Start, sc.Goal); err != nil {
+				t.Fatalf("WriteScene: %v", err)
+			}
+			back, err := LoadScene(&buf)
+			if err != nil {
+				t.Fatalf("reload: %v", err)
+			}
+			if back.Filter != sc.Filter || back.Start != sc.Start || back.Goal != sc.Goal {
+				t.Fatalf("reloaded settings differ")
+			}
+			sameColumns(t, back.Env, sc.Env, NewFilter(0, 0, 1, 0, 0))
+		})
+	}
+}
+
+func TestMapFile_RoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(43))
+	env := buildGridsEnv(t, 2, 1, func(x, y int) cellFixture {
+		switch rnd.Intn(6) {
+		case 0:
+			return cellFixture{terrain: rr(0, 30, testTexBase), lpPayload: []zmap3base.RichRange{rr(50, 60, testTexObs)}}
+		case 1:
+			return cellFixture{terrain: rr(0, 10, testTexBase), hpPayload: map[int][]zmap3base.RichRange{
+				rnd.Intn(zmap3base.SecondaryTileNum): {rr(10, 40, testTexCol)},
+			}}
+		}
+		return cellFixture{terrain: rr(0, 10, testTexBase)}
+	})
+	// Edits move cells from the base store to the dirty trees.
+	var ops []zmap3base.Point3d
+	for i := 0; i < 30; i++ {
+		ops = append(ops, zmap3base.Point3d{X: uint16(rnd.Intn(64)), Y: uint16(rnd.Intn(32)), H: 20, RangeEnd: 35})
+	}
+	ops = append(ops, zmap3base.Point3d{X: 40, Y: 7, XOffset: 2, YOffset: 3, H: 15, RangeEnd: 90})
+	if !env.ApplyRichOperationsExt(ops, nil, zmap3base.Accessory{Texture: testTexObs, Config: 7}) {
+		t.Fatalf("ApplyRichOperationsExt failed")
+	}
+
+	var buf bytes.Buffer
+	if err := WriteMap(&buf, env); err != nil {
+		t.Fatalf("WriteMap: %v", err)
+	}
+	back, err := ReadMap(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("ReadMap: %v", err)
+	}
+	sameColumns(t, back, env, NewFilter(0, 0, 1, 0, 0))
+	c, ok := ReadCell(back, zmap3base.Point2d{X: 40, Y: 7})
+	if !ok || c.HP == nil {
+		t.Fatalf("edited HP cell lost: ok=%v %+v", ok, c)
+	}
+
+	if _, err := ReadMap(bytes.NewReader(buf.Bytes()[:buf.Len()/2])); err == nil {
+		t.Fatalf("truncated file accepted")
+	}
+	if _, err := ReadMap(bytes.NewReader([]byte("nope"))); err == nil {
+		t.Fatalf("garbage accepted")
+	}
+}
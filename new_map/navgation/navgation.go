@@ -12,6 +12,37 @@ type Filter struct {
 	ignoreTexture,
 	forbiddenTexture uint32
 	height, upLimit, downLimit int32
+
+	swim      bool // see WithSwimming
+	wadeDepth int32
+
+	size  int32           // footprint edge in sub-cells, see WithFootprint
+	layer *FootprintLayer // see FootprintLayer.Filter
+}
+
+// NewFilter bundles the agent parameters shared by every GetInterval call.
+func NewFilter(ignoreTexture, forbiddenTexture uint32, height, upLimit, downLimit int32) Filter {
+	return Filter{
+		ignoreTexture:    ignoreTexture,
+		forbiddenTexture: forbiddenTexture,
+		height:           height,
+		upLimit:          upLimit,
+		downLimit:        downLimit,
+	}
+}
+
+func (f Filter) IgnoreTexture() uint32    { return f.ignoreTexture }
+func (f Filter) ForbiddenTexture() uint32 { return f.forbiddenTexture }
+func (f Filter) Height() int32            { return f.height }
+func (f Filter) UpLimit() int32           { return f.upLimit }
+func (f Filter) DownLimit() int32         { return f.downLimit }
+
+// Interval runs GetIntervalFast with the filter parameters.
+func (f Filter) Interval(env *zmap3base.Env, p2d zmap3base.Point2d, curY int32) (zmap3base.SnapRichRange, bool) {
+	if f.swim {
+		return f.intervalSwim(env, p2d, curY)
+	}
+	return GetIntervalFast(env, p2d, curY, f.ignoreTexture, f.forbiddenTexture, f.height, f.upLimit, f.downLimit)
 }
 
 var richRangeSlicePool = sync.Pool{
@@ -0,0 +1,585 @@
+package navgation
+
+import (
+	"container/heap"
+	"sort"
+
+	"pathfinding/navtrace"
+	zmap3base "pathfinding/new_map"
+)
+
+const sqrt2 = 1.41421356
+
+// stepDirs are the 8-connected sub-cell moves; costs are in sub-cell units.
+var stepDirs = [8]struct {
+	dx, dy int32
+	cost   float32
+}{
+	{1, 0, 1}, {-1, 0, 1}, {0, 1, 1}, {0, -1, 1},
+	{1, 1, sqrt2}, {1, -1, sqrt2}, {-1, 1, sqrt2}, {-1, -1, sqrt2},
+}
+
+// Waypoint is one standing point of a path. Point3d addresses the reference
+// sub-cell of the footprint, H/RangeEnd hold the floor and ceiling.
+type Waypoint struct {
+	zmap3base.Point3d
+	Texture zmap3base.Texture // floor texture
+	// Link is the off-mesh link taken to reach this waypoint, LinkNone for
+	// an ordinary step.
+	Link LinkType
+	// Mode is the locomotion on this waypoint (see Filter.WithSwimming).
+	Mode Locomotion
+}
+
+// waypointAt builds the waypoint of stand st at (qx, qy).
+func waypointAt(env *zmap3base.Env, f Filter, qx, qy int32, st stand, link LinkType) Waypoint {
+	return Waypoint{Point3d: point3dAt(qx, qy, st.gap()), Texture: st.tex, Link: link, Mode: locomotionAt(env, qx, qy, st, f)}
+}
+
+// PathResult is the outcome of Planner.FindPath.
+type PathResult struct {
+	Waypoints []Waypoint
+	Cost      float32 // in sub-cell units
+	Expanded  int
+
+	// Start/Goal are the endpoints the search actually used.
+	Start, Goal zmap3base.Point3d
+	// StartRelocated/GoalRelocated report that an endpoint inside an obstacle
+	// was moved to the nearest valid standing point (see Planner.RelocateRadius).
+	StartRelocated, GoalRelocated bool
+}
+
+// Planner runs A* over the standable surfaces of an Env at sub-cell resolution.
+type Planner struct {
+	Env    *zmap3base.Env
+	Filter Filter
+
+	// MaxExpansions bounds the search; 0 means unlimited.
+	MaxExpansions int
+	// JumpPoints enables jump point search over bare-terrain LP regions
+	// (see jps.go). Costs stay optimal; Expanded drops on open ground.
+	JumpPoints bool
+	// RelocateRadius, in sub-cells, lets FindPath move a start or goal whose
+	// footprint has no valid stand to the nearest valid one. 0 disables it.
+	RelocateRadius int32
+	// Regions, if set, rejects goals outside the start's region without
+	// searching. It must be built with the same Filter and links.
+	Regions *RegionMap
+	// Links, if set, adds off-mesh links to the move set (see links.go).
+	Links *LinkRegistry
+	// Cost, if set, prices every step (see cost.go). Jump point search needs
+	// uniform costs and is skipped while a model is set.
+	Cost CostModel
+	// Tracer, if set, receives the events of every FindPath search and
+	// PathRequest. Coordinates are sub-cells and heights in 1/20m (see NewTrace).
+	Tracer navtrace.Tracer
+}
+
+func NewPlanner(env *zmap3base.Env, f Filter) *Planner {
+	return &Planner{Env: env, Filter: f}
+}
+
+// FindPath searches from start to goal. start.H and goal.H are the standing
+// heights the endpoints are resolved from.
+func (pl *Planner) FindPath(start, goal zmap3base.Point3d) (res PathResult, ok bool) {
+	s, res, ok := pl.begin(nil, start, goal)
+	if !ok {
+		return res, false
+	}
+	end, ok := s.run(pl.MaxExpansions)
+	return pl.finish(s, res, end, ok)
+}
+
+// begin resolves the endpoints and returns a search seeded with the start,
+// built on the buffers of sc if not nil. ok is false when there is nothing
+// to search.
+func (pl *Planner) begin(sc *searchScratch, start, goal zmap3base.Point3d) (s *search, res PathResult, ok bool) {
+	if pl.Env == nil {
+		return nil, res, false
+	}
+
+	sqx, sqy := subCellOf(start.Point2d())
+	sst, relocated, ok := pl.resolveEndpoint(&sqx, &sqy, int32(start.H))
+	if !ok {
+		return nil, res, false
+	}
+	res.StartRelocated = relocated
+	res.Start = point3dAt(sqx, sqy, sst.gap())
+
+	gqx, gqy := subCellOf(goal.Point2d())
+	gst, relocated, ok := pl.resolveEndpoint(&gqx, &gqy, int32(goal.H))
+	if !ok {
+		return nil, res, false
+	}
+	res.GoalRelocated = relocated
+	res.Goal = point3dAt(gqx, gqy, gst.gap())
+
+	if pl.Regions != nil && !pl.Regions.connected(sqx, sqy, sst.h, gqx, gqy, gst.h) {
+		return nil, res, false
+	}
+
+	s = newSearchIn(sc, pl, gqx, gqy, gst.h)
+	s.tracer = pl.Tracer
+	s.trace(navtrace.Start, 0, sqx, sqy, sst.h, 0, s.heuristic(sqx, sqy))
+	s.trace(navtrace.Goal, 0, gqx, gqy, gst.h, 0, 0)
+	s.push(sqx, sqy, sst, 0, -1)
+	return s, res, true
+}
+
+// finish completes res for a search that reached node end, or failed.
+func (pl *Planner) finish(s *search, res PathResult, end int32, ok bool) (PathResult, bool) {
+	res.Expanded = s.expanded
+	if !ok {
+		s.trace(navtrace.Failed, 0, s.goalQX, s.goalQY, s.goalH, 0, 0)
+		return res, false
+	}
+	res.Waypoints = s.reconstruct(end)
+	res.Cost = s.nodes[end].g
+	if s.tracer != nil {
+		s.trace(navtrace.Found, 0, s.goalQX, s.goalQY, s.goalH, res.Cost, res.Cost)
+		for _, w := range res.Waypoints {
+			qx, qy := subCellOf(w.Point2d())
+			s.trace(navtrace.Path, 0, qx, qy, w.H, 0, 0)
+		}
+	}
+	return res, true
+}
+
+// NewTrace returns a trace in the units Planner.Tracer and FlyPlanner.Tracer
+// record: sub-cells and 1/20m heights.
+func NewTrace() *navtrace.Trace {
+	return navtrace.New(zmap3base.SecondaryTileLen, zmap3base.HeightScale)
+}
+
+// resolveEndpoint validates the footprint at (qx, qy) and relocates it if
+// allowed. qx/qy are updated in place on relocation.
+func (pl *Planner) resolveEndpoint(qx, qy *int32, curY int32) (st stand, relocated, ok bool) {
+	if st, ok = footprintStand(pl.Env, *qx, *qy, curY, pl.Filter); ok {
+		return st, false, true
+	}
+	if pl.RelocateRadius <= 0 {
+		return stand{}, false, false
+	}
+	nx, ny, st, ok := relocateStand(pl.Env, *qx, *qy, curY, pl.RelocateRadius, pl.Filter)
+	if !ok {
+		return stand{}, false, false
+	}
+	*qx, *qy = nx, ny
+	return st, true, true
+}
+
+// ---------------------------------------------------------------------------
+
+type searchNode struct {
+	qx, qy  int32
+	st      stand
+	g, f    float32
+	parent  int32
+	heapIdx int32
+	closed  bool
+	link    LinkType // how the node was reached from parent
+}
+
+type search struct {
+	pl    *Planner
+	nodes []searchNode
+	index map[uint64]int32
+	open  openList
+
+	goalQX, goalQY int32
+	goalH          uint16
+	expanded       int
+
+	// bounded limits expansion to the sub-cells [minQX,maxQX)x[minQY,maxQY).
+	bounded                    bool
+	minQX, minQY, maxQX, maxQY int32
+	// dijkstra disables the heuristic; used to reach several targets at once.
+	// The search stops once every key in targets is closed.
+	dijkstra bool
+	targets  map[uint64]struct{}
+	// reverse expands the predecessors of each node instead, so g is the
+	// cost from the node to the one the search started at.
+	reverse bool
+	floors  []uint16 // scratch of expandPreds
+
+	jps *jpsCache // set when jump point search is used
+	// hscale and bounds keep the heuristic admissible under the cost model
+	// and across shortcut links.
+	hscale float32
+	bounds linkBounds
+
+	tracer navtrace.Tracer
+}
+
+func newSearch(pl *Planner, gqx, gqy int32, gh uint16) *search {
+	return newSearchIn(nil, pl, gqx, gqy, gh)
+}
+
+// newSearchIn is newSearch reusing the buffers of sc when not nil.
+func newSearchIn(sc *searchScratch, pl *Planner, gqx, gqy int32, gh uint16) *search {
+	s := &search{
+		pl:     pl,
+		goalQX: gqx,
+		goalQY: gqy,
+		goalH:  gh,
+	}
+	if sc != nil && sc.index != nil {
+		clear(sc.index)
+		s.nodes, s.index, s.open.items = sc.nodes[:0], sc.index, sc.items[:0]
+		*sc = searchScratch{}
+	} else {
+		s.nodes = make([]searchNode, 0, 256)
+		s.index = make(map[uint64]int32, 256)
+	}
+	if pl.JumpPoints && pl.Cost == nil {
+		s.jps = newJPSCache()
+	}
+	s.hscale = heuristicScale(pl.Cost)
+	s.bounds = newLinkBounds(pl.Links, gqx, gqy, s.hscale)
+	s.open.s = s
+	return s
+}
+
+// searchScratch keeps the node arena, index and open list of a finished
+// search for the next one, so a long-lived worker does not reallocate them
+// per query.
+type searchScratch struct {
+	nodes []searchNode
+	index map[uint64]int32
+	items []int32
+}
+
+// reclaim takes the buffers of s, which must not be used afterwards.
+func (sc *searchScratch) reclaim(s *search) {
+	if sc == nil || s == nil {
+		return
+	}
+	sc.nodes, sc.index, sc.items = s.nodes, s.index, s.open.items
+}
+
+func nodeKey(qx, qy int32, h uint16) uint64 {
+	return uint64(uint32(qx))<<40 | uint64(uint32(qy))<<16 | uint64(h)
+}
+
+func (s *search) heuristic(qx, qy int32) float32 {
+	if s.dijkstra {
+		return 0
+	}
+	return s.bounds.apply(qx, qy, s.hscale, s.hscale*octile(s.goalQX-qx, s.goalQY-qy))
+}
+
+// run pops nodes until the goal is closed, the open list is empty or
+// maxExpansions (0 = unlimited) is reached.
+func (s *search) run(maxExpansions int) (int32, bool) {
+	for s.open.Len() > 0 {
+		if maxExpansions > 0 && s.expanded >= maxExpansions {
+			break
+		}
+		cur := heap.Pop(&s.open).(int32)
+		if s.tracer != nil {
+			n := &s.nodes[cur]
+			s.trace(navtrace.Pop, 0, n.qx, n.qy, n.st.h, n.g, n.f)
+			if n.closed {
+				s.trace(navtrace.Reject, navtrace.Stale, n.qx, n.qy, n.st.h, n.g, n.f)
+			}
+		}
+		if s.nodes[cur].closed {
+			continue
+		}
+		s.nodes[cur].closed = true
+		s.expanded++
+		if s.tracer != nil {
+			n := &s.nodes[cur]
+			s.trace(navtrace.Expand, 0, n.qx, n.qy, n.st.h, n.g, n.f)
+		}
+
+		if s.isGoal(cur) {
+			return cur, true
+		}
+		if len(s.targets) > 0 {
+			n := &s.nodes[cur]
+			delete(s.targets, nodeKey(n.qx, n.qy, n.st.h))
+			if len(s.targets) == 0 {
+				break
+			}
+		}
+		switch {
+		case s.reverse:
+			s.expandPreds(cur)
+		case s.jps != nil:
+			s.expandJPS(cur)
+		default:
+			s.expand(cur)
+		}
+	}
+	return -1, false
+}
+
+// bound restricts the search to a rectangle of sub-cells.
+func (s *search) bound(minQX, minQY, maxQX, maxQY int32) {
+	s.bounded = true
+	s.minQX, s.minQY, s.maxQX, s.maxQY = minQX, minQY, maxQX, maxQY
+}
+
+// closedCost returns g of the closed node (qx, qy, h).
+func (s *search) closedCost(qx, qy int32, h uint16) (float32, bool) {
+	i, ok := s.index[nodeKey(qx, qy, h)]
+	if !ok || !s.nodes[i].closed {
+		return 0, false
+	}
+	return s.nodes[i].g, true
+}
+
+func (s *search) isGoal(i int32) bool {
+	n := &s.nodes[i]
+	return n.qx == s.goalQX && n.qy == s.goalQY && n.st.h == s.goalH
+}
+
+// push inserts or relaxes the node at (qx, qy, st.h).
+func (s *search) push(qx, qy int32, st stand, g float32, parent int32) {
+	s.pushLink(qx, qy, st, g, parent, LinkNone)
+}
+
+// pushLink is push for a node reached through a link of type lt.
+func (s *search) pushLink(qx, qy int32, st stand, g float32, parent int32, lt LinkType) {
+	key := nodeKey(qx, qy, st.h)
+	if i, ok := s.index[key]; ok {
+		n := &s.nodes[i]
+		if n.closed || g >= n.g {
+			if s.tracer != nil {
+				reason := navtrace.Worse
+				if n.closed {
+					reason = navtrace.Closed
+				}
+				s.trace(navtrace.Reject, reason, qx, qy, st.h, g, g+s.heuristic(qx, qy))
+			}
+			return
+		}
+		n.g = g
+		n.f = g + s.heuristic(qx, qy)
+		n.parent = parent
+		n.st = st
+		n.link = lt
+		if n.heapIdx >= 0 {
+			heap.Fix(&s.open, int(n.heapIdx))
+		} else {
+			heap.Push(&s.open, i)
+		}
+		s.trace(navtrace.Push, 0, qx, qy, st.h, n.g, n.f)
+		return
+	}
+
+	i := int32(len(s.nodes))
+	s.nodes = append(s.nodes, searchNode{
+		qx: qx, qy: qy, st: st,
+		g: g, f: g + s.heuristic(qx, qy),
+		parent: parent, heapIdx: -1, link: lt,
+	})
+	s.index[key] = i
+	heap.Push(&s.open, i)
+	if s.tracer != nil {
+		s.trace(navtrace.Push, 0, qx, qy, st.h, g, s.nodes[i].f)
+	}
+}
+
+// trace records an event at (qx, qy, h) when a tracer is set.
+func (s *search) trace(k navtrace.Kind, r navtrace.Reason, qx, qy int32, h uint16, g, f float32) {
+	if s.tracer == nil {
+		return
+	}
+	s.tracer.Record(navtrace.Event{Kind: k, Reason: r, At: navtrace.Coord{X: qx, Y: int32(h), Z: qy}, G: g, F: f})
+}
+
+func (s *search) expand(cur int32) {
+	n := s.nodes[cur]
+	var reached [len(stepDirs)]bool
+	forEachStep(s.pl.Env, n.qx, n.qy, int32(n.st.h), s.pl.Filter, func(nx, ny int32, st stand, cost float32) {
+		if s.tracer != nil {
+			reached[stepIndex(nx-n.qx, ny-n.qy)] = true
+		}
+		if s.bounded && (nx < s.minQX || ny < s.minQY || nx >= s.maxQX || ny >= s.maxQY) {
+			s.trace(navtrace.Reject, navtrace.OutOfBounds, nx, ny, st.h, n.g, n.f)
+			return
+		}
+		s.push(nx, ny, st, n.g+stepCost(s.pl.Cost, s.pl.Env, s.pl.Filter, cost, n.st.h, nx, ny, st), cur)
+	})
+	if s.tracer != nil {
+		s.traceBlocked(&n, &reached)
+	}
+	s.expandLinks(cur)
+}
+
+// expandPreds pushes the stands with a move or link onto cur, at the cost of
+// that move.
+func (s *search) expandPreds(cur int32) {
+	n := s.nodes[cur]
+	env, f := s.pl.Env, s.pl.Filter
+	forEachPredStep(env, n.qx, n.qy, n.st.h, f, &s.floors, func(px, py int32, ph uint16, to stand, cost float32) {
+		if s.bounded && (px < s.minQX || py < s.minQY || px >= s.maxQX || py >= s.maxQY) {
+			return
+		}
+		s.push(px, py, stand{h: ph}, n.g+stepCost(s.pl.Cost, env, f, cost, ph, n.qx, n.qy, to), cur)
+	})
+	forEachLinkTo(s.pl.Links, env, n.qx, n.qy, n.st.h, f, func(_ LinkID, l *Link, fqx, fqy int32, from stand) {
+		if s.bounded && (fqx < s.minQX || fqy < s.minQY || fqx >= s.maxQX || fqy >= s.maxQY) {
+			return
+		}
+		s.pushLink(fqx, fqy, from, n.g+l.cost(), cur, l.Type)
+	})
+}
+
+// traceBlocked records the moves forEachStep skipped from n. The height of
+// a blocked move is the current floor.
+func (s *search) traceBlocked(n *searchNode, reached *[len(stepDirs)]bool) {
+	for d, dir := range stepDirs {
+		if reached[d] {
+			continue
+		}
+		reason := navtrace.Blocked
+		if d >= 4 && (!reached[dirIndex(dir.dx, 0)] || !reached[dirIndex(0, dir.dy)]) {
+			reason = navtrace.CornerCut
+		}
+		s.trace(navtrace.Reject, reason, n.qx+dir.dx, n.qy+dir.dy, n.st.h, n.g, n.f)
+	}
+}
+
+// expandLinks pushes the landing stands of the links leaving cur.
+func (s *search) expandLinks(cur int32) {
+	n := s.nodes[cur]
+	forEachLinkFrom(s.pl.Links, s.pl.Env, n.qx, n.qy, n.st.h, s.pl.Filter, func(_ LinkID, l *Link, tqx, tqy int32, st stand) {
+		if s.bounded && (tqx < s.minQX || tqy < s.minQY || tqx >= s.maxQX || tqy >= s.maxQY) {
+			return
+		}
+		s.pushLink(tqx, tqy, st, n.g+l.cost(), cur, l.Type)
+	})
+}
+
+// forEachStep visits every footprint reachable in one move from a stand at
+// (qx, qy) with floor curY. Diagonal moves may not cut corners.
+func forEachStep(env *zmap3base.Env, qx, qy, curY int32, f Filter, visit func(nx, ny int32, st stand, cost float32)) {
+	var orth [4]bool
+	for d := 0; d < len(stepDirs); d++ {
+		dir := stepDirs[d]
+		if d >= 4 {
+			// No corner cutting: both orthogonal moves must be passable.
+			if !orth[dirIndex(dir.dx, 0)] || !orth[dirIndex(0, dir.dy)] {
+				continue
+			}
+		}
+		nx, ny := qx+dir.dx, qy+dir.dy
+		st, ok := footprintStand(env, nx, ny, curY, f)
+		if d < 4 {
+			orth[d] = ok
+		}
+		if !ok {
+			continue
+		}
+		visit(nx, ny, st, dir.cost)
+	}
+}
+
+// stepFrom is the move of forEachStep in direction stepDirs[dir] alone.
+func stepFrom(env *zmap3base.Env, qx, qy, curY int32, dir int, f Filter) (stand, bool) {
+	step := stepDirs[dir]
+	if dir >= 4 {
+		if _, ok := footprintStand(env, qx+step.dx, qy, curY, f); !ok {
+			return stand{}, false
+		}
+		if _, ok := footprintStand(env, qx, qy+step.dy, curY, f); !ok {
+			return stand{}, false
+		}
+	}
+	return footprintStand(env, qx+step.dx, qy+step.dy, curY, f)
+}
+
+// forEachPredStep visits every floor ph at a neighbour (px, py) whose move
+// of forEachStep lands on floor h at (qx, qy), with the stand it lands on and
+// the step length. floors is a scratch buffer.
+func forEachPredStep(env *zmap3base.Env, qx, qy int32, h uint16, f Filter, floors *[]uint16, visit func(px, py int32, ph uint16, to stand, cost float32)) {
+	for dir, step := range stepDirs {
+		px, py := qx-step.dx, qy-step.dy
+		fl := appendFootprintFloors((*floors)[:0], env, px, py, f)
+		sort.Slice(fl, func(i, j int) bool { return fl[i] < fl[j] })
+		*floors = fl
+		for _, ph := range fl[:dedupeSorted(fl)] {
+			if to, ok := stepFrom(env, px, py, int32(ph), dir, f); ok && to.h == h {
+				visit(px, py, ph, to, step.cost)
+			}
+		}
+	}
+}
+
+// dirIndex maps an orthogonal step to its index in stepDirs.
+func dirIndex(dx, dy int32) int {
+	switch {
+	case dx > 0:
+		return 0
+	case dx < 0:
+		return 1
+	case dy > 0:
+		return 2
+	default:
+		return 3
+	}
+}
+
+// stepIndex maps any of the 8 steps to its index in stepDirs.
+func stepIndex(dx, dy int32) int {
+	if dx == 0 || dy == 0 {
+		return dirIndex(dx, dy)
+	}
+	for d := 4; d < len(stepDirs); d++ {
+		if stepDirs[d].dx == dx && stepDirs[d].dy == dy {
+			return d
+		}
+	}
+	return -1
+}
+
+func (s *search) reconstruct(goal int32) []Waypoint {
+	var chain []int32
+	for i := goal; i >= 0; i = s.nodes[i].parent {
+		chain = append(chain, i)
+	}
+	out := make([]Waypoint, 0, len(chain))
+	for k := len(chain) - 1; k >= 0; k-- {
+		n := &s.nodes[chain[k]]
+		if k < len(chain)-1 && s.jps != nil && n.link == LinkNone {
+			out = s.fillJump(out, &s.nodes[chain[k+1]], n)
+		}
+		out = append(out, waypointAt(s.pl.Env, s.pl.Filter, n.qx, n.qy, n.st, n.link))
+	}
+	return out
+}
+
+// openList is a binary heap of node indices ordered by f, then larger g.
+type openList struct {
+	items []int32
+	s     *search
+}
+
+func (o openList) Len() int { return len(o.items) }
+func (o openList) Less(i, j int) bool {
+	a, b := &o.s.nodes[o.items[i]], &o.s.nodes[o.items[j]]
+	if a.f == b.f {
+		return a.g > b.g
+	}
+	return a.f < b.f
+}
+func (o openList) Swap(i, j int) {
+	o.items[i], o.items[j] = o.items[j], o.items[i]
+	o.s.nodes[o.items[i]].heapIdx = int32(i)
+	o.s.nodes[o.items[j]].heapIdx = int32(j)
+}
+func (o *openList) Push(x any) {
+	i := x.(int32)
+	o.s.nodes[i].heapIdx = int32(len(o.items))
+	o.items = append(o.items, i)
+}
+func (o *openList) Pop() any {
+	n := len(o.items)
+	i := o.items[n-1]
+	o.items = o.items[:n-1]
+	o.s.nodes[i].heapIdx = -1
+	return i
+}
@@ -0,0 +1,192 @@
+package navgation
+
+import (
+	"bytes"
+	"math"
+	"reflect"
+	"testing"
+
+	"pathfinding/navtrace"
+	zmap3base "pathfinding/new_map"
+)
+
+// flatCells fills every cell of the test grid with terrain of height h.
+func flatCells(h uint16) map[int]cellFixture {
+	cells := make(map[int]cellFixture, zmap3base.FastGridCellNum)
+	for i := 0; i < zmap3base.FastGridCellNum; i++ {
+		cells[i] = cellFixture{terrain: rr(0, h, testTexBase)}
+	}
+	return cells
+}
+
+func cellIndex(x, y int) int {
+	return x + y*zmap3base.FastGridSetSize
+}
+
+func subPoint(qx, qy int32, h uint16) zmap3base.Point3d {
+	p, _ := subCellPoint2d(qx, qy)
+	return zmap3base.Point3d{X: p.X, Y: p.Y, XOffset: p.XOffset, YOffset: p.YOffset, H: h}
+}
+
+func testFilter() Filter {
+	return NewFilter(0, 0, 22, 22, 200)
+}
+
+func TestPlannerFindPath_Flat(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	pl := NewPlanner(env, testFilter())
+
+	res, ok := pl.FindPath(subPoint(4, 4, 10), subPoint(24, 24, 10))
+	if !ok {
+		t.Fatalf("expected a path")
+	}
+	if want := float32(20 * sqrt2); math.Abs(float64(res.Cost-want)) > 1e-3 {
+		t.Fatalf("cost got=%v want=%v", res.Cost, want)
+	}
+	if len(res.Waypoints) != 21 {
+		t.Fatalf("waypoints got=%d", len(res.Waypoints))
+	}
+	if res.StartRelocated || res.GoalRelocated {
+		t.Fatalf("unexpected relocation")
+	}
+}
+
+func TestPlannerFindPath_WallAndStep(t *testing.T) {
+	cells := flatCells(10)
+	// A 2m high wall on x=8 for y=0..19, and a 0.5m platform beyond it.
+	for y := 0; y < 20; y++ {
+		cells[cellIndex(8, y)] = cellFixture{terrain: rr(0, 50, testTexBase)}
+	}
+	for x := 12; x < 16; x++ {
+		for y := 0; y < 4; y++ {
+			cells[cellIndex(x, y)] = cellFixture{terrain: rr(0, 20, testTexBase)}
+		}
+	}
+	env := buildSingleGridEnv(t, cells)
+	pl := NewPlanner(env, testFilter())
+
+	res, ok := pl.FindPath(subPoint(8, 8, 10), subPoint(52, 4, 20))
+	if !ok {
+		t.Fatalf("expected a path around the wall")
+	}
+	for _, w := range res.Waypoints {
+		if w.X == 8 && w.Y < 20 {
+			t.Fatalf("path crosses the wall at %+v", w.Point3d)
+		}
+	}
+	last := res.Waypoints[len(res.Waypoints)-1]
+	if last.H != 20 {
+		t.Fatalf("expected to end on the platform, got %+v", last.Point3d)
+	}
+
+	pl.MaxExpansions = 10
+	if _, ok := pl.FindPath(subPoint(8, 8, 10), subPoint(52, 4, 20)); ok {
+		t.Fatalf("expected failure with a tiny expansion budget")
+	}
+}
+
+func TestPlannerFindPath_RelocateEndpoints(t *testing.T) {
+	cells := flatCells(10)
+	cells[cellIndex(3, 3)] = cellFixture{
+		terrain:   rr(0, 10, testTexBase),
+		lpPayload: []zmap3base.RichRange{rr(0, 200, testTexObs)},
+	}
+	env := buildSingleGridEnv(t, cells)
+	pl := NewPlanner(env, testFilter())
+
+	start := subPoint(13, 13, 10) // inside the pillar at cell (3,3)
+	goal := subPoint(40, 40, 10)
+	if _, ok := pl.FindPath(start, goal); ok {
+		t.Fatalf("expected failure for a start inside an obstacle")
+	}
+
+	pl.RelocateRadius = 8
+	res, ok := pl.FindPath(start, goal)
+	if !ok {
+		t.Fatalf("expected relocation to find a path")
+	}
+	if !res.StartRelocated || res.GoalRelocated {
+		t.Fatalf("unexpected relocation flags: %+v", res)
+	}
+	if res.Start.H != 10 || res.Waypoints[0].Point3d != res.Start {
+		t.Fatalf("unexpected relocated start: %+v first=%+v", res.Start, res.Waypoints[0])
+	}
+	qx, qy := subCellOf(res.Start.Point2d())
+	if qx >= 10 && qx <= 15 && qy >= 10 && qy <= 15 {
+		t.Fatalf("relocated start still overlaps the pillar: (%d,%d)", qx, qy)
+	}
+
+	pl.RelocateRadius = 1
+	if _, ok := pl.FindPath(start, goal); ok {
+		t.Fatalf("expected failure when the radius is too small")
+	}
+}
+
+func TestPlannerFindPath_Trace(t *testing.T) {
+	cells := flatCells(10)
+	for y := 0; y < 20; y++ {
+		cells[cellIndex(8, y)] = cellFixture{terrain: rr(0, 50, testTexBase)}
+	}
+	env := buildSingleGridEnv(t, cells)
+	pl := NewPlanner(env, testFilter())
+	tr := NewTrace()
+	pl.Tracer = tr
+
+	res, ok := pl.FindPath(subPoint(8, 8, 10), subPoint(52, 4, 10))
+	if !ok {
+		t.Fatalf("expected a path")
+	}
+	if tr.Events[0].Kind != navtrace.Start || tr.Events[1].Kind != navtrace.Goal {
+		t.Fatalf("trace should open with start and goal, got %v %v", tr.Events[0].Kind, tr.Events[1].Kind)
+	}
+	if got := tr.Count(navtrace.Expand); got != res.Expanded {
+		t.Fatalf("expand events got=%d want=%d", got, res.Expanded)
+	}
+	if pops, stale := tr.Count(navtrace.Pop), countReason(tr, navtrace.Stale); pops != res.Expanded+stale {
+		t.Fatalf("pops=%d expanded=%d stale=%d", pops, res.Expanded, stale)
+	}
+	if countReason(tr, navtrace.Blocked) == 0 {
+		t.Fatalf("expected blocked moves along the wall")
+	}
+	found, cost, path := tr.Result()
+	if !found || cost != res.Cost || len(path) != len(res.Waypoints) {
+		t.Fatalf("result found=%v cost=%v len=%d, want cost=%v len=%d", found, cost, len(path), res.Cost, len(res.Waypoints))
+	}
+	for i, w := range res.Waypoints {
+		qx, qy := subCellOf(w.Point2d())
+		if c := path[i]; c.X != qx || c.Z != qy || c.Y != int32(w.H) {
+			t.Fatalf("path[%d] got=%+v want=(%d,%d,%d)", i, c, qx, w.H, qy)
+		}
+	}
+
+	var buf bytes.Buffer
+	if _, err := tr.WriteTo(&buf); err != nil {
+		t.Fatalf("write: %v", err)
+	}
+	back, err := navtrace.Read(&buf)
+	if err != nil {
+		t.Fatalf("read: %v", err)
+	}
+	if back.CellSize != tr.CellSize || back.HeightScale != tr.HeightScale || !reflect.DeepEqual(back.Events, tr.Events) {
+		t.Fatalf("round trip changed the trace")
+	}
+
+	tr.Reset()
+	pl.MaxExpansions = 10
+	if _, ok := pl.FindPath(subPoint(8, 8, 10), subPoint(52, 4, 10)); ok {
+		t.Fatalf("expected failure with a tiny expansion budget")
+	}
+	if last := tr.Events[len(tr.Events)-1]; last.Kind != navtrace.Failed {
+		t.Fatalf("trace should end with failed, got %v", last.Kind)
+	}
+}
+
+func countReason(tr *navtrace.Trace, r navtrace.Reason) int {
+	n := 0
+	for _, e := range tr.Events {
+		if e.Kind == navtrace.Reject && e.Reason == r {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,185 @@
+package navgation
+
+import (
+	"math"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// RayMask selects which spans a ray sees. Spans matching Ignore are
+// transparent unless they also match Forbidden, which always blocks.
+type RayMask struct {
+	Ignore, Forbidden zmap3base.Texture
+}
+
+func (m RayMask) blocks(tex zmap3base.Texture) bool {
+	if m.Forbidden != 0 && (tex&m.Forbidden) != 0 {
+		return true
+	}
+	return m.Ignore == 0 || (tex&m.Ignore) == 0
+}
+
+// RayHit describes the first blocking span a ray touches.
+type RayHit struct {
+	Point    [3]float32 // world x, y, z
+	Normal   [3]float32 // face normal; zero when the origin is inside the span
+	Distance float32    // meters from the origin
+	Column   zmap3base.Point2d
+	zmap3base.RichRange
+}
+
+const (
+	rayAxisNone = iota
+	rayAxisX
+	rayAxisZ
+)
+
+// Raycast traces a ray from origin (x, y, z in meters, y up) along dir for at
+// most maxDist meters. It walks the 0.25m sub-columns with a DDA and
+// intersects the segment inside each column with its blocking spans, terrain
+// included. Route work is shared while the ray stays in one GridRBData.
+func Raycast(env *zmap3base.Env, origin, dir [3]float32, maxDist float32, mask RayMask) (hit RayHit, ok bool) {
+	if env == nil || maxDist <= 0 {
+		return
+	}
+	l := float32(math.Sqrt(float64(dir[0]*dir[0] + dir[1]*dir[1] + dir[2]*dir[2])))
+	if l == 0 {
+		return
+	}
+	dx, dy, dz := dir[0]/l, dir[1]/l, dir[2]/l
+	ox, oy, oz := origin[0], origin[1], origin[2]
+	if ox < 0 || oz < 0 {
+		return
+	}
+
+	const cell = zmap3base.SecondaryTileLen
+	qx := int32(ox / cell)
+	qz := int32(oz / cell)
+
+	stepX, tMaxX, tDeltaX := ddaAxis(ox, dx, qx)
+	stepZ, tMaxZ, tDeltaZ := ddaAxis(oz, dz, qz)
+
+	buf := fastRichRangeSlicePool.Get().(*[]zmap3base.RichRange)
+	spans := (*buf)[:0]
+	defer func() { recycleFastSpanBuf(buf, spans) }()
+
+	var (
+		g         *zmap3base.GridRBData
+		tEnter    float32
+		enterAxis = rayAxisNone
+	)
+
+	for {
+		p2d, inside := subCellPoint2d(qx, qz)
+		if !inside || !env.Validate2d(p2d) {
+			return
+		}
+		rc, routed := routeShared(env, g, p2d)
+		if !routed {
+			return
+		}
+		g = rc.G
+
+		tExit := min(tMaxX, tMaxZ, maxDist)
+
+		var terrain zmap3base.RichRange
+		var collected bool
+		terrain, spans, collected = collectRoutedSpans(rc, spans[:0])
+		if collected {
+			spans = append(spans, terrain)
+			if t, rr, found := rayColumnHit(spans, oy, dy, tEnter, tExit, mask); found {
+				hit.Distance = t
+				hit.Point = [3]float32{ox + dx*t, oy + dy*t, oz + dz*t}
+				hit.Column = p2d
+				hit.RichRange = rr
+				switch {
+				case t > tEnter:
+					if dy < 0 {
+						hit.Normal = [3]float32{0, 1, 0}
+					} else {
+						hit.Normal = [3]float32{0, -1, 0}
+					}
+				case enterAxis == rayAxisX:
+					hit.Normal = [3]float32{float32(-stepX), 0, 0}
+				case enterAxis == rayAxisZ:
+					hit.Normal = [3]float32{0, 0, float32(-stepZ)}
+				}
+				return hit, true
+			}
+		}
+
+		if tExit >= maxDist {
+			return
+		}
+		if tMaxX < tMaxZ {
+			tEnter, enterAxis = tMaxX, rayAxisX
+			qx += stepX
+			tMaxX += tDeltaX
+		} else {
+			tEnter, enterAxis = tMaxZ, rayAxisZ
+			qz += stepZ
+			tMaxZ += tDeltaZ
+		}
+	}
+}
+
+// ddaAxis returns the DDA step, the t of the first boundary and the t between
+// boundaries along one horizontal axis.
+func ddaAxis(o, d float32, q int32) (step int32, tMax, tDelta float32) {
+	const cell = zmap3base.SecondaryTileLen
+	inf := float32(math.Inf(1))
+	switch {
+	case d > 0:
+		return 1, (float32(q+1)*cell - o) / d, cell / d
+	case d < 0:
+		return -1, (float32(q)*cell - o) / d, -cell / d
+	default:
+		return 0, inf, inf
+	}
+}
+
+// routeShared skips Env.Route while p2d stays inside the last grid.
+func routeShared(env *zmap3base.Env, g *zmap3base.GridRBData, p2d zmap3base.Point2d) (zmap3base.RouteCtx, bool) {
+	// uint16 wrap-around makes the subtraction fail for points left of/below the grid.
+	if g != nil && p2d.X-g.BaseX() < zmap3base.FastGridSetSize && p2d.Y-g.BaseY() < zmap3base.FastGridSetSize {
+		subIdx, ok := zmap3base.SubIdxFromPoint2d(p2d)
+		if !ok {
+			return zmap3base.RouteCtx{}, false
+		}
+		return zmap3base.RouteCtx{G: g, SourceP2d: p2d, CellIdx: g.CellIdx(p2d.X, p2d.Y), IsHP: true, SubIdx: subIdx}, true
+	}
+	return env.Route(p2d)
+}
+
+// rayColumnHit returns the smallest t in [tEnter, tExit] where the ray height
+// lies inside a blocking span.
+func rayColumnHit(spans []zmap3base.RichRange, oy, dy, tEnter, tExit float32, mask RayMask) (best float32, bestRR zmap3base.RichRange, found bool) {
+	for i := 0; i < len(spans); i++ {
+		rr := spans[i]
+		if rr.End <= rr.Begin || !mask.blocks(rr.Accessory.Texture) {
+			continue
+		}
+		b := heightToWorld(rr.Begin)
+		e := heightToWorld(rr.End)
+
+		lo, hi := tEnter, tExit
+		if dy == 0 {
+			if oy < b || oy >= e {
+				continue
+			}
+		} else {
+			t1, t2 := (b-oy)/dy, (e-oy)/dy
+			if t1 > t2 {
+				t1, t2 = t2, t1
+			}
+			lo, hi = max(lo, t1), min(hi, t2)
+			if lo > hi {
+				continue
+			}
+		}
+		if !found || lo < best {
+			best, bestRR, found = lo, rr, true
+		}
+	}
+	return
+}
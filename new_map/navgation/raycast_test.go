@@ -0,0 +1,79 @@
+package navgation
+
+import (
+	"math"
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestRaycast(t *testing.T) {
+	cells := flatCells(10)
+	// Wall at x=6 from 0.5m to 3m, a collider slab at x=10 from 1m to 1.5m.
+	for y := 0; y < zmap3base.FastGridSetSize; y++ {
+		cells[cellIndex(6, y)] = cellFixture{
+			terrain:   rr(0, 10, testTexBase),
+			lpPayload: []zmap3base.RichRange{{Range: zmap3base.Range{Begin: 10, End: 60}, Accessory: zmap3base.Accessory{Texture: testTexObs, Config: 42}}},
+		}
+		cells[cellIndex(10, y)] = cellFixture{
+			terrain:   rr(0, 10, testTexBase),
+			lpPayload: []zmap3base.RichRange{rr(20, 30, testTexCol)},
+		}
+	}
+	env := buildSingleGridEnv(t, cells)
+
+	near := func(a, b float32) bool { return math.Abs(float64(a-b)) < 1e-3 }
+
+	t.Run("horizontal-hits-wall-side", func(t *testing.T) {
+		hit, ok := Raycast(env, [3]float32{2.1, 1, 5.3}, [3]float32{1, 0, 0}, 20, RayMask{})
+		if !ok {
+			t.Fatalf("expected hit")
+		}
+		if !near(hit.Point[0], 6) || !near(hit.Distance, 3.9) || hit.Normal != [3]float32{-1, 0, 0} {
+			t.Fatalf("unexpected hit: %+v", hit)
+		}
+		if hit.Accessory.Config != 42 || hit.Accessory.Texture != testTexObs || hit.Column.X != 6 {
+			t.Fatalf("unexpected span: %+v", hit)
+		}
+	})
+
+	t.Run("downward-hits-ground-top", func(t *testing.T) {
+		hit, ok := Raycast(env, [3]float32{3.3, 5, 3.3}, [3]float32{0, -1, 0}, 20, RayMask{})
+		if !ok || !near(hit.Point[1], 0.5) || hit.Normal != [3]float32{0, 1, 0} || hit.Accessory.Texture != testTexBase {
+			t.Fatalf("unexpected hit: %+v ok=%v", hit, ok)
+		}
+	})
+
+	t.Run("rising-hits-slab-bottom", func(t *testing.T) {
+		// Rising ray crosses y=1m inside the slab column and hits its bottom face.
+		hit, ok := Raycast(env, [3]float32{7.1, 0.8, 2.3}, [3]float32{1, 0.06, 0}, 20, RayMask{})
+		if !ok || hit.Column.X != 10 || hit.Accessory.Texture != testTexCol || hit.Normal != [3]float32{0, -1, 0} || !near(hit.Point[1], 1) {
+			t.Fatalf("unexpected hit: %+v ok=%v", hit, ok)
+		}
+	})
+
+	t.Run("ignore-and-forbidden", func(t *testing.T) {
+		hit, ok := Raycast(env, [3]float32{7.1, 1.2, 2.3}, [3]float32{1, 0, 0}, 20, RayMask{Ignore: testTexCol})
+		if ok {
+			t.Fatalf("expected ignored slab to be transparent, got %+v", hit)
+		}
+		_, ok = Raycast(env, [3]float32{7.1, 1.2, 2.3}, [3]float32{1, 0, 0}, 20,
+			RayMask{Ignore: testTexCol, Forbidden: testTexCol})
+		if !ok {
+			t.Fatalf("expected forbidden texture to block")
+		}
+	})
+
+	t.Run("max-distance", func(t *testing.T) {
+		if _, ok := Raycast(env, [3]float32{2.1, 1, 5.3}, [3]float32{1, 0, 0}, 3, RayMask{}); ok {
+			t.Fatalf("expected no hit within 3m")
+		}
+	})
+
+	t.Run("origin-inside", func(t *testing.T) {
+		hit, ok := Raycast(env, [3]float32{6.5, 1, 5.5}, [3]float32{0, 0, 1}, 5, RayMask{})
+		if !ok || hit.Distance != 0 || hit.Normal != [3]float32{} {
+			t.Fatalf("unexpected hit: %+v ok=%v", hit, ok)
+		}
+	})
+}
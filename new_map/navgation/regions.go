@@ -0,0 +1,355 @@
+package navgation
+
+import (
+	"sort"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// RegionMap labels the connected walkable regions of an Env for one agent
+// profile (Filter). Nodes are the planner states: a footprint reference
+// sub-cell plus the floor it stands on; edges are the planner moves and the
+// off-mesh links set with SetLinks. Two
+// points in different regions can never be joined by Planner.FindPath, so
+// SameRegion returning false is a sound and instant rejection. Regions are
+// weakly connected, so true does not guarantee a path (one-way drops).
+//
+// Labels are kept per GridRBData and stitched by a global union-find, so an
+// edit only rebuilds the touched grids. RegionMap is not safe for concurrent
+// use with Env edits.
+type RegionMap struct {
+	env    *zmap3base.Env
+	filter Filter
+
+	gridW, gridH int
+	grids        []*regionGrid // len = gridW*gridH, nil for unloaded grids
+
+	links  *LinkRegistry
+	linkID int
+
+	listenerID int
+}
+
+// regionGrid holds the nodes of one GridRBData in CSR form: the floors of the
+// sub-cell at local index li are floors[offsets[li]:offsets[li+1]], ascending.
+type regionGrid struct {
+	qx0, qy0 int32
+
+	offsets []uint32
+	floors  []uint16
+	comp    []uint32 // node -> local component
+	nComp   uint32
+	border  []regionBorder
+
+	global []uint32 // local component -> region label
+}
+
+// regionBorder is an edge from a local node to a node of another grid.
+type regionBorder struct {
+	from   uint32
+	qx, qy int32
+	h      uint16
+}
+
+// NewRegionMap labels every loaded grid of env and keeps the labels up to date
+// through ApplyRichOperationsExt. Call Detach when the map is no longer used.
+func NewRegionMap(env *zmap3base.Env, f Filter) *RegionMap {
+	w, h := env.GridDims()
+	m := &RegionMap{
+		env:    env,
+		filter: f,
+		gridW:  w,
+		gridH:  h,
+		grids:  make([]*regionGrid, w*h),
+	}
+	for gy := 0; gy < h; gy++ {
+		for gx := 0; gx < w; gx++ {
+			m.grids[gx+gy*w] = m.buildGrid(gx, gy)
+		}
+	}
+	m.stitch()
+	m.listenerID = env.AddChangeListener(m.Update)
+	return m
+}
+
+// Detach stops listening to Env edits and link changes. Labels are frozen
+// afterwards.
+func (m *RegionMap) Detach() {
+	if m.listenerID != 0 {
+		m.env.RemoveChangeListener(m.listenerID)
+		m.listenerID = 0
+	}
+	if m.linkID != 0 {
+		m.links.RemoveChangeListener(m.linkID)
+		m.linkID = 0
+	}
+}
+
+// SetLinks adds the off-mesh links of r (nil for none) to the region edges
+// and relabels every grid. Labels follow later link changes.
+func (m *RegionMap) SetLinks(r *LinkRegistry) {
+	if m.linkID != 0 {
+		m.links.RemoveChangeListener(m.linkID)
+		m.linkID = 0
+	}
+	m.links = r
+	if r != nil {
+		m.linkID = r.AddChangeListener(m.Update)
+	}
+	for i := range m.grids {
+		m.grids[i] = m.buildGrid(i%m.gridW, i/m.gridW)
+	}
+	m.stitch()
+}
+
+// Update relabels the grids around the changed LP points. A footprint and its
+// moves reach one sub-cell past its own grid, so the 8 neighbour grids are
+// rebuilt as well. It is called automatically for ApplyRichOperationsExt and
+// link changes.
+func (m *RegionMap) Update(lps []zmap3base.Point2d) {
+	dirty := make(map[int]struct{})
+	for _, p := range lps {
+		gx, gy, ok := m.env.GridCoordOf(p)
+		if !ok {
+			continue
+		}
+		for dy := -1; dy <= 1; dy++ {
+			for dx := -1; dx <= 1; dx++ {
+				x, y := gx+dx, gy+dy
+				if x < 0 || y < 0 || x >= m.gridW || y >= m.gridH {
+					continue
+				}
+				dirty[x+y*m.gridW] = struct{}{}
+			}
+		}
+	}
+	if len(dirty) == 0 {
+		return
+	}
+	for i := range dirty {
+		m.grids[i] = m.buildGrid(i%m.gridW, i/m.gridW)
+	}
+	m.stitch()
+}
+
+// Label returns the region of an agent standing at p (p.H is its height).
+// Labels start at 1; ok is false when p has no valid stand.
+func (m *RegionMap) Label(p zmap3base.Point3d) (label uint32, ok bool) {
+	qx, qy := subCellOf(p.Point2d())
+	st, ok := footprintStand(m.env, qx, qy, int32(p.H), m.filter)
+	if !ok {
+		return 0, false
+	}
+	return m.labelAt(qx, qy, st.h)
+}
+
+// SameRegion reports whether a and b may be connected. false means
+// Planner.FindPath with the same filter and no relocation must fail.
+func (m *RegionMap) SameRegion(a, b zmap3base.Point3d) bool {
+	la, ok := m.Label(a)
+	if !ok {
+		return false
+	}
+	lb, ok := m.Label(b)
+	return ok && la == lb
+}
+
+// connected is SameRegion on resolved stands. Unknown nodes are reported as
+// connected so the caller falls back to searching.
+func (m *RegionMap) connected(aqx, aqy int32, ah uint16, bqx, bqy int32, bh uint16) bool {
+	la, ok := m.labelAt(aqx, aqy, ah)
+	if !ok {
+		return true
+	}
+	lb, ok := m.labelAt(bqx, bqy, bh)
+	return !ok || la == lb
+}
+
+// labelAt looks up the node (qx, qy, h).
+func (m *RegionMap) labelAt(qx, qy int32, h uint16) (uint32, bool) {
+	rg := m.gridOfSub(qx, qy)
+	if rg == nil {
+		return 0, false
+	}
+	i, ok := rg.node(qx, qy, h)
+	if !ok {
+		return 0, false
+	}
+	return rg.global[rg.comp[i]], true
+}
+
+func (m *RegionMap) gridOfSub(qx, qy int32) *regionGrid {
+	gx, gy, ok := gridOfSubCell(m.env, qx, qy)
+	if !ok {
+		return nil
+	}
+	return m.grids[gx+gy*m.gridW]
+}
+
+func (rg *regionGrid) local(qx, qy int32) (int, bool) {
+	lx, ly := qx-rg.qx0, qy-rg.qy0
+	if lx < 0 || ly < 0 || lx >= gridSideSub || ly >= gridSideSub {
+		return 0, false
+	}
+	return int(lx + ly*gridSideSub), true
+}
+
+func (rg *regionGrid) node(qx, qy int32, h uint16) (uint32, bool) {
+	li, ok := rg.local(qx, qy)
+	if !ok {
+		return 0, false
+	}
+	lo, hi := rg.offsets[li], rg.offsets[li+1]
+	for i := lo; i < hi; i++ {
+		if rg.floors[i] == h {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// buildGrid enumerates the nodes of grid (gx, gy) and unions its local edges.
+func (m *RegionMap) buildGrid(gx, gy int) *regionGrid {
+	if m.env.GridAt(gx, gy) == nil {
+		return nil
+	}
+	rg := &regionGrid{offsets: make([]uint32, gridSideSub*gridSideSub+1)}
+	rg.qx0, rg.qy0 = gridOrigin(m.env, gx, gy)
+
+	// 1) nodes: a footprint stands on the highest floor of its sub-columns,
+	//    so every stand height is one of their gap floors.
+	for ly := int32(0); ly < gridSideSub; ly++ {
+		for lx := int32(0); lx < gridSideSub; lx++ {
+			li := lx + ly*gridSideSub
+			start := len(rg.floors)
+			rg.floors = appendFootprintFloors(rg.floors, m.env, rg.qx0+lx, rg.qy0+ly, m.filter)
+			rg.offsets[li+1] = uint32(len(rg.floors))
+			if len(rg.floors)-start > 1 {
+				tail := rg.floors[start:]
+				sort.Slice(tail, func(i, j int) bool { return tail[i] < tail[j] })
+				rg.floors = rg.floors[:start+dedupeSorted(tail)]
+				rg.offsets[li+1] = uint32(len(rg.floors))
+			}
+		}
+	}
+
+	// 2) edges
+	parent := make([]uint32, len(rg.floors))
+	for i := range parent {
+		parent[i] = uint32(i)
+	}
+	for li := 0; li < gridSideSub*gridSideSub; li++ {
+		qx, qy := rg.qx0+int32(li%gridSideSub), rg.qy0+int32(li/gridSideSub)
+		for i := rg.offsets[li]; i < rg.offsets[li+1]; i++ {
+			from := i
+			edge := func(nx, ny int32, st stand) {
+				if j, ok := rg.node(nx, ny, st.h); ok {
+					unionFind(parent, from, j)
+					return
+				}
+				if _, inside := rg.local(nx, ny); !inside {
+					rg.border = append(rg.border, regionBorder{from: from, qx: nx, qy: ny, h: st.h})
+				}
+			}
+			forEachStep(m.env, qx, qy, int32(rg.floors[i]), m.filter, func(nx, ny int32, st stand, _ float32) {
+				edge(nx, ny, st)
+			})
+			forEachLinkFrom(m.links, m.env, qx, qy, rg.floors[i], m.filter, func(_ LinkID, _ *Link, tqx, tqy int32, st stand) {
+				edge(tqx, tqy, st)
+			})
+		}
+	}
+
+	// 3) compact local components
+	rg.comp = make([]uint32, len(parent))
+	ids := make(map[uint32]uint32)
+	for i := range parent {
+		r := findRoot(parent, uint32(i))
+		c, ok := ids[r]
+		if !ok {
+			c = rg.nComp
+			ids[r] = c
+			rg.nComp++
+		}
+		rg.comp[i] = c
+	}
+	return rg
+}
+
+// stitch joins the local components of all grids through their border edges
+// and assigns the final labels.
+func (m *RegionMap) stitch() {
+	base := make([]uint32, len(m.grids))
+	total := uint32(0)
+	for i, rg := range m.grids {
+		base[i] = total
+		if rg != nil {
+			total += rg.nComp
+		}
+	}
+	parent := make([]uint32, total)
+	for i := range parent {
+		parent[i] = uint32(i)
+	}
+	for i, rg := range m.grids {
+		if rg == nil {
+			continue
+		}
+		for _, b := range rg.border {
+			to := m.gridOfSub(b.qx, b.qy)
+			if to == nil {
+				continue
+			}
+			j, ok := to.node(b.qx, b.qy, b.h)
+			if !ok {
+				continue
+			}
+			unionFind(parent, base[i]+rg.comp[b.from], base[m.gridIndexOf(to)]+to.comp[j])
+		}
+	}
+	for i, rg := range m.grids {
+		if rg == nil {
+			continue
+		}
+		rg.global = make([]uint32, rg.nComp)
+		for c := uint32(0); c < rg.nComp; c++ {
+			rg.global[c] = findRoot(parent, base[i]+c) + 1
+		}
+	}
+}
+
+func (m *RegionMap) gridIndexOf(rg *regionGrid) int {
+	gx, gy, _ := gridOfSubCell(m.env, rg.qx0, rg.qy0)
+	return gx + gy*m.gridW
+}
+
+func dedupeSorted(s []uint16) int {
+	n := 0
+	for i := range s {
+		if i == 0 || s[i] != s[n-1] {
+			s[n] = s[i]
+			n++
+		}
+	}
+	return n
+}
+
+func findRoot(parent []uint32, i uint32) uint32 {
+	for parent[i] != i {
+		parent[i] = parent[parent[i]]
+		i = parent[i]
+	}
+	return i
+}
+
+func unionFind(parent []uint32, a, b uint32) {
+	ra, rb := findRoot(parent, a), findRoot(parent, b)
+	if ra == rb {
+		return
+	}
+	if ra < rb {
+		parent[rb] = ra
+	} else {
+		parent[ra] = rb
+	}
+}
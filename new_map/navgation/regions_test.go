@@ -0,0 +1,71 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestRegionMap(t *testing.T) {
+	cells := flatCells(10)
+	// A raised 2m platform at x=24..27, y=24..27 is an island of its own.
+	for x := 24; x < 28; x++ {
+		for y := 24; y < 28; y++ {
+			cells[cellIndex(x, y)] = cellFixture{terrain: rr(0, 50, testTexBase)}
+		}
+	}
+	env := buildSingleGridEnv(t, cells)
+	// Regions are weakly connected, so the drop limit must also keep the agent
+	// off the platform.
+	f := NewFilter(0, 0, 22, 22, 22)
+	rm := NewRegionMap(env, f)
+	defer rm.Detach()
+
+	west, east := subPoint(8, 40, 10), subPoint(100, 40, 10)
+	island := subPoint(100, 100, 50)
+	if !rm.SameRegion(west, east) {
+		t.Fatalf("open floor should be one region")
+	}
+	if rm.SameRegion(west, island) {
+		t.Fatalf("the platform should be a separate region")
+	}
+
+	// A wall across the whole grid at x=16 splits the floor.
+	wall := make([]zmap3base.Point3d, 0, zmap3base.FastGridSetSize)
+	for y := 0; y < zmap3base.FastGridSetSize; y++ {
+		wall = append(wall, zmap3base.Point3d{X: 16, Y: uint16(y), H: 10, RangeEnd: 60})
+	}
+	acc := zmap3base.Accessory{Texture: testTexObs, Config: 7}
+	if !env.ApplyRichOperationsExt(wall, nil, acc) {
+		t.Fatalf("add wall failed")
+	}
+	if rm.SameRegion(west, east) {
+		t.Fatalf("wall should split the floor")
+	}
+
+	pl := NewPlanner(env, f)
+	pl.Regions = rm
+	res, ok := pl.FindPath(west, east)
+	if ok || res.Expanded != 0 {
+		t.Fatalf("expected an instant rejection, got ok=%v expanded=%d", ok, res.Expanded)
+	}
+	pl.Regions = nil
+	if _, ok := pl.FindPath(west, east); ok {
+		t.Fatalf("planner disagrees with regions")
+	}
+
+	// Opening a 1m door merges the halves again.
+	if !env.ApplyRichOperationsExt(nil, wall[20:21], acc) {
+		t.Fatalf("remove wall failed")
+	}
+	if !rm.SameRegion(west, east) {
+		t.Fatalf("door should merge the regions")
+	}
+	pl.Regions = rm
+	if _, ok := pl.FindPath(west, east); !ok {
+		t.Fatalf("expected a path through the door")
+	}
+	if _, ok := rm.Label(subPoint(64, 81, 10)); !ok {
+		t.Fatalf("door should be labelled")
+	}
+}
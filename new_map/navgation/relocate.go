@@ -0,0 +1,54 @@
+package navgation
+
+import (
+	zmap3base "pathfinding/new_map"
+)
+
+// relocateStand runs a BFS over sub-cells from (qx, qy) and returns the
+// nearest footprint with a valid stand for an agent at curY. The BFS walks
+// through blocked sub-cells too, since the origin is usually inside geometry;
+// radius bounds it by Chebyshev distance. Within one BFS layer the smallest
+// horizontal, then vertical, offset wins.
+func relocateStand(env *zmap3base.Env, qx, qy, curY, radius int32, f Filter) (bx, by int32, best stand, ok bool) {
+	type cell struct{ x, y int32 }
+
+	visited := make(map[cell]struct{}, int((2*radius+1)*(2*radius+1)))
+	layer := []cell{{qx, qy}}
+	visited[cell{qx, qy}] = struct{}{}
+	var next []cell
+	var bestScore int64
+
+	for depth := int32(0); depth <= radius && len(layer) > 0; depth++ {
+		for _, c := range layer {
+			st, valid := footprintStand(env, c.x, c.y, curY, f)
+			if !valid {
+				continue
+			}
+			dx, dy := int64(c.x-qx), int64(c.y-qy)
+			score := (dx*dx+dy*dy)<<16 + int64(abs32(int32(st.h)-curY))
+			if !ok || score < bestScore {
+				bx, by, best, bestScore, ok = c.x, c.y, st, score, true
+			}
+		}
+		if ok {
+			return
+		}
+
+		next = next[:0]
+		for _, c := range layer {
+			for _, dir := range stepDirs {
+				n := cell{c.x + dir.dx, c.y + dir.dy}
+				if _, seen := visited[n]; seen {
+					continue
+				}
+				if _, inside := subCellPoint2d(n.x, n.y); !inside {
+					continue
+				}
+				visited[n] = struct{}{}
+				next = append(next, n)
+			}
+		}
+		layer, next = next, layer
+	}
+	return
+}
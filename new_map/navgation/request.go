@@ -0,0 +1,242 @@
+package navgation
+
+import (
+	"sync"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// StepStatus is the state of a PathRequest.
+type StepStatus uint8
+
+const (
+	InProgress StepStatus = iota
+	Found
+	Failed
+)
+
+func (s StepStatus) String() string {
+	switch s {
+	case InProgress:
+		return "in-progress"
+	case Found:
+		return "found"
+	case Failed:
+		return "failed"
+	}
+	return "unknown"
+}
+
+// ChangePolicy tells a PathRequest what to do when the Env changes between
+// two Step calls (see Env.Epoch). A change to Planner.Links always restarts
+// the search.
+type ChangePolicy uint8
+
+const (
+	// Restart drops the search and starts over from the endpoints.
+	Restart ChangePolicy = iota
+	// Repair keeps the search when no edit lies within reach of a node it
+	// has generated and the goal stand is unchanged, and restarts otherwise.
+	// Searches with a cost model or jump points always restart: they read
+	// the map beyond the generated nodes.
+	Repair
+)
+
+// PathRequest is a time-sliced FindPath: Step runs a bounded number of
+// expansions and keeps the open and closed sets for the next call, so a
+// search can be spread over several game ticks. The Planner must not be
+// changed while a request is in progress. Call Close to drop a request
+// before it finishes.
+type PathRequest struct {
+	pl          *Planner
+	start, goal zmap3base.Point3d
+	policy      ChangePolicy
+
+	status StepStatus
+	res    PathResult
+	s      *search
+	epoch  uint64 // Env.Epoch the search was built or repaired against
+
+	// Repair bookkeeping: the LP points changed since epoch, and the last
+	// epoch the listener saw. A gap means an edit without a change event
+	// (SetGrid), which forces a restart.
+	listenerID int
+	changed    []zmap3base.Point2d
+	seenEpoch  uint64
+	missed     bool
+
+	// linksID is the Planner.Links listener; linksChanged forces a restart.
+	linksID      int
+	linksChanged bool
+
+	restarts, repairs int
+
+	scratch  *searchScratch // reused search buffers, see Scheduler
+	listenMu *sync.Mutex    // guards listener (de)registration, see Scheduler
+}
+
+// NewRequest returns a request from start to goal. Nothing is searched
+// before the first Step.
+func (pl *Planner) NewRequest(start, goal zmap3base.Point3d, policy ChangePolicy) *PathRequest {
+	return &PathRequest{pl: pl, start: start, goal: goal, policy: policy}
+}
+
+// Step runs at most budget expansions (budget <= 0 means until done) and
+// returns the new status. Planner.MaxExpansions bounds the total over all
+// steps since the last restart. Once Found or Failed, Step returns the same
+// status without searching.
+func (r *PathRequest) Step(budget int) StepStatus {
+	if r.status != InProgress {
+		return r.status
+	}
+	if r.s == nil {
+		if !r.begin() {
+			return r.status
+		}
+	} else if epoch := r.pl.Env.Epoch(); epoch != r.epoch || r.linksChanged {
+		if !r.linksChanged && r.canRepair() {
+			r.repairs++
+			r.epoch = epoch
+			r.changed = r.changed[:0]
+		} else {
+			r.restarts++
+			if !r.begin() {
+				return r.status
+			}
+		}
+	}
+
+	limit := r.pl.MaxExpansions
+	if budget > 0 && (limit <= 0 || r.s.expanded+budget < limit) {
+		limit = r.s.expanded + budget
+	}
+	end, ok := r.s.run(limit)
+	switch {
+	case ok:
+		r.done(r.pl.finish(r.s, r.res, end, true))
+	case r.s.open.Len() == 0 || (r.pl.MaxExpansions > 0 && r.s.expanded >= r.pl.MaxExpansions):
+		r.done(r.pl.finish(r.s, r.res, -1, false))
+	default:
+		r.res.Expanded = r.s.expanded
+	}
+	return r.status
+}
+
+// Status returns the status after the last Step.
+func (r *PathRequest) Status() StepStatus { return r.status }
+
+// Result returns the path once Found. Before that it holds the endpoints
+// and the expansions so far.
+func (r *PathRequest) Result() PathResult { return r.res }
+
+// Restarts returns how often a map change restarted the search; Repairs how
+// often the search was kept across a change.
+func (r *PathRequest) Restarts() int { return r.restarts }
+func (r *PathRequest) Repairs() int  { return r.repairs }
+
+// Close stops listening to Env and link edits. Finished requests close
+// themselves.
+func (r *PathRequest) Close() {
+	if r.listenMu != nil {
+		r.listenMu.Lock()
+		defer r.listenMu.Unlock()
+	}
+	if r.listenerID != 0 {
+		r.pl.Env.RemoveChangeListener(r.listenerID)
+		r.listenerID = 0
+	}
+	if r.linksID != 0 {
+		r.pl.Links.RemoveChangeListener(r.linksID)
+		r.linksID = 0
+	}
+	r.changed = nil
+}
+
+// begin (re)starts the search against the current map.
+func (r *PathRequest) begin() bool {
+	r.epoch = r.pl.Env.Epoch()
+	r.seenEpoch, r.missed = r.epoch, false
+	r.linksChanged = false
+	r.changed = r.changed[:0]
+	r.scratch.reclaim(r.s)
+	s, res, ok := r.pl.begin(r.scratch, r.start, r.goal)
+	r.s, r.res = s, res
+	if !ok {
+		r.done(res, false)
+		return false
+	}
+	if r.listenMu != nil {
+		r.listenMu.Lock()
+		defer r.listenMu.Unlock()
+	}
+	if r.policy == Repair && r.listenerID == 0 {
+		r.listenerID = r.pl.Env.AddChangeListener(r.onChange)
+	}
+	if r.pl.Links != nil && r.linksID == 0 {
+		r.linksID = r.pl.Links.AddChangeListener(func([]zmap3base.Point2d) { r.linksChanged = true })
+	}
+	return true
+}
+
+func (r *PathRequest) done(res PathResult, ok bool) {
+	r.res = res
+	r.status = Failed
+	if ok {
+		r.status = Found
+	}
+	r.scratch.reclaim(r.s)
+	r.s = nil
+	r.Close()
+}
+
+func (r *PathRequest) onChange(lps []zmap3base.Point2d) {
+	if epoch := r.pl.Env.Epoch(); epoch == r.seenEpoch+1 {
+		r.seenEpoch = epoch
+	} else {
+		r.missed = true
+	}
+	r.changed = append(r.changed, lps...)
+}
+
+// canRepair reports whether the search survives the changes since epoch.
+// Generating a node reads the footprint sub-cells [q, q+n) of its stand and
+// expanding it those of its 8 neighbours, so a node at q depends on the LP
+// cells covering sub-cells [q-1, q+n] on either axis. Each node is tested
+// against the bounding box of the changed LP cells, and only inside it
+// against the set of them.
+func (r *PathRequest) canRepair() bool {
+	pl, s := r.pl, r.s
+	if r.policy != Repair || r.missed || r.seenEpoch != pl.Env.Epoch() || pl.Cost != nil || s.jps != nil {
+		return false
+	}
+	gst, ok := footprintStand(pl.Env, s.goalQX, s.goalQY, int32(s.goalH), pl.Filter)
+	if !ok || gst.h != s.goalH {
+		return false
+	}
+	if len(r.changed) == 0 {
+		return true
+	}
+	changed := make(map[uint32]struct{}, len(r.changed))
+	minX, minY := int32(r.changed[0].X), int32(r.changed[0].Y)
+	maxX, maxY := minX, minY
+	for _, lp := range r.changed {
+		x, y := int32(lp.X), int32(lp.Y)
+		changed[uint32(x)<<16|uint32(y)] = struct{}{}
+		minX, maxX = min(minX, x), max(maxX, x)
+		minY, maxY = min(minY, y), max(maxY, y)
+	}
+	n := pl.Filter.footprint()
+	for i := range s.nodes {
+		nd := &s.nodes[i]
+		x0, x1 := max((nd.qx-1)/zmap3base.SecondaryAccuracy, minX), min((nd.qx+n)/zmap3base.SecondaryAccuracy, maxX)
+		y0, y1 := max((nd.qy-1)/zmap3base.SecondaryAccuracy, minY), min((nd.qy+n)/zmap3base.SecondaryAccuracy, maxY)
+		for x := x0; x <= x1; x++ {
+			for y := y0; y <= y1; y++ {
+				if _, ok := changed[uint32(x)<<16|uint32(y)]; ok {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,136 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestPathRequest_SlicedMatchesFindPath(t *testing.T) {
+	for _, file := range sceneFiles(t) {
+		env, f, start, goal := loadScene(t, file)
+		pl := NewPlanner(env, f)
+		want, wantOK := pl.FindPath(start, goal)
+
+		req := pl.NewRequest(start, goal, Restart)
+		steps := 0
+		for req.Step(64) == InProgress {
+			steps++
+			if got := req.Result().Expanded; got != 64*steps {
+				t.Fatalf("%s: step %d expanded %d", file, steps, got)
+			}
+		}
+		got := req.Result()
+		if (req.Status() == Found) != wantOK {
+			t.Fatalf("%s: status %v, FindPath ok=%v", file, req.Status(), wantOK)
+		}
+		if !wantOK {
+			continue
+		}
+		if got.Cost != want.Cost || got.Expanded != want.Expanded || len(got.Waypoints) != len(want.Waypoints) {
+			t.Fatalf("%s: sliced cost=%v expanded=%d len=%d, want cost=%v expanded=%d len=%d",
+				file, got.Cost, got.Expanded, len(got.Waypoints), want.Cost, want.Expanded, len(want.Waypoints))
+		}
+		if steps == 0 {
+			t.Fatalf("%s: expected several steps", file)
+		}
+	}
+}
+
+func TestPathRequest_MaxExpansions(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	pl := NewPlanner(env, testFilter())
+	pl.MaxExpansions = 30
+	req := pl.NewRequest(subPoint(4, 4, 10), subPoint(120, 120, 10), Restart)
+	if st := req.Step(20); st != InProgress {
+		t.Fatalf("first step: %v", st)
+	}
+	if st := req.Step(20); st != Failed {
+		t.Fatalf("second step: %v", st)
+	}
+	if got := req.Result().Expanded; got != 30 {
+		t.Fatalf("expanded %d, want 30", got)
+	}
+}
+
+func TestPathRequest_MapChange(t *testing.T) {
+	wall := func(x uint16) []zmap3base.Point3d {
+		var ops []zmap3base.Point3d
+		for y := uint16(0); y < 30; y++ {
+			ops = append(ops, zmap3base.Point3d{X: x, Y: y, H: 10, RangeEnd: 60})
+		}
+		return ops
+	}
+	acc := zmap3base.Accessory{Texture: testTexObs}
+	start, goal := subPoint(4, 8, 10), subPoint(120, 8, 10)
+
+	cases := []struct {
+		name     string
+		policy   ChangePolicy
+		wallX    uint16
+		restarts int
+		repairs  int
+	}{
+		{"restart-far", Restart, 26, 1, 0},
+		{"repair-far", Repair, 26, 0, 1},
+		{"repair-near", Repair, 3, 1, 0},
+	}
+	for _, tc := range cases {
+		env := buildSingleGridEnv(t, flatCells(10))
+		pl := NewPlanner(env, testFilter())
+		req := pl.NewRequest(start, goal, tc.policy)
+		if st := req.Step(40); st != InProgress {
+			t.Fatalf("%s: first step %v", tc.name, st)
+		}
+		if !env.ApplyRichOperationsExt(wall(tc.wallX), nil, acc) {
+			t.Fatalf("%s: ApplyRichOperationsExt failed", tc.name)
+		}
+		for req.Step(40) == InProgress {
+		}
+		if req.Status() != Found {
+			t.Fatalf("%s: status %v", tc.name, req.Status())
+		}
+		if req.Restarts() != tc.restarts || req.Repairs() != tc.repairs {
+			t.Fatalf("%s: restarts=%d repairs=%d, want %d/%d", tc.name, req.Restarts(), req.Repairs(), tc.restarts, tc.repairs)
+		}
+		want, ok := pl.FindPath(start, goal)
+		if !ok {
+			t.Fatalf("%s: FindPath failed after the edit", tc.name)
+		}
+		if got := req.Result(); !sameCost(got.Cost, want.Cost) {
+			t.Fatalf("%s: cost %v, fresh search %v", tc.name, got.Cost, want.Cost)
+		}
+		for _, w := range req.Result().Waypoints {
+			if w.X == tc.wallX && w.Y < 30 {
+				t.Fatalf("%s: path crosses the new wall at %+v", tc.name, w.Point3d)
+			}
+		}
+	}
+}
+
+func TestPathRequest_LinkChange(t *testing.T) {
+	start, goal := subPoint(4, 8, 10), subPoint(120, 8, 10)
+	for _, policy := range []ChangePolicy{Restart, Repair} {
+		env := buildSingleGridEnv(t, flatCells(10))
+		pl := NewPlanner(env, testFilter())
+		pl.Links = NewLinkRegistry()
+		req := pl.NewRequest(start, goal, policy)
+		if st := req.Step(40); st != InProgress {
+			t.Fatalf("policy %d: first step %v", policy, st)
+		}
+		// A teleport next to the start; the Env epoch does not move.
+		pl.Links.Add(Link{From: subPoint(8, 8, 10), To: subPoint(116, 8, 10), Type: LinkTeleport, Cost: 1})
+		for req.Step(40) == InProgress {
+		}
+		if req.Status() != Found || req.Restarts() != 1 || req.Repairs() != 0 {
+			t.Fatalf("policy %d: %v, restarts=%d repairs=%d", policy, req.Status(), req.Restarts(), req.Repairs())
+		}
+		want, _ := pl.FindPath(start, goal)
+		if got := req.Result(); !sameCost(got.Cost, want.Cost) || countLinks(got.Waypoints, LinkTeleport) != 1 {
+			t.Fatalf("policy %d: cost %v with %d links, fresh search %v", policy, got.Cost, countLinks(got.Waypoints, LinkTeleport), want.Cost)
+		}
+		if len(pl.Links.listeners) != 0 {
+			t.Fatalf("policy %d: finished request still listens to links", policy)
+		}
+	}
+}
@@ -0,0 +1,202 @@
+package navgation
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// Scene is a web_world_data export of the 3D visualizer: the map, the agent
+// settings of its UI and the start/goal markers.
+type Scene struct {
+	Env         *zmap3base.Env
+	Filter      Filter
+	Start, Goal zmap3base.Point3d
+}
+
+// sceneJSON is the visualizer file layout. Heights in the UI are meters,
+// columns hold [begin, end, texture] in 1/20m, start/goal x,z are sub-cells
+// and y is 1/20m.
+type sceneJSON struct {
+	Version int `json:"version"`
+	UI      struct {
+		MapW      int     `json:"mapW"`
+		MapH      int     `json:"mapH"`
+		AgentH    float64 `json:"agentH"`
+		StepUp    float64 `json:"stepUp"`
+		StepDown  float64 `json:"stepDown"`
+		BanTex    string  `json:"banTex"`
+		IgnoreTex string  `json:"ignoreTex"`
+	} `json:"ui"`
+	Map struct {
+		WidthM  int               `json:"widthM"`
+		HeightM int               `json:"heightM"`
+		Columns []sceneJSONColumn `json:"columns"`
+	} `json:"map"`
+	Start sceneJSONPoint `json:"start"`
+	Goal  sceneJSONPoint `json:"goal"`
+}
+
+type sceneJSONColumn struct {
+	MX      int          `json:"mx"`
+	MZ      int          `json:"mz"`
+	Terrain []uint32     `json:"terrain"`
+	Other   [][]uint32   `json:"other,omitempty"`
+	HP      [][][]uint32 `json:"hp,omitempty"`
+}
+
+type sceneJSONPoint struct {
+	X int32 `json:"x"`
+	Z int32 `json:"z"`
+	Y int32 `json:"y"`
+}
+
+// LoadScene reads a web_world_data scene. High-precision columns keep only
+// their "hp" lists, as in the visualizer.
+func LoadScene(r io.Reader) (*Scene, error) {
+	var sj sceneJSON
+	if err := json.NewDecoder(r).Decode(&sj); err != nil {
+		return nil, fmt.Errorf("navgation: parse scene: %w", err)
+	}
+	w, h := sj.Map.WidthM, sj.Map.HeightM
+	if w <= 0 || h <= 0 || w > math.MaxUint16 || h > math.MaxUint16 {
+		return nil, fmt.Errorf("navgation: scene size %dx%d", w, h)
+	}
+	cells := make(map[zmap3base.Point2d]CellData, len(sj.Map.Columns))
+	for _, c := range sj.Map.Columns {
+		if c.MX < 0 || c.MZ < 0 || c.MX >= w || c.MZ >= h {
+			return nil, fmt.Errorf("navgation: column (%d,%d) outside the scene", c.MX, c.MZ)
+		}
+		terrain, err := parseSceneRange(c.Terrain)
+		if err != nil {
+			return nil, err
+		}
+		cd := CellData{Terrain: terrain}
+		if len(c.HP) > 0 {
+			cd.HP = make([][]zmap3base.RichRange, zmap3base.SecondaryTileNum)
+			for sub, spans := range c.HP {
+				if sub >= zmap3base.SecondaryTileNum {
+					break
+				}
+				for _, v := range spans {
+					rr, err := parseSceneRange(v)
+					if err != nil {
+						return nil, err
+					}
+					cd.HP[sub] = append(cd.HP[sub], rr)
+				}
+			}
+		} else {
+			for _, v := range c.Other {
+				rr, err := parseSceneRange(v)
+				if err != nil {
+					return nil, err
+				}
+				cd.LP = append(cd.LP, rr)
+			}
+		}
+		cells[zmap3base.Point2d{X: uint16(c.MX), Y: uint16(c.MZ)}] = cd
+	}
+	env, err := NewEnvFromCells(zmap3base.Rect{Max: zmap3base.Point2d{X: uint16(w), Y: uint16(h)}},
+		func(lp zmap3base.Point2d) (CellData, bool) {
+			c, ok := cells[lp]
+			return c, ok
+		})
+	if err != nil {
+		return nil, err
+	}
+
+	ban, _ := strconv.ParseUint(sj.UI.BanTex, 10, 32)
+	ignore, _ := strconv.ParseUint(sj.UI.IgnoreTex, 10, 32)
+	sc := &Scene{
+		Env:    env,
+		Filter: NewFilter(uint32(ignore), uint32(ban), metersToHeight(sj.UI.AgentH), metersToHeight(sj.UI.StepUp), metersToHeight(sj.UI.StepDown)),
+		Start:  sj.Start.point(),
+		Goal:   sj.Goal.point(),
+	}
+	return sc, nil
+}
+
+// WriteScene writes env with f, start and goal as a web_world_data scene.
+// The Env is exported from its Min corner; high-precision cells merge their
+// LP spans into every sub-cell list.
+func WriteScene(w io.Writer, env *zmap3base.Env, f Filter, start, goal zmap3base.Point3d) error {
+	var sj sceneJSON
+	sj.Version = 1
+	r := env.Rect()
+	sj.Map.WidthM, sj.Map.HeightM = int(r.Width()), int(r.Height())
+	sj.UI.MapW, sj.UI.MapH = sj.Map.WidthM, sj.Map.HeightM
+	sj.UI.AgentH = heightToMeters(f.height)
+	sj.UI.StepUp = heightToMeters(f.upLimit)
+	sj.UI.StepDown = heightToMeters(f.downLimit)
+	sj.UI.BanTex = strconv.FormatUint(uint64(f.forbiddenTexture), 10)
+	sj.UI.IgnoreTex = strconv.FormatUint(uint64(f.ignoreTexture), 10)
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for x := r.Min.X; x < r.Max.X; x++ {
+			c, ok := ReadCell(env, zmap3base.Point2d{X: x, Y: y})
+			if !ok {
+				continue
+			}
+			col := sceneJSONColumn{MX: int(x - r.Min.X), MZ: int(y - r.Min.Y), Terrain: sceneTriple(c.Terrain)}
+			if c.HP != nil {
+				col.HP = make([][][]uint32, len(c.HP))
+				for sub, spans := range c.HP {
+					col.HP[sub] = [][]uint32{}
+					for _, rr := range append(append([]zmap3base.RichRange(nil), c.LP...), spans...) {
+						col.HP[sub] = append(col.HP[sub], sceneTriple(rr))
+					}
+				}
+			} else {
+				for _, rr := range c.LP {
+					col.Other = append(col.Other, sceneTriple(rr))
+				}
+			}
+			sj.Map.Columns = append(sj.Map.Columns, col)
+		}
+	}
+	sj.Start = scenePointOf(start, r)
+	sj.Goal = scenePointOf(goal, r)
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(&sj)
+}
+
+func parseSceneRange(v []uint32) (zmap3base.RichRange, error) {
+	if len(v) < 3 || v[0] > math.MaxUint16 || v[1] > math.MaxUint16 {
+		return zmap3base.RichRange{}, fmt.Errorf("navgation: bad scene range %v", v)
+	}
+	return zmap3base.RichRange{
+		Range:     zmap3base.Range{Begin: uint16(v[0]), End: uint16(v[1])},
+		Accessory: zmap3base.Accessory{Texture: zmap3base.Texture(v[2])},
+	}, nil
+}
+
+func sceneTriple(rr zmap3base.RichRange) []uint32 {
+	return []uint32{uint32(rr.Begin), uint32(rr.End), uint32(rr.Accessory.Texture)}
+}
+
+func (p sceneJSONPoint) point() zmap3base.Point3d {
+	h := min(max(p.Y, 0), math.MaxUint16)
+	return point3dAt(p.X, p.Z, zmap3base.Range{Begin: uint16(h)})
+}
+
+func scenePointOf(p zmap3base.Point3d, r zmap3base.Rect) sceneJSONPoint {
+	qx, qy := subCellOf(p.Point2d())
+	return sceneJSONPoint{
+		X: qx - int32(r.Min.X)*zmap3base.SecondaryAccuracy,
+		Z: qy - int32(r.Min.Y)*zmap3base.SecondaryAccuracy,
+		Y: int32(p.H),
+	}
+}
+
+func metersToHeight(m float64) int32 {
+	return int32(math.Min(m*zmap3base.HeightScale+0.5, math.MaxUint16))
+}
+
+func heightToMeters(h int32) float64 {
+	return float64(h) / zmap3base.HeightScale
+}
@@ -0,0 +1,485 @@
+package navgation
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	zmap3base "pathfinding/new_map"
+)
+
+var (
+	// ErrNoPath is returned for a query whose search failed.
+	ErrNoPath = errors.New("navgation: no path")
+	// ErrSchedulerClosed is returned for queries submitted to, or still
+	// queued in, a closed Scheduler.
+	ErrSchedulerClosed = errors.New("navgation: scheduler closed")
+)
+
+// Query is one path query for a Scheduler.
+type Query struct {
+	Start, Goal zmap3base.Point3d
+	// Filter is the agent profile; the other planner settings come from the
+	// Scheduler's base Planner.
+	Filter Filter
+	// Priority orders the queue, higher first. Equal priorities run in order
+	// of deadline, then of submission.
+	Priority int
+	// Deadline, if not zero, fails the query with context.DeadlineExceeded
+	// once passed, like a context deadline.
+	Deadline time.Time
+}
+
+// SchedulerStats is a snapshot of a Scheduler's counters.
+type SchedulerStats struct {
+	QueueDepth int // queries waiting for a worker
+	Running    int // queries being searched
+
+	Submitted int64
+	Coalesced int64 // submissions attached to an identical queued or running query
+	Found     int64
+	NoPath    int64
+	Canceled  int64 // tickets ended by Cancel or their context
+	Expired   int64 // tickets ended by their deadline
+	Restarts  int64 // searches restarted by map edits
+	Repairs   int64 // searches kept across map edits
+
+	// QueueWait is the time from submission to the start of the search and
+	// Latency the time from submission to the result, over finished searches.
+	QueueWait, Latency LatencyStats
+}
+
+// LatencyStats summarizes durations.
+type LatencyStats struct {
+	Count      int64
+	Total, Max time.Duration
+}
+
+// Mean returns the mean duration, 0 without samples.
+func (l LatencyStats) Mean() time.Duration {
+	if l.Count == 0 {
+		return 0
+	}
+	return l.Total / time.Duration(l.Count)
+}
+
+func (l *LatencyStats) add(d time.Duration) {
+	l.Count++
+	l.Total += d
+	if d > l.Max {
+		l.Max = d
+	}
+}
+
+// Scheduler runs path queries on a bounded pool of workers. Each worker
+// keeps its search buffers between queries. Searches run as PathRequests in
+// slices of SliceExpansions, so cancellation and deadlines are checked
+// between slices and map edits made through Edit take effect mid-search.
+//
+// The requests use the Repair policy: an edit out of reach of a search
+// keeps it, other edits restart it. So that a steady stream of edits cannot
+// starve a long query, a query restarted MaxRestarts times holds the map
+// until it ends; Edit, and with it the searches that start a slice after
+// Edit was called, wait for it meanwhile.
+//
+// The Env must only be edited through Edit while the Scheduler runs.
+// Queries whose filters share a FootprintLayer are searched one at a time,
+// since the layer is not safe for concurrent use. The base Planner's Tracer
+// is ignored.
+type Scheduler struct {
+	base *Planner
+	// SliceExpansions is the number of expansions between two cancellation
+	// checks; set it before the first Submit. 0 means 256.
+	SliceExpansions int
+	// MaxRestarts is the number of restarts after which a query finishes
+	// against the current map; set it before the first Submit. 0 means 4.
+	MaxRestarts int
+
+	mapMu sync.RWMutex // held for reading by searching workers, for writing by Edit
+
+	mu      sync.Mutex
+	cond    *sync.Cond
+	queue   jobQueue
+	pending map[jobKey]*job // queued and running jobs, for coalescing
+	seq     uint64
+	closed  bool
+	stats   SchedulerStats
+	layerMu map[*FootprintLayer]*sync.Mutex
+	wg      sync.WaitGroup
+
+	// listenMu serializes the Env and link listener (de)registrations of
+	// the requests, which run concurrently under mapMu.RLock.
+	listenMu sync.Mutex
+
+	afterSlice func() // called by the worker after each slice, for tests
+}
+
+// NewScheduler starts workers goroutines (at least 1) searching with the
+// settings of base.
+func NewScheduler(base *Planner, workers int) *Scheduler {
+	if workers < 1 {
+		workers = 1
+	}
+	s := &Scheduler{
+		base:    base,
+		pending: make(map[jobKey]*job),
+		layerMu: make(map[*FootprintLayer]*sync.Mutex),
+	}
+	s.cond = sync.NewCond(&s.mu)
+	s.wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go s.worker()
+	}
+	return s
+}
+
+// Ticket is the pending result of a submitted Query.
+type Ticket struct {
+	s         *Scheduler
+	job       *job
+	submitted time.Time
+	stop      []func() bool // deregisters the deadline timer and context callback
+
+	done chan struct{}
+	res  PathResult
+	err  error
+}
+
+// Done is closed once the result is available.
+func (t *Ticket) Done() <-chan struct{} { return t.done }
+
+// Result returns the path, ErrNoPath, the context error or
+// ErrSchedulerClosed. It blocks until Done is closed.
+func (t *Ticket) Result() (PathResult, error) {
+	<-t.done
+	return t.res, t.err
+}
+
+// Cancel drops the ticket with context.Canceled. The search stops once no
+// ticket waits for it.
+func (t *Ticket) Cancel() {
+	t.s.detach(t, context.Canceled)
+}
+
+type jobKey struct {
+	start, goal zmap3base.Point3d
+	filter      Filter
+}
+
+type job struct {
+	key      jobKey
+	q        Query
+	seq      uint64
+	heapIdx  int // -1 once taken by a worker
+	tickets  []*Ticket
+	canceled bool // no ticket left; the worker stops at the next slice
+}
+
+// Submit queues q. The ticket fails with ctx.Err() when ctx ends, or with
+// context.DeadlineExceeded at q.Deadline. A query identical to a queued or
+// running one (same endpoints and filter) shares its search; the shared
+// search takes the highest priority and earliest deadline of its tickets.
+func (s *Scheduler) Submit(ctx context.Context, q Query) *Ticket {
+	t := &Ticket{s: s, submitted: time.Now(), done: make(chan struct{})}
+	s.mu.Lock()
+	s.stats.Submitted++
+	if s.closed {
+		s.mu.Unlock()
+		t.finish(PathResult{}, ErrSchedulerClosed)
+		return t
+	}
+	if err := ctx.Err(); err != nil {
+		s.stats.Canceled++
+		s.mu.Unlock()
+		t.finish(PathResult{}, err)
+		return t
+	}
+	key := jobKey{start: q.Start, goal: q.Goal, filter: q.Filter}
+	j := s.pending[key]
+	if j != nil && !j.canceled {
+		s.stats.Coalesced++
+		if q.Priority > j.q.Priority {
+			j.q.Priority = q.Priority
+		}
+		if !q.Deadline.IsZero() && (j.q.Deadline.IsZero() || q.Deadline.Before(j.q.Deadline)) {
+			j.q.Deadline = q.Deadline
+		}
+		if j.heapIdx >= 0 {
+			heap.Fix(&s.queue, j.heapIdx)
+		}
+	} else {
+		s.seq++
+		j = &job{key: key, q: q, seq: s.seq}
+		s.pending[key] = j
+		heap.Push(&s.queue, j)
+		s.cond.Signal()
+	}
+	t.job = j
+	j.tickets = append(j.tickets, t)
+	// The callbacks run in their own goroutines and take s.mu, so they see
+	// the ticket fully set up.
+	if !q.Deadline.IsZero() {
+		timer := time.AfterFunc(time.Until(q.Deadline), func() { s.detach(t, context.DeadlineExceeded) })
+		t.stop = append(t.stop, timer.Stop)
+	}
+	t.stop = append(t.stop, context.AfterFunc(ctx, func() { s.detach(t, ctx.Err()) }))
+	s.mu.Unlock()
+	return t
+}
+
+// Find submits q and waits for the result.
+func (s *Scheduler) Find(ctx context.Context, q Query) (PathResult, error) {
+	return s.Submit(ctx, q).Result()
+}
+
+// Edit runs fn with every search paused; use it for all Env edits while the
+// Scheduler runs. Searches in progress are repaired or restarted against
+// the edited map; Edit waits for the queries that hit MaxRestarts.
+func (s *Scheduler) Edit(fn func()) {
+	s.mapMu.Lock()
+	defer s.mapMu.Unlock()
+	fn()
+}
+
+// Stats returns a snapshot of the counters.
+func (s *Scheduler) Stats() SchedulerStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	st := s.stats
+	st.QueueDepth = s.queue.Len()
+	return st
+}
+
+// Close stops the workers after their current slice, fails the queued and
+// running queries with ErrSchedulerClosed and waits for the workers to exit.
+func (s *Scheduler) Close() {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return
+	}
+	s.closed = true
+	for s.queue.Len() > 0 {
+		j := heap.Pop(&s.queue).(*job)
+		s.resolveLocked(j, PathResult{}, ErrSchedulerClosed)
+	}
+	for _, j := range s.pending {
+		j.canceled = true
+	}
+	s.cond.Broadcast()
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// detach ends ticket t with err unless it already has a result, and drops
+// its job once no ticket waits for it.
+func (s *Scheduler) detach(t *Ticket, err error) {
+	s.mu.Lock()
+	j := t.job
+	if j == nil {
+		s.mu.Unlock()
+		return
+	}
+	for i, o := range j.tickets {
+		if o == t {
+			j.tickets = append(j.tickets[:i], j.tickets[i+1:]...)
+			break
+		}
+	}
+	t.job = nil
+	if err == context.DeadlineExceeded {
+		s.stats.Expired++
+	} else {
+		s.stats.Canceled++
+	}
+	if len(j.tickets) == 0 {
+		j.canceled = true
+		if s.pending[j.key] == j {
+			delete(s.pending, j.key)
+		}
+		if j.heapIdx >= 0 {
+			heap.Remove(&s.queue, j.heapIdx)
+		}
+	}
+	t.finish(PathResult{}, err)
+	s.mu.Unlock()
+}
+
+// resolveLocked hands the outcome of j to its tickets. s.mu must be held.
+func (s *Scheduler) resolveLocked(j *job, res PathResult, err error) {
+	if s.pending[j.key] == j {
+		delete(s.pending, j.key)
+	}
+	now := time.Now()
+	for _, t := range j.tickets {
+		t.job = nil
+		s.stats.Latency.add(now.Sub(t.submitted))
+		t.finish(res, err)
+	}
+	j.tickets = nil
+}
+
+// finish delivers the outcome. It is called once, under s.mu for published
+// tickets.
+func (t *Ticket) finish(res PathResult, err error) {
+	for _, stop := range t.stop {
+		stop()
+	}
+	t.res, t.err = res, err
+	close(t.done)
+}
+
+func (s *Scheduler) worker() {
+	defer s.wg.Done()
+	var sc searchScratch
+	for {
+		s.mu.Lock()
+		for s.queue.Len() == 0 && !s.closed {
+			s.cond.Wait()
+		}
+		if s.closed {
+			s.mu.Unlock()
+			return
+		}
+		j := heap.Pop(&s.queue).(*job)
+		now := time.Now()
+		for _, t := range j.tickets {
+			s.stats.QueueWait.add(now.Sub(t.submitted))
+		}
+		s.stats.Running++
+		s.mu.Unlock()
+
+		res, st, restarts, repairs := s.search(j, &sc)
+
+		s.mu.Lock()
+		s.stats.Running--
+		s.stats.Restarts += int64(restarts)
+		s.stats.Repairs += int64(repairs)
+		switch {
+		case st == InProgress && s.closed:
+			s.resolveLocked(j, PathResult{}, ErrSchedulerClosed)
+		case st == InProgress:
+			// Every ticket left; nothing to deliver.
+			if s.pending[j.key] == j {
+				delete(s.pending, j.key)
+			}
+		case st == Found:
+			s.stats.Found++
+			s.resolveLocked(j, res, nil)
+		default:
+			s.stats.NoPath++
+			s.resolveLocked(j, res, ErrNoPath)
+		}
+		s.mu.Unlock()
+	}
+}
+
+// search runs j in slices until it ends or no ticket waits for it; the
+// status is InProgress in the latter case. It also returns the restart and
+// repair counts of the request.
+func (s *Scheduler) search(j *job, sc *searchScratch) (PathResult, StepStatus, int, int) {
+	pl := *s.base
+	pl.Filter = j.q.Filter
+	pl.Tracer = nil
+	req := pl.NewRequest(j.q.Start, j.q.Goal, Repair)
+	req.scratch = sc
+	req.listenMu = &s.listenMu
+
+	var layerMu *sync.Mutex
+	if l := j.q.Filter.layer; l != nil {
+		s.mu.Lock()
+		if layerMu = s.layerMu[l]; layerMu == nil {
+			layerMu = new(sync.Mutex)
+			s.layerMu[l] = layerMu
+		}
+		s.mu.Unlock()
+	}
+	slice := s.SliceExpansions
+	if slice <= 0 {
+		slice = 256
+	}
+	maxRestarts := s.MaxRestarts
+	if maxRestarts <= 0 {
+		maxRestarts = 4
+	}
+
+	st := InProgress
+	pinned := false // holding mapMu until the end, see MaxRestarts
+	for st == InProgress {
+		s.mu.Lock()
+		canceled := j.canceled
+		s.mu.Unlock()
+		if canceled {
+			break
+		}
+		if layerMu != nil {
+			layerMu.Lock()
+		}
+		if !pinned {
+			s.mapMu.RLock()
+		}
+		st = req.Step(slice)
+		if pinned = st == InProgress && req.Restarts() >= maxRestarts; !pinned {
+			s.mapMu.RUnlock()
+		}
+		if layerMu != nil {
+			layerMu.Unlock()
+		}
+		if s.afterSlice != nil {
+			s.afterSlice()
+		}
+	}
+	if st == InProgress {
+		// Hand the buffers back for the next query and stop listening.
+		sc.reclaim(req.s)
+		req.s = nil
+		if !pinned {
+			s.mapMu.RLock()
+		}
+		req.Close()
+		pinned = true
+	}
+	if pinned {
+		s.mapMu.RUnlock()
+	}
+	return req.Result(), st, req.Restarts(), req.Repairs()
+}
+
+// jobQueue orders jobs by priority, then deadline, then submission.
+type jobQueue []*job
+
+func (q jobQueue) Len() int { return len(q) }
+func (q jobQueue) Less(i, j int) bool {
+	a, b := q[i], q[j]
+	if a.q.Priority != b.q.Priority {
+		return a.q.Priority > b.q.Priority
+	}
+	if !a.q.Deadline.Equal(b.q.Deadline) {
+		if a.q.Deadline.IsZero() || b.q.Deadline.IsZero() {
+			return b.q.Deadline.IsZero()
+		}
+		return a.q.Deadline.Before(b.q.Deadline)
+	}
+	return a.seq < b.seq
+}
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+	q[i].heapIdx = i
+	q[j].heapIdx = j
+}
+func (q *jobQueue) Push(x any) {
+	j := x.(*job)
+	j.heapIdx = len(*q)
+	*q = append(*q, j)
+}
+func (q *jobQueue) Pop() any {
+	old := *q
+	n := len(old)
+	j := old[n-1]
+	old[n-1] = nil
+	j.heapIdx = -1
+	*q = old[:n-1]
+	return j
+}
@@ -0,0 +1,230 @@
+package navgation
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestScheduler_MatchesFindPath(t *testing.T) {
+	for _, file := range sceneFiles(t) {
+		env, f, start, goal := loadScene(t, file)
+		pl := NewPlanner(env, f)
+		want, wantOK := pl.FindPath(start, goal)
+
+		s := NewScheduler(pl, 4)
+		s.SliceExpansions = 50
+		var wg sync.WaitGroup
+		for i := 0; i < 16; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				q := Query{Start: start, Goal: goal, Filter: f, Priority: i % 3}
+				res, err := s.Find(context.Background(), q)
+				if !wantOK {
+					if !errors.Is(err, ErrNoPath) {
+						t.Errorf("%s: err=%v, want ErrNoPath", file, err)
+					}
+					return
+				}
+				if err != nil || !sameCost(res.Cost, want.Cost) {
+					t.Errorf("%s: query %d cost=%v err=%v, want %v", file, i, res.Cost, err, want.Cost)
+				}
+			}(i)
+		}
+		wg.Wait()
+		st := s.Stats()
+		s.Close()
+		if st.Submitted != 16 || st.Found+st.NoPath+st.Coalesced < 16 || st.QueueDepth != 0 || st.Running != 0 {
+			t.Fatalf("%s: stats %+v", file, st)
+		}
+	}
+}
+
+// blockedScheduler returns a one-worker scheduler whose worker is stuck on a
+// first query until release is called.
+func blockedScheduler(t *testing.T) (s *Scheduler, f Filter, release func()) {
+	t.Helper()
+	env := buildSingleGridEnv(t, flatCells(10))
+	f = testFilter()
+	s = NewScheduler(NewPlanner(env, f), 1)
+	unblock := make(chan struct{})
+	editing := make(chan struct{})
+	go s.Edit(func() {
+		close(editing)
+		<-unblock
+	})
+	<-editing
+	s.Submit(context.Background(), Query{Start: subPoint(0, 0, 10), Goal: subPoint(100, 100, 10), Filter: f})
+	for s.Stats().Running != 1 {
+		time.Sleep(time.Millisecond)
+	}
+	return s, f, func() { close(unblock) }
+}
+
+func TestScheduler_PriorityAndCoalescing(t *testing.T) {
+	s, f, release := blockedScheduler(t)
+	defer s.Close()
+
+	low := s.Submit(context.Background(), Query{Start: subPoint(4, 4, 10), Goal: subPoint(60, 60, 10), Filter: f, Priority: 1})
+	high := s.Submit(context.Background(), Query{Start: subPoint(8, 4, 10), Goal: subPoint(60, 90, 10), Filter: f, Priority: 5})
+	dup := s.Submit(context.Background(), Query{Start: subPoint(4, 4, 10), Goal: subPoint(60, 60, 10), Filter: f, Priority: 9})
+	if st := s.Stats(); st.QueueDepth != 2 || st.Coalesced != 1 {
+		t.Fatalf("stats before release: %+v", st)
+	}
+	release()
+
+	// The duplicate raised the shared query above high.
+	<-high.Done()
+	select {
+	case <-low.Done():
+	default:
+		t.Fatalf("coalesced query should have run before the high priority one")
+	}
+	a, errA := low.Result()
+	b, errB := dup.Result()
+	if errA != nil || errB != nil || a.Cost != b.Cost || len(a.Waypoints) == 0 {
+		t.Fatalf("coalesced results differ: %v/%v %v/%v", a.Cost, errA, b.Cost, errB)
+	}
+	if st := s.Stats(); st.Found != 3 || st.Latency.Count != 4 || st.QueueWait.Mean() <= 0 {
+		t.Fatalf("stats after release: %+v", st)
+	}
+}
+
+func TestScheduler_CancelAndDeadline(t *testing.T) {
+	s, f, release := blockedScheduler(t)
+	defer s.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	q := Query{Start: subPoint(4, 4, 10), Goal: subPoint(60, 60, 10), Filter: f}
+	canceled := s.Submit(ctx, q)
+	shared := s.Submit(context.Background(), q)
+	expiring := s.Submit(context.Background(), Query{Start: subPoint(8, 8, 10), Goal: subPoint(90, 60, 10), Filter: f, Deadline: time.Now().Add(20 * time.Millisecond)})
+	dropped := s.Submit(context.Background(), Query{Start: subPoint(12, 8, 10), Goal: subPoint(90, 90, 10), Filter: f})
+
+	cancel()
+	if _, err := canceled.Result(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled ticket: %v", err)
+	}
+	if _, err := expiring.Result(); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expiring ticket: %v", err)
+	}
+	dropped.Cancel()
+	if _, err := dropped.Result(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("dropped ticket: %v", err)
+	}
+	if st := s.Stats(); st.QueueDepth != 1 || st.Canceled != 2 || st.Expired != 1 {
+		t.Fatalf("stats: %+v", st)
+	}
+	release()
+	if _, err := shared.Result(); err != nil {
+		t.Fatalf("the other ticket of a canceled one should still get a path: %v", err)
+	}
+}
+
+// gateSlices makes the worker of s wait after each of its first n slices
+// until the returned resume is called; paused receives once per wait.
+func gateSlices(s *Scheduler, n int) (paused <-chan struct{}, resume func()) {
+	p, r := make(chan struct{}), make(chan struct{})
+	slices := 0
+	s.afterSlice = func() {
+		if slices++; slices <= n {
+			p <- struct{}{}
+			<-r
+		}
+	}
+	return p, func() { r <- struct{}{} }
+}
+
+// wallAt returns the ops of a wall along LP column x over y < 30.
+func wallAt(x uint16) []zmap3base.Point3d {
+	var ops []zmap3base.Point3d
+	for y := uint16(0); y < 30; y++ {
+		ops = append(ops, zmap3base.Point3d{X: x, Y: y, H: 10, RangeEnd: 60})
+	}
+	return ops
+}
+
+func TestScheduler_EditRestartsSearch(t *testing.T) {
+	start, goal := subPoint(4, 8, 10), subPoint(120, 8, 10)
+	acc := zmap3base.Accessory{Texture: testTexObs}
+	for _, tc := range []struct {
+		wallX             uint16
+		restarts, repairs int64
+	}{
+		{3, 1, 0},  // within reach of the first slice: restarts
+		{26, 0, 1}, // out of reach of the first slice: repaired
+	} {
+		env := buildSingleGridEnv(t, flatCells(10))
+		f := testFilter()
+		s := NewScheduler(NewPlanner(env, f), 1)
+		s.SliceExpansions = 20
+		paused, resume := gateSlices(s, 1)
+
+		tk := s.Submit(context.Background(), Query{Start: start, Goal: goal, Filter: f})
+		<-paused
+		s.Edit(func() { env.ApplyRichOperationsExt(wallAt(tc.wallX), nil, acc) })
+		resume()
+		res, err := tk.Result()
+		st := s.Stats()
+		s.Close()
+		if err != nil {
+			t.Fatalf("wall at %d: %v", tc.wallX, err)
+		}
+		if st.Restarts != tc.restarts || st.Repairs != tc.repairs {
+			t.Fatalf("wall at %d: restarts=%d repairs=%d, want %d/%d", tc.wallX, st.Restarts, st.Repairs, tc.restarts, tc.repairs)
+		}
+		want, _ := NewPlanner(env, f).FindPath(start, goal)
+		if !sameCost(res.Cost, want.Cost) {
+			t.Fatalf("wall at %d: cost %v, fresh search %v", tc.wallX, res.Cost, want.Cost)
+		}
+	}
+}
+
+func TestScheduler_MaxRestarts(t *testing.T) {
+	env := buildSingleGridEnv(t, flatCells(10))
+	f := testFilter()
+	s := NewScheduler(NewPlanner(env, f), 1)
+	defer s.Close()
+	s.SliceExpansions = 20
+	s.MaxRestarts = 1
+	paused, resume := gateSlices(s, 2)
+	acc := zmap3base.Accessory{Texture: testTexObs}
+
+	start, goal := subPoint(4, 8, 10), subPoint(120, 8, 10)
+	tk := s.Submit(context.Background(), Query{Start: start, Goal: goal, Filter: f})
+	<-paused
+	s.Edit(func() { env.ApplyRichOperationsExt(wallAt(3), nil, acc) })
+	resume()
+
+	// The second slice restarted the search and hit the limit, so a new
+	// edit waits for the query to end.
+	<-paused
+	want, _ := NewPlanner(env, f).FindPath(start, goal)
+	ended := make(chan bool, 1)
+	go s.Edit(func() {
+		// A search still running would wait for this edit to end.
+		select {
+		case <-tk.Done():
+			ended <- true
+		case <-time.After(time.Second):
+			ended <- false
+		}
+		env.ApplyRichOperationsExt(wallAt(6), nil, acc)
+	})
+	resume()
+	if !<-ended {
+		t.Fatalf("the edit ran while the query was in progress")
+	}
+	res, err := tk.Result()
+	if err != nil || !sameCost(res.Cost, want.Cost) {
+		t.Fatalf("cost %v err %v, before the second edit %v", res.Cost, err, want.Cost)
+	}
+	if st := s.Stats(); st.Restarts != 1 {
+		t.Fatalf("restarts %d, want 1", st.Restarts)
+	}
+}
@@ -0,0 +1,62 @@
+package navgation
+
+import (
+	zmap3base "pathfinding/new_map"
+)
+
+// World returns the world position of the default 2x2 footprint centre on
+// its floor.
+func (w Waypoint) World() [3]float32 {
+	return w.WorldFor(Filter{})
+}
+
+// WorldFor is World for the footprint of f.
+func (w Waypoint) WorldFor(f Filter) [3]float32 {
+	qx, qy := subCellOf(w.Point2d())
+	x, z := footprintCenter(qx, qy, f.footprint())
+	return [3]float32{x, heightToWorld(w.H), z}
+}
+
+// SmoothPath removes waypoints that a walkable straight line can skip.
+// Waypoints at height transitions (stairs, drops) are always kept with their
+// floor heights, as are both ends of off-mesh links and locomotion changes;
+// only runs on one floor are pulled, and every shortcut is re-checked with
+// WalkableLine.
+func SmoothPath(env *zmap3base.Env, path []Waypoint, f Filter) []Waypoint {
+	n := len(path)
+	if n <= 2 {
+		return append([]Waypoint(nil), path...)
+	}
+	out := make([]Waypoint, 0, n)
+	out = append(out, path[0])
+
+	anchor := 0
+	for i := 1; i < n-1; i++ {
+		if path[i].H != path[i-1].H || path[i].H != path[i+1].H || path[i].Link != LinkNone || path[i+1].Link != LinkNone ||
+			path[i].Mode != path[i-1].Mode || path[i].Mode != path[i+1].Mode {
+			out = append(out, path[i])
+			anchor = i
+			continue
+		}
+		if _, ok := WalkableLine(env, path[anchor].Point3d, path[i+1].Point3d, f); !ok {
+			out = append(out, path[i])
+			anchor = i
+		}
+	}
+	return append(out, path[n-1])
+}
+
+// WorldPath converts a path found with filter f to world positions, at the
+// centre of f's footprint. start and goal replace the first and last waypoint
+// so callers keep their original float endpoints.
+func WorldPath(path []Waypoint, f Filter, start, goal [3]float32) [][3]float32 {
+	if len(path) == 0 {
+		return nil
+	}
+	out := make([][3]float32, 0, len(path)+1)
+	out = append(out, start)
+	for i := 1; i < len(path)-1; i++ {
+		out = append(out, path[i].WorldFor(f))
+	}
+	return append(out, goal)
+}
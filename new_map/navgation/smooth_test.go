@@ -0,0 +1,68 @@
+package navgation
+
+import (
+	"testing"
+)
+
+func TestSmoothPath(t *testing.T) {
+	cells := flatCells(10)
+	for y := 0; y < 20; y++ {
+		cells[cellIndex(8, y)] = cellFixture{terrain: rr(0, 60, testTexBase)}
+	}
+	// Stairs: three 0.4m steps along x at y=26..27.
+	for i, h := range []uint16{18, 26, 34} {
+		for y := 26; y < 28; y++ {
+			cells[cellIndex(20+i, y)] = cellFixture{terrain: rr(0, h, testTexBase)}
+		}
+	}
+	env := buildSingleGridEnv(t, cells)
+	f := testFilter()
+	pl := NewPlanner(env, f)
+
+	res, ok := pl.FindPath(subPoint(8, 8, 10), subPoint(60, 8, 10))
+	if !ok {
+		t.Fatalf("expected a path")
+	}
+	smooth := SmoothPath(env, res.Waypoints, f)
+	if len(smooth) >= len(res.Waypoints)/4 {
+		t.Fatalf("expected far fewer waypoints: raw=%d smooth=%d", len(res.Waypoints), len(smooth))
+	}
+	if smooth[0] != res.Waypoints[0] || smooth[len(smooth)-1] != res.Waypoints[len(res.Waypoints)-1] {
+		t.Fatalf("endpoints changed")
+	}
+	for i := 1; i < len(smooth); i++ {
+		if _, ok := WalkableLine(env, smooth[i-1].Point3d, smooth[i].Point3d, f); !ok {
+			t.Fatalf("segment %d not walkable: %+v -> %+v", i, smooth[i-1], smooth[i])
+		}
+	}
+
+	res, ok = pl.FindPath(subPoint(72, 105, 10), subPoint(100, 105, 34))
+	if !ok {
+		t.Fatalf("expected a path up the stairs")
+	}
+	smooth = SmoothPath(env, res.Waypoints, f)
+	heights := map[uint16]bool{}
+	for _, w := range smooth {
+		heights[w.H] = true
+	}
+	for _, h := range []uint16{10, 18, 26, 34} {
+		if !heights[h] {
+			t.Fatalf("height %d lost by smoothing: %+v", h, smooth)
+		}
+	}
+
+	start, goal := [3]float32{18.13, 0.5, 26.31}, [3]float32{25.4, 1.7, 26.4}
+	world := WorldPath(smooth, f, start, goal)
+	if world[0] != start || world[len(world)-1] != goal || len(world) != len(smooth) {
+		t.Fatalf("unexpected world path: %v", world)
+	}
+	if mid := world[1]; mid[1] != heightToWorld(smooth[1].H) {
+		t.Fatalf("waypoint height not preserved: %v", mid)
+	}
+	// Larger footprints put waypoints at their own centre.
+	wide := f.WithFootprint(6)
+	world = WorldPath(smooth, wide, start, goal)
+	if mid := world[1]; mid != smooth[1].WorldFor(wide) || mid == smooth[1].World() {
+		t.Fatalf("6x6 waypoint %v, want %v", mid, smooth[1].WorldFor(wide))
+	}
+}
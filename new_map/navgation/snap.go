@@ -0,0 +1,94 @@
+package navgation
+
+import (
+	zmap3base "pathfinding/new_map"
+)
+
+// SnapRings is how many rings of neighbouring sub-cells SnapToGround searches
+// when the sub-column under the position has no gap inside the window.
+const SnapRings = 8
+
+// SnapToGround converts a float world position (x, z horizontal, y vertical in
+// meters) to the nearest standing gap. maxUp/maxDown bound the vertical search
+// window in 1/20m. It returns the standing point and the floor texture.
+func SnapToGround(env *zmap3base.Env, x, y, z float32, maxUp, maxDown int32) (p zmap3base.Point3d, tex zmap3base.Texture, ok bool) {
+	return SnapToGroundFilter(env, x, y, z, maxUp, maxDown, Filter{})
+}
+
+// SnapToGroundFilter is SnapToGround that only accepts gaps passing f.
+// The up/down limits of f are not used, maxUp/maxDown are.
+func SnapToGroundFilter(env *zmap3base.Env, x, y, z float32, maxUp, maxDown int32, f Filter) (p zmap3base.Point3d, tex zmap3base.Texture, ok bool) {
+	if env == nil || x < 0 || z < 0 {
+		return
+	}
+	qx, qy := subCellOf(zmap3base.WrapHighPrecisionPoint2d(x, z))
+	curY := worldToHeight(y)
+
+	if g, found := nearestGapInColumn(env, qx, qy, curY, maxUp, maxDown, f); found {
+		return point3dAt(qx, qy, g.Range), g.Accessory.Texture, true
+	}
+
+	for r := int32(1); r <= SnapRings; r++ {
+		var (
+			best      Gap
+			bestQX    int32
+			bestQY    int32
+			bestScore int64
+			found     bool
+		)
+		forEachRingCell(qx, qy, r, func(nx, ny int32) {
+			g, ok := nearestGapInColumn(env, nx, ny, curY, maxUp, maxDown, f)
+			if !ok {
+				return
+			}
+			dx, dy := int64(nx-qx), int64(ny-qy)
+			dh := int64(abs32(int32(g.Begin) - curY))
+			// Vertical distance dominates, horizontal breaks ties inside a ring.
+			score := dh<<16 + dx*dx + dy*dy
+			if !found || score < bestScore {
+				best, bestQX, bestQY, bestScore, found = g, nx, ny, score, true
+			}
+		})
+		if found {
+			return point3dAt(bestQX, bestQY, best.Range), best.Accessory.Texture, true
+		}
+	}
+	return
+}
+
+// nearestGapInColumn picks the gap whose floor is closest to curY inside
+// [curY-maxDown, curY+maxUp]. On a tie the lower floor wins.
+func nearestGapInColumn(env *zmap3base.Env, qx, qy, curY, maxUp, maxDown int32, f Filter) (best Gap, found bool) {
+	p2d, ok := subCellPoint2d(qx, qy)
+	if !ok {
+		return Gap{}, false
+	}
+	bestDist := int32(0)
+	ForEachInterval(env, p2d, f, func(g Gap) bool {
+		floor := int32(g.Begin)
+		if floor > curY+maxUp {
+			return false
+		}
+		if floor < curY-maxDown {
+			return true
+		}
+		d := abs32(floor - curY)
+		if !found || d < bestDist {
+			best, bestDist, found = g, d, true
+		}
+		return true
+	})
+	return best, found
+}
+
+// forEachRingCell visits the sub-cells at Chebyshev distance r from (qx, qy).
+func forEachRingCell(qx, qy, r int32, visit func(x, y int32)) {
+	for dx := -r; dx <= r; dx++ {
+		visit(qx+dx, qy-r)
+		visit(qx+dx, qy+r)
+	}
+	for dy := -r + 1; dy <= r-1; dy++ {
+		visit(qx-r, qy+dy)
+		visit(qx+r, qy+dy)
+	}
+}
@@ -0,0 +1,57 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestSnapToGround(t *testing.T) {
+	cellIdx := 2 + 2*zmap3base.FastGridSetSize
+	env := buildSingleGridEnv(t, map[int]cellFixture{
+		cellIdx: {
+			terrain:   rr(0, 10, testTexBase),
+			lpPayload: []zmap3base.RichRange{rr(80, 90, testTexObs)},
+			hpPayload: map[int][]zmap3base.RichRange{
+				0: {rr(10, 60, testTexCol)},
+			},
+		},
+	})
+
+	t.Run("hovering-above-floor", func(t *testing.T) {
+		p, tex, ok := SnapToGround(env, 2.6, 0.8, 2.6, 20, 20)
+		if !ok {
+			t.Fatalf("expected snap")
+		}
+		if p.X != 2 || p.Y != 2 || p.XOffset != 3 || p.YOffset != 3 || p.H != 10 || p.RangeEnd != 80 || tex != testTexBase {
+			t.Fatalf("unexpected snap: %+v tex=%v", p, tex)
+		}
+	})
+
+	t.Run("nearest-floor-upstairs", func(t *testing.T) {
+		p, _, ok := SnapToGround(env, 2.6, 4.4, 2.6, 20, 20)
+		if !ok || p.H != 90 {
+			t.Fatalf("unexpected snap: %+v ok=%v", p, ok)
+		}
+	})
+
+	t.Run("inside-blocker-searches-ring", func(t *testing.T) {
+		// (2.1, 2.1) is sub (1,1), filled by the collider up to 60.
+		p, tex, ok := SnapToGround(env, 2.1, 0.6, 2.1, 20, 20)
+		if !ok {
+			t.Fatalf("expected snap from ring search")
+		}
+		if p.H != 10 || tex != testTexBase || (p.XOffset == 1 && p.YOffset == 1) {
+			t.Fatalf("unexpected snap: %+v tex=%v", p, tex)
+		}
+		if p.XOffset > 2 || p.YOffset > 2 {
+			t.Fatalf("expected first ring, got %+v", p)
+		}
+	})
+
+	t.Run("outside-env", func(t *testing.T) {
+		if _, _, ok := SnapToGround(env, 500, 0, 500, 20, 20); ok {
+			t.Fatalf("expected failure outside env")
+		}
+	})
+}
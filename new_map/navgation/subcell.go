@@ -0,0 +1,98 @@
+package navgation
+
+import (
+	"math"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// Sub-cell coordinates address 0.25m columns in quarter units:
+// q = X*SecondaryAccuracy + (Offset-1). LP points map to their (1,1) sub-cell,
+// which is the same column GetInterval uses for an LP query.
+
+const maxSubCoord = int32(math.MaxUint16)*zmap3base.SecondaryAccuracy + zmap3base.SecondaryAccuracy - 1
+
+func subCellOf(p zmap3base.Point2d) (qx, qy int32) {
+	xo, yo := int32(p.XOffset), int32(p.YOffset)
+	if xo == 0 {
+		xo = 1
+	}
+	if yo == 0 {
+		yo = 1
+	}
+	return int32(p.X)*zmap3base.SecondaryAccuracy + xo - 1, int32(p.Y)*zmap3base.SecondaryAccuracy + yo - 1
+}
+
+func subCellPoint2d(qx, qy int32) (zmap3base.Point2d, bool) {
+	if qx < 0 || qy < 0 || qx > maxSubCoord || qy > maxSubCoord {
+		return zmap3base.Point2d{}, false
+	}
+	return zmap3base.Point2d{
+		X:       uint16(qx / zmap3base.SecondaryAccuracy),
+		Y:       uint16(qy / zmap3base.SecondaryAccuracy),
+		XOffset: uint8(qx%zmap3base.SecondaryAccuracy) + 1,
+		YOffset: uint8(qy%zmap3base.SecondaryAccuracy) + 1,
+	}, true
+}
+
+// SubCellOf returns the sub-cell coordinates of p.
+func SubCellOf(p zmap3base.Point2d) (qx, qy int32) { return subCellOf(p) }
+
+// SubCellPoint returns the point of sub-cell (qx, qy) at height h. ok is false
+// for coordinates no Point2d can hold.
+func SubCellPoint(qx, qy int32, h uint16) (p zmap3base.Point3d, ok bool) {
+	if _, ok := subCellPoint2d(qx, qy); !ok {
+		return p, false
+	}
+	return point3dAt(qx, qy, zmap3base.Range{Begin: h}), true
+}
+
+// subCellCenter returns the world x/z of the sub-cell centre.
+func subCellCenter(qx, qy int32) (x, z float32) {
+	return (float32(qx) + 0.5) * zmap3base.SecondaryTileLen, (float32(qy) + 0.5) * zmap3base.SecondaryTileLen
+}
+
+func point3dAt(qx, qy int32, g zmap3base.Range) zmap3base.Point3d {
+	p, _ := subCellPoint2d(qx, qy)
+	return zmap3base.Point3d{X: p.X, Y: p.Y, XOffset: p.XOffset, YOffset: p.YOffset, H: g.Begin, RangeEnd: g.End}
+}
+
+func heightToWorld(h uint16) float32 {
+	return float32(h) / zmap3base.HeightScale
+}
+
+func worldToHeight(y float32) int32 {
+	if y <= 0 {
+		return 0
+	}
+	h := int32(y*zmap3base.HeightScale + 0.5)
+	if h > math.MaxUint16 {
+		h = math.MaxUint16
+	}
+	return h
+}
+
+func abs32(v int32) int32 {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
+
+// gridSideSub is the edge of one GridRBData in sub-cells.
+const gridSideSub = zmap3base.FastGridSetSize * zmap3base.SecondaryAccuracy
+
+// gridOrigin returns the first sub-cell of grid (gx, gy).
+func gridOrigin(env *zmap3base.Env, gx, gy int) (qx, qy int32) {
+	return (int32(env.MinX()) + int32(gx*zmap3base.FastGridSetSize)) * zmap3base.SecondaryAccuracy,
+		(int32(env.MinY()) + int32(gy*zmap3base.FastGridSetSize)) * zmap3base.SecondaryAccuracy
+}
+
+// gridOfSubCell returns the grid holding sub-cell (qx, qy).
+func gridOfSubCell(env *zmap3base.Env, qx, qy int32) (gx, gy int, ok bool) {
+	p, ok := subCellPoint2d(qx, qy)
+	if !ok {
+		return 0, 0, false
+	}
+	return env.GridCoordOf(p)
+}
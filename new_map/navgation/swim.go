@@ -0,0 +1,127 @@
+package navgation
+
+import (
+	zmap3base "pathfinding/new_map"
+)
+
+// Locomotion is how the agent moves onto a waypoint.
+type Locomotion uint8
+
+const (
+	LocoWalk Locomotion = iota
+	LocoWade            // standing on a bed under shallow water
+	LocoSwim            // floating on a water surface
+	LocoFly             // flying through free air, see FlyPlanner
+)
+
+func (l Locomotion) String() string {
+	switch l {
+	case LocoWalk:
+		return "walk"
+	case LocoWade:
+		return "wade"
+	case LocoSwim:
+		return "swim"
+	case LocoFly:
+		return "fly"
+	}
+	return "unknown"
+}
+
+const waterTextures = zmap3base.TexturePropWater | zmap3base.TexturePropWaterDeep
+
+// WithSwimming returns f for an amphibious agent. Water spans
+// (TexturePropWater or TexturePropWaterDeep) become volumes: one no deeper
+// than wadeDepth (1/20m) and not marked deep is waded, the agent standing on
+// the bed below it; deeper water is swum at its surface. Water bits are
+// removed from the forbidden textures so both are allowed, and shorelines
+// are crossed by ordinary steps within the up/down limits.
+//
+// All planners honour the mode through the filter; waypoints report it in
+// Waypoint.Mode.
+func (f Filter) WithSwimming(wadeDepth int32) Filter {
+	f.swim = true
+	f.wadeDepth = wadeDepth
+	f.forbiddenTexture &^= uint32(waterTextures)
+	return f
+}
+
+// Swimming reports whether f was made by WithSwimming.
+func (f Filter) Swimming() bool { return f.swim }
+
+// wadeable reports whether span is shallow water the agent walks through.
+func (f Filter) wadeable(span zmap3base.RichRange) bool {
+	tex := span.Accessory.Texture
+	return tex&waterTextures != 0 && tex&zmap3base.TexturePropWaterDeep == 0 &&
+		int32(span.End)-int32(span.Begin) <= f.wadeDepth
+}
+
+// dropWadeable removes the wadeable spans in place.
+func (f Filter) dropWadeable(spans []zmap3base.RichRange) []zmap3base.RichRange {
+	n := 0
+	for _, s := range spans {
+		if !f.wadeable(s) {
+			spans[n] = s
+			n++
+		}
+	}
+	return spans[:n]
+}
+
+// intervalSwim is Interval for swimming filters.
+func (f Filter) intervalSwim(env *zmap3base.Env, p2d zmap3base.Point2d, curY int32) (res zmap3base.SnapRichRange, ok bool) {
+	if env == nil {
+		return res, false
+	}
+	rc, ok := env.Route(p2d)
+	if !ok {
+		return res, false
+	}
+	buf := fastRichRangeSlicePool.Get().(*[]zmap3base.RichRange)
+	terrain, spans, ok := collectRoutedSpans(rc, (*buf)[:0])
+	if ok {
+		spans = f.dropWadeable(spans)
+		sortSpansByEndBegin(spans)
+		res, ok = getIntervalFromTerrainAndSpans(terrain, spans, curY,
+			f.ignoreTexture, f.forbiddenTexture, f.height, f.upLimit, f.downLimit)
+	}
+	recycleFastSpanBuf(buf, spans)
+	return res, ok
+}
+
+// locomotionAt classifies the stand st of the footprint at (qx, qy).
+func locomotionAt(env *zmap3base.Env, qx, qy int32, st stand, f Filter) Locomotion {
+	if !f.swim {
+		return LocoWalk
+	}
+	if st.tex&waterTextures != 0 {
+		return LocoSwim
+	}
+	n := f.footprint()
+	for dx := int32(0); dx < n; dx++ {
+		for dy := int32(0); dy < n; dy++ {
+			p2d, ok := subCellPoint2d(qx+dx, qy+dy)
+			if !ok {
+				continue
+			}
+			rc, ok := env.Route(p2d)
+			if !ok {
+				continue
+			}
+			buf := fastRichRangeSlicePool.Get().(*[]zmap3base.RichRange)
+			_, spans, _ := collectRoutedSpans(rc, (*buf)[:0])
+			wet := false
+			for _, s := range spans {
+				if f.wadeable(s) && s.Begin <= st.h && st.h < s.End {
+					wet = true
+					break
+				}
+			}
+			recycleFastSpanBuf(buf, spans)
+			if wet {
+				return LocoWade
+			}
+		}
+	}
+	return LocoWalk
+}
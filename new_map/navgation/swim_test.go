@@ -0,0 +1,93 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+// lakeCells returns 2m ground split by a lake along x=12..19: shallow banks
+// (0.3m of water over the bed) around a deep middle.
+func lakeCells() map[int]cellFixture {
+	cells := flatCells(40)
+	for y := 0; y < zmap3base.FastGridSetSize; y++ {
+		for x := 12; x < 20; x++ {
+			if x < 14 || x >= 18 {
+				cells[cellIndex(x, y)] = cellFixture{
+					terrain:   rr(0, 34, testTexBase),
+					lpPayload: []zmap3base.RichRange{rr(34, 40, testTexWater)},
+				}
+			} else {
+				cells[cellIndex(x, y)] = cellFixture{
+					terrain:   rr(0, 1, testTexBase),
+					lpPayload: []zmap3base.RichRange{rr(1, 40, testTexWater|zmap3base.TexturePropWaterDeep)},
+				}
+			}
+		}
+	}
+	return cells
+}
+
+func TestSwimming(t *testing.T) {
+	env := buildSingleGridEnv(t, lakeCells())
+	walker := NewFilter(0, uint32(waterTextures), 22, 22, 22)
+	swimmer := walker.WithSwimming(10)
+	if walker.Swimming() || !swimmer.Swimming() || swimmer.ForbiddenTexture() != 0 {
+		t.Fatalf("WithSwimming did not set up the filter")
+	}
+
+	west, east := subPoint(8, 60, 40), subPoint(100, 60, 40)
+	if _, ok := NewPlanner(env, walker).FindPath(west, east); ok {
+		t.Fatalf("walker crossed the lake")
+	}
+
+	pl := NewPlanner(env, swimmer)
+	res, ok := pl.FindPath(west, east)
+	if !ok {
+		t.Fatalf("swimmer found no path")
+	}
+	checkPathMoves(t, env, res.Waypoints, swimmer)
+
+	// Modes along the path: walk, wade, swim, wade, walk.
+	var modes []Locomotion
+	for _, w := range res.Waypoints {
+		if len(modes) == 0 || modes[len(modes)-1] != w.Mode {
+			modes = append(modes, w.Mode)
+		}
+		switch w.Mode {
+		case LocoWade:
+			if w.H != 34 {
+				t.Fatalf("wading at %d, want the bed at 34", w.H)
+			}
+		case LocoSwim:
+			if w.H != 40 || w.Texture&waterTextures == 0 {
+				t.Fatalf("swimming at %d tex %x, want the surface at 40", w.H, w.Texture)
+			}
+		}
+	}
+	want := []Locomotion{LocoWalk, LocoWade, LocoSwim, LocoWade, LocoWalk}
+	if len(modes) != len(want) {
+		t.Fatalf("modes %v, want %v", modes, want)
+	}
+	for i := range want {
+		if modes[i] != want[i] {
+			t.Fatalf("modes %v, want %v", modes, want)
+		}
+	}
+
+	smoothed := SmoothPath(env, res.Waypoints, swimmer)
+	if len(smoothed) >= len(res.Waypoints) || smoothed[len(smoothed)-1] != res.Waypoints[len(res.Waypoints)-1] {
+		t.Fatalf("smoothing did not shorten the path")
+	}
+
+	// Swimming costs more than walking, so the optimal cost grows by the
+	// extra factor on the swum and waded sub-cells.
+	pl.Cost = &TextureCostModel{Wade: 2, Swim: 3}
+	priced, ok := pl.FindPath(west, east)
+	if !ok || priced.Cost <= res.Cost {
+		t.Fatalf("priced ok=%v cost=%v, plain %v", ok, priced.Cost, res.Cost)
+	}
+	if got := (&TextureCostModel{Swim: 3}).StepCost(StepInfo{Dist: 1, Mode: LocoSwim}); got != 3 {
+		t.Fatalf("swim step cost %v", got)
+	}
+}
@@ -0,0 +1,193 @@
+package navgation
+
+import (
+	zmap3base "pathfinding/new_map"
+)
+
+// WalkReason tells why WalkableLine stopped.
+type WalkReason uint8
+
+const (
+	WalkOK WalkReason = iota
+	// WalkOutOfMap the line leaves the Env or an unloaded grid.
+	WalkOutOfMap
+	// WalkStepUp the next floor is higher than upLimit allows.
+	WalkStepUp
+	// WalkStepDown the next floor is lower than downLimit allows.
+	WalkStepDown
+	// WalkHeadroom a floor is in reach but the gap above it is too low.
+	WalkHeadroom
+	// WalkForbidden the only reachable floor has a forbidden texture.
+	WalkForbidden
+	// WalkFloorMismatch the line reaches b on a different floor than b.H.
+	WalkFloorMismatch
+)
+
+func (r WalkReason) String() string {
+	switch r {
+	case WalkOK:
+		return "ok"
+	case WalkOutOfMap:
+		return "out-of-map"
+	case WalkStepUp:
+		return "step-up"
+	case WalkStepDown:
+		return "step-down"
+	case WalkHeadroom:
+		return "headroom"
+	case WalkForbidden:
+		return "forbidden"
+	case WalkFloorMismatch:
+		return "floor-mismatch"
+	}
+	return "unknown"
+}
+
+// WalkResult is the outcome of WalkableLine. On failure At is the first
+// sub-cell that could not be entered, with H set to the height the agent
+// arrived at.
+type WalkResult struct {
+	Reason WalkReason
+	At     zmap3base.Point3d
+	// Stand is the last footprint stand reached, i.e. the floor at b on success.
+	Stand zmap3base.Point3d
+}
+
+// WalkableLine traces the straight XZ line from a to b sub-cell by sub-cell
+// with the agent footprint, applying the same step-up, step-down, headroom and
+// forbidden-texture rules the planner uses. A diagonal crossing through a
+// sub-cell corner needs both orthogonal neighbours to be walkable.
+func WalkableLine(env *zmap3base.Env, a, b zmap3base.Point3d, f Filter) (res WalkResult, ok bool) {
+	if env == nil {
+		res.Reason = WalkOutOfMap
+		return res, false
+	}
+	ax, ay := subCellOf(a.Point2d())
+	bx, by := subCellOf(b.Point2d())
+	return walkLine(env, ax, ay, int32(a.H), bx, by, b.H, f)
+}
+
+func walkLine(env *zmap3base.Env, ax, ay, curY, bx, by int32, bh uint16, f Filter) (res WalkResult, ok bool) {
+	fail := func(qx, qy, y int32, reason WalkReason) (WalkResult, bool) {
+		p := point3dAt(qx, qy, zmap3base.Range{Begin: uint16(y)})
+		res.Reason = reason
+		res.At = p
+		return res, false
+	}
+
+	st, valid := footprintStand(env, ax, ay, curY, f)
+	if !valid {
+		return fail(ax, ay, curY, diagnoseStand(env, ax, ay, curY, f))
+	}
+	curY = int32(st.h)
+	res.Stand = point3dAt(ax, ay, st.gap())
+
+	dx, dy := bx-ax, by-ay
+	nx, ny := abs32(dx), abs32(dy)
+	sx, sy := sign32(dx), sign32(dy)
+
+	x, y := ax, ay
+	for ix, iy := int32(0), int32(0); ix < nx || iy < ny; {
+		// Compare (ix+0.5)/nx with (iy+0.5)/ny without division.
+		cmp := (2*ix+1)*ny - (2*iy+1)*nx
+		switch {
+		case cmp == 0:
+			// Exact corner: both orthogonal neighbours must pass.
+			if _, okX := footprintStand(env, x+sx, y, curY, f); !okX {
+				return fail(x+sx, y, curY, diagnoseStand(env, x+sx, y, curY, f))
+			}
+			if _, okY := footprintStand(env, x, y+sy, curY, f); !okY {
+				return fail(x, y+sy, curY, diagnoseStand(env, x, y+sy, curY, f))
+			}
+			x, y = x+sx, y+sy
+			ix++
+			iy++
+		case cmp < 0:
+			x += sx
+			ix++
+		default:
+			y += sy
+			iy++
+		}
+
+		st, valid = footprintStand(env, x, y, curY, f)
+		if !valid {
+			return fail(x, y, curY, diagnoseStand(env, x, y, curY, f))
+		}
+		curY = int32(st.h)
+		res.Stand = point3dAt(x, y, st.gap())
+	}
+
+	if st.h != bh {
+		return fail(bx, by, curY, WalkFloorMismatch)
+	}
+	res.Reason = WalkOK
+	return res, true
+}
+
+// diagnoseStand explains why footprintStand failed at (qx, qy).
+func diagnoseStand(env *zmap3base.Env, qx, qy, curY int32, f Filter) WalkReason {
+	n := f.footprint()
+	for dx := int32(0); dx < n; dx++ {
+		for dy := int32(0); dy < n; dy++ {
+			p2d, ok := subCellPoint2d(qx+dx, qy+dy)
+			if !ok || !env.Validate2d(p2d) {
+				return WalkOutOfMap
+			}
+			if _, ok := f.Interval(env, p2d, curY); ok {
+				continue
+			}
+			return diagnoseColumn(env, p2d, curY, f)
+		}
+	}
+	// Every column has a floor in reach, but their common gap is too low.
+	return WalkHeadroom
+}
+
+// diagnoseColumn explains why GetInterval found nothing for one sub-column.
+func diagnoseColumn(env *zmap3base.Env, p2d zmap3base.Point2d, curY int32, f Filter) WalkReason {
+	minAllowed, maxAllowed := curY-f.downLimit, curY+f.upLimit
+	forbidden := zmap3base.Texture(f.forbiddenTexture)
+
+	var above, below, short, banned bool
+	loose := Filter{ignoreTexture: f.ignoreTexture}
+	routed := ForEachInterval(env, p2d, loose, func(g Gap) bool {
+		floor := int32(g.Begin)
+		switch {
+		case floor > maxAllowed:
+			above = true
+			return false
+		case floor < minAllowed:
+			below = true
+		case int32(g.Len()) < f.height || int32(g.End) < curY+f.height:
+			short = true
+		case forbidden != 0 && (g.Accessory.Texture&forbidden) != 0:
+			banned = true
+		}
+		return true
+	})
+
+	switch {
+	case !routed:
+		return WalkOutOfMap
+	case banned:
+		return WalkForbidden
+	case short:
+		return WalkHeadroom
+	case above:
+		return WalkStepUp
+	case below:
+		return WalkStepDown
+	}
+	return WalkHeadroom
+}
+
+func sign32(v int32) int32 {
+	switch {
+	case v > 0:
+		return 1
+	case v < 0:
+		return -1
+	}
+	return 0
+}
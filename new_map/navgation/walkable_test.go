@@ -0,0 +1,70 @@
+package navgation
+
+import (
+	"testing"
+
+	zmap3base "pathfinding/new_map"
+)
+
+func TestWalkableLine(t *testing.T) {
+	cells := flatCells(10)
+	// wall
+	cells[cellIndex(10, 2)] = cellFixture{terrain: rr(0, 60, testTexBase)}
+	// pit
+	cells[cellIndex(10, 6)] = cellFixture{terrain: rr(0, 1, testTexBase)}
+	// water
+	cells[cellIndex(10, 10)] = cellFixture{terrain: rr(0, 10, testTexBase|testTexWater)}
+	// small step
+	cells[cellIndex(10, 14)] = cellFixture{terrain: rr(0, 14, testTexBase)}
+	// low beam
+	cells[cellIndex(10, 18)] = cellFixture{terrain: rr(0, 10, testTexBase), lpPayload: []zmap3base.RichRange{rr(20, 40, testTexObs)}}
+	env := buildSingleGridEnv(t, cells)
+
+	f := NewFilter(0, uint32(testTexWater), 22, 10, 5)
+
+	cases := []struct {
+		name   string
+		row    int32
+		endH   uint16
+		reason WalkReason
+		failX  int32
+	}{
+		{"wall", 2, 10, WalkStepUp, 40},
+		{"pit", 6, 10, WalkStepDown, 40},
+		{"water", 10, 10, WalkForbidden, 40},
+		{"beam", 18, 10, WalkHeadroom, 40},
+		{"step-ok", 14, 10, WalkOK, 0},
+		{"floor-mismatch", 14, 14, WalkFloorMismatch, 60},
+	}
+	for _, c := range cases {
+		t.Run(c.name, func(t *testing.T) {
+			qy := c.row*4 + 1
+			// The footprint reference is bottom-left, so x=39 already overlaps x=40.
+			res, ok := WalkableLine(env, subPoint(24, qy, 10), subPoint(60, qy, c.endH), f)
+			if ok != (c.reason == WalkOK) || res.Reason != c.reason {
+				t.Fatalf("got ok=%v reason=%v at=%+v", ok, res.Reason, res.At)
+			}
+			if ok {
+				if res.Stand.H != 10 {
+					t.Fatalf("unexpected final stand: %+v", res.Stand)
+				}
+				return
+			}
+			qx, _ := subCellOf(res.At.Point2d())
+			if c.reason != WalkFloorMismatch {
+				qx++ // footprint overlap
+			}
+			if qx != c.failX {
+				t.Fatalf("failed at x=%d want=%d (%+v)", qx, c.failX, res.At)
+			}
+		})
+	}
+
+	t.Run("diagonal-corner", func(t *testing.T) {
+		// The wall row blocks one orthogonal neighbour of a 45 degree crossing.
+		res, ok := WalkableLine(env, subPoint(36, 4, 10), subPoint(44, 12, 10), f)
+		if ok || res.Reason != WalkStepUp {
+			t.Fatalf("expected the diagonal to be blocked, got ok=%v %v", ok, res.Reason)
+		}
+	})
+}
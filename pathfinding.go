@@ -3,6 +3,9 @@ package main
 
 import (
 	"container/heap"
+	"math"
+
+	"pathfinding/navtrace"
 )
 
 type Dir int8
@@ -37,11 +40,12 @@ type openHeap []*node
 func (h openHeap) Len() int            { return len(h) }
 func (h openHeap) Less(i, j int) bool  { return h[i].f < h[j].f }
 func (h openHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i]; h[i].openIdx, h[j].openIdx = i, j }
-func (h *openHeap) Push(x interface{}) { *h = append(*h, x.(*node)) }
+func (h *openHeap) Push(x interface{}) { n := x.(*node); n.openIdx = len(*h); *h = append(*h, n) }
 func (h *openHeap) Pop() interface{} {
 	old := *h
 	n := len(old)
 	x := old[n-1]
+	x.openIdx = -1 // 已出堆，再次松弛时重新入堆
 	*h = old[:n-1]
 	return x
 }
@@ -67,30 +71,124 @@ type Pathfinder struct {
 	W  *World
 	AG AgentSpec
 	ec *EdgeCache
+	// Tracer 非空时记录每次 FindPath 的搜索事件；坐标为宏格（1m），高度为 1/20m，
+	// 对应 navtrace.New(1, HeightScale)
+	Tracer navtrace.Tracer
+	// MaxExpand 限制单次寻路扩展的节点数，0 表示不限
+	MaxExpand int
+	// Partial 非 PartialOff 时，寻路失败（终点不可达或扩展数用尽）返回通往
+	// 最接近终点的已扩展节点的路径，而不是 nil
+	Partial PartialMode
+	// Cost 非空时为每一步计价（材质、爬升等），启发式按其 MinFactor 缩放；
+	// 为空时每步代价为 1 或 √2
+	Cost CostModel
+}
+
+// PartialMode 选择失败时“最接近终点”的度量
+type PartialMode uint8
+
+const (
+	PartialOff       PartialMode = iota
+	PartialHeuristic             // 启发值（octile 距离）最小
+	PartialDistance              // 与终点的 3D 直线距离最小（高度换算为米）
+)
+
+// FindStatus 是 FindPathStatus 的结果状态
+type FindStatus uint8
+
+const (
+	FindOK              FindStatus = iota
+	FindUnreachable                // 开放表耗尽仍未到达终点
+	FindBudgetExhausted            // 扩展数达到 MaxExpand
+	FindStartInvalid               // 设置了 Partial 时：起点宏格在起始高度附近无可站立面
+)
+
+func (s FindStatus) String() string {
+	switch s {
+	case FindOK:
+		return "ok"
+	case FindUnreachable:
+		return "unreachable"
+	case FindBudgetExhausted:
+		return "budget-exhausted"
+	case FindStartInvalid:
+		return "start-invalid"
+	}
+	return "unknown"
+}
+
+// trace 在设置了 Tracer 时记录一个事件
+func (pf *Pathfinder) trace(k navtrace.Kind, r navtrace.Reason, x, z int32, h20 uint16, g, f float32) {
+	if pf.Tracer == nil {
+		return
+	}
+	pf.Tracer.Record(navtrace.Event{Kind: k, Reason: r, At: navtrace.Coord{X: x, Y: int32(h20), Z: z}, G: g, F: f})
 }
 
 func NewPathfinder(w *World, ag AgentSpec) *Pathfinder {
 	return &Pathfinder{W: w, AG: ag, ec: newEdgeCache()}
 }
 
-// FindPath 在宏格层面寻路（起终点用宏格坐标+起始高度）
+// FindPath 在宏格层面寻路（起终点用宏格坐标+起始高度）。
+// 设置了 Partial 时失败也可能返回部分路径（ok 仍为 false），终点高度按起始高度计；
+// 需要状态码或终点高度时用 FindPathStatus
 func (pf *Pathfinder) FindPath(sx, sz int32, sh20 uint16, gx, gz int32) ([][3]float32, bool) {
+	path, st := pf.FindPathStatus(sx, sz, sh20, gx, gz, sh20)
+	return path, st == FindOK
+}
+
+// FindPathStatus 同 FindPath，并返回结果状态。gh20 为终点高度，仅用于
+// PartialDistance 的距离计算。设置了 Partial 时起点须有可站立面，否则返回
+// FindStartInvalid 且路径为 nil；未设置时与 FindPath 一样照常从起点搜索
+func (pf *Pathfinder) FindPathStatus(sx, sz int32, sh20 uint16, gx, gz int32, gh20 uint16) ([][3]float32, FindStatus) {
 	start := &node{x: sx, z: sz, h20: sh20, g: 0}
 	start.f = pf.h(sx, sz, gx, gz)
+	pf.trace(navtrace.Start, 0, sx, sz, sh20, 0, start.f)
+	pf.trace(navtrace.Goal, 0, gx, gz, gh20, 0, 0)
+	if _, ok := pf.standAt(sx, sz, sh20); !ok && pf.Partial != PartialOff {
+		pf.trace(navtrace.Failed, 0, gx, gz, gh20, 0, 0)
+		return nil, FindStartInvalid
+	}
 	open := &openHeap{start}
 	heap.Init(open)
 	vis := make(map[int64]*node) // key = (x<<32)|uint32(z)
 	vis[keyOf(sx, sz)] = start
+	pf.trace(navtrace.Push, 0, sx, sz, sh20, 0, start.f)
 
+	// closest 为按 Partial 度量最接近终点的已扩展节点
+	var closest *node
+	closestD := float32(math.MaxFloat32)
+	status := FindUnreachable
+	expanded := 0
 	for open.Len() > 0 {
+		if pf.MaxExpand > 0 && expanded >= pf.MaxExpand {
+			status = FindBudgetExhausted
+			break
+		}
 		cur := heap.Pop(open).(*node)
+		pf.trace(navtrace.Pop, 0, cur.x, cur.z, cur.h20, cur.g, cur.f)
 		if cur.x == gx && cur.z == gz {
-			return pf.reconstruct(cur)
+			path, _ := pf.reconstruct(cur)
+			if pf.Tracer != nil {
+				pf.trace(navtrace.Found, 0, cur.x, cur.z, cur.h20, cur.g, cur.g)
+				for _, n := range chainOf(cur) {
+					pf.trace(navtrace.Path, 0, n.x, n.z, n.h20, n.g, n.f)
+				}
+			}
+			return path, FindOK
 		}
+		expanded++
+		if pf.Partial != PartialOff {
+			if d := pf.goalDist(cur, gx, gz, gh20); d < closestD || d == closestD && cur.g < closest.g {
+				closest, closestD = cur, d
+			}
+		}
+		pf.trace(navtrace.Expand, 0, cur.x, cur.z, cur.h20, cur.g, cur.f)
 		for _, d := range []Dir{E, W, N, S, NE, NW, SE, SW} {
 			nx, nz := step(cur.x, cur.z, d)
 			nh, ok := pf.edgePass(cur.x, cur.z, cur.h20, d)
 			if !ok {
+				pf.trace(navtrace.Reject, navtrace.Blocked, nx, nz, cur.h20, cur.g, cur.f)
 				continue
 			}
 			// 反剪角（对角需要两正交边可行）
@@ -98,10 +196,11 @@ func (pf *Pathfinder) FindPath(sx, sz int32, sh20 uint16, gx, gz int32) ([][3]fl
 				_, ok1 := pf.edgePass(cur.x, cur.z, cur.h20, toOrthA(d))
 				_, ok2 := pf.edgePass(cur.x, cur.z, cur.h20, toOrthB(d))
 				if !ok1 || !ok2 {
+					pf.trace(navtrace.Reject, navtrace.CornerCut, nx, nz, nh, cur.g, cur.f)
 					continue
 				}
 			}
-			ng := cur.g + moveCost(d)
+			ng := cur.g + pf.moveCost(d, cur.h20, nx, nz, nh)
 			key := keyOf(nx, nz)
 			if old, ok := vis[key]; ok {
 				if ng < old.g {
@@ -114,6 +213,9 @@ func (pf *Pathfinder) FindPath(sx, sz int32, sh20 uint16, gx, gz int32) ([][3]fl
 					} else {
 						heap.Push(open, old)
 					}
+					pf.trace(navtrace.Push, 0, nx, nz, nh, old.g, old.f)
+				} else {
+					pf.trace(navtrace.Reject, navtrace.Worse, nx, nz, nh, ng, ng+pf.h(nx, nz, gx, gz))
 				}
 			} else {
 				nn := &node{x: nx, z: nz, h20: nh, g: ng}
@@ -122,10 +224,27 @@ func (pf *Pathfinder) FindPath(sx, sz int32, sh20 uint16, gx, gz int32) ([][3]fl
 				nn.openIdx = -1
 				vis[key] = nn
 				heap.Push(open, nn)
+				pf.trace(navtrace.Push, 0, nx, nz, nh, nn.g, nn.f)
 			}
 		}
 	}
-	return nil, false
+	pf.trace(navtrace.Failed, 0, gx, gz, gh20, 0, 0)
+	if closest == nil {
+		return nil, status
+	}
+	path, _ := pf.reconstruct(closest)
+	return path, status
+}
+
+// goalDist 按 Partial 度量节点 n 到终点的距离
+func (pf *Pathfinder) goalDist(n *node, gx, gz int32, gh20 uint16) float32 {
+	if pf.Partial == PartialHeuristic {
+		return pf.h(n.x, n.z, gx, gz)
+	}
+	dx := float64(gx - n.x)
+	dz := float64(gz - n.z)
+	dy := (float64(gh20) - float64(n.h20)) / HeightScale
+	return float32(math.Sqrt(dx*dx + dy*dy + dz*dz))
 }
 
 func (pf *Pathfinder) h(x, z, gx, gz int32) float32 {
@@ -136,17 +255,11 @@ func (pf *Pathfinder) h(x, z, gx, gz int32) float32 {
 	if minv > maxv {
 		minv, maxv = maxv, minv
 	}
-	return (maxv - minv) + minv*sqrt2
+	return ((maxv - minv) + minv*sqrt2) * pf.hscale()
 }
 
 const sqrt2 = 1.41421356
 
-func moveCost(d Dir) float32 {
-	if isDiag(d) {
-		return sqrt2
-	}
-	return 1
-}
 func isDiag(d Dir) bool { return d >= NE }
 
 func toOrthA(d Dir) Dir { // NE->E, NW->W, SE->E, SW->W
@@ -226,6 +339,14 @@ func (pf *Pathfinder) edgePass(x, z int32, h20 uint16, d Dir) (uint16, bool) {
 	case SW:
 		tx, tz = x-1, z+1
 	}
+	newH, ok := pf.standAt(tx, tz, h20)
+	pf.ec.m[key] = edgeCacheVal{ok: ok, nh: newH}
+	return newH, ok
+}
+
+// standAt 判定宏格 (tx,tz) 中心四子格相对高度 h20 是否都有可站立面；
+// 可站立时返回站立高度（四子格 End 的最大值）。
+func (pf *Pathfinder) standAt(tx, tz int32, h20 uint16) (uint16, bool) {
 	txq := (tx << 2) + 1
 	tzq := (tz << 2) + 1
 
@@ -240,24 +361,33 @@ func (pf *Pathfinder) edgePass(x, z int32, h20 uint16, d Dir) (uint16, bool) {
 	for i := 0; i < 4; i++ {
 		colID, ok := pf.W.columnAtQuarter(target[i][0], target[i][1])
 		if !ok {
-			pf.ec.m[key] = edgeCacheVal{ok: false}
 			return 0, false
 		}
 		col := pf.W.cols.Get(colID)
 		// 在该子格柱列上，寻找相对当前高度 h20 的最佳上表面 a
 		top, ok := col.findBestSupport(h20, pf.AG.IgnoreMask)
 		if !ok {
-			pf.ec.m[key] = edgeCacheVal{ok: false}
 			return 0, false
 		}
 		if top > newH {
 			newH = top
 		}
 	}
-	pf.ec.m[key] = edgeCacheVal{ok: true, nh: newH}
 	return newH, true
 }
 
+// chainOf 返回从起点到 goal 的节点链
+func chainOf(goal *node) []*node {
+	var rev []*node
+	for n := goal; n != nil; n = n.parent {
+		rev = append(rev, n)
+	}
+	for i, j := 0, len(rev)-1; i < j; i, j = i+1, j-1 {
+		rev[i], rev[j] = rev[j], rev[i]
+	}
+	return rev
+}
+
 // reconstruct 将宏路径还原为世界坐标（以 1×1 宏格中心为 xz，y=End/20）
 func (pf *Pathfinder) reconstruct(goal *node) ([][3]float32, bool) {
 	var rev [][3]float32
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"testing"
+
+	"pathfinding/navtrace"
+)
+
+func TestFindPathStatus_Partial(t *testing.T) {
+	// (7,3) 为墙，终点不可达；东侧 (8,3) 为高 0.9m 的台子
+	wd := buildSmoothWorld(10, 7, func(x, z int32) uint16 {
+		switch {
+		case x == 7 && z == 3:
+			return 0
+		case x == 8 && z == 3:
+			return 28
+		}
+		return 10
+	})
+	end := func(path [][3]float32) [3]float32 { return path[len(path)-1] }
+
+	pf := NewPathfinder(wd, AgentSpec{})
+	if path, st := pf.FindPathStatus(0, 3, 10, 7, 3, 10); path != nil || st != FindUnreachable {
+		t.Fatalf("without Partial: %v %v", path, st)
+	}
+
+	pf = NewPathfinder(wd, AgentSpec{})
+	pf.Partial = PartialHeuristic
+	path, ok := pf.FindPath(0, 3, 10, 7, 3)
+	if ok || len(path) == 0 || path[0] != [3]float32{0.5, 0.5, 3.5} || end(path) != [3]float32{6.5, 0.5, 3.5} {
+		t.Fatalf("PartialHeuristic: ok=%v %v", ok, path)
+	}
+	checkSmoothSegments(t, pf, path)
+
+	// 按 3D 距离，终点高度 1.4m 时台子最近
+	pf = NewPathfinder(wd, AgentSpec{})
+	pf.Partial = PartialDistance
+	path, st := pf.FindPathStatus(0, 3, 10, 7, 3, 28)
+	if st != FindUnreachable || len(path) == 0 || end(path) != [3]float32{8.5, 1.4, 3.5} {
+		t.Fatalf("PartialDistance: %v %v", st, path)
+	}
+	if path, _ := pf.FindPathStatus(0, 3, 10, 7, 3, 10); end(path) != [3]float32{6.5, 0.5, 3.5} {
+		t.Fatalf("PartialDistance at 0.5m: %v", path)
+	}
+
+	// 扩展数用尽
+	pf = NewPathfinder(wd, AgentSpec{})
+	pf.MaxExpand = 3
+	if path, st := pf.FindPathStatus(0, 3, 10, 9, 3, 10); path != nil || st != FindBudgetExhausted {
+		t.Fatalf("budget without Partial: %v %v", path, st)
+	}
+	pf.Partial = PartialHeuristic
+	path, st = pf.FindPathStatus(0, 3, 10, 9, 3, 10)
+	if st != FindBudgetExhausted || len(path) < 2 || path[0] != [3]float32{0.5, 0.5, 3.5} || end(path)[0] <= 0.5 {
+		t.Fatalf("budget with Partial: %v %v", st, path)
+	}
+	if full, st := NewPathfinder(wd, AgentSpec{}).FindPathStatus(0, 3, 10, 9, 3, 10); st != FindOK || len(full) == 0 {
+		t.Fatalf("unlimited search: %v", st)
+	}
+
+	if path, st := pf.FindPathStatus(7, 3, 10, 0, 3, 10); path != nil || st != FindStartInvalid {
+		t.Fatalf("start in the wall: %v %v", path, st)
+	}
+	// 未设置 Partial 时照旧从无站立面的起点搜索
+	pf = NewPathfinder(wd, AgentSpec{})
+	if path, ok := pf.FindPath(7, 3, 10, 7, 3); !ok || len(path) != 1 || path[0] != [3]float32{7.5, 0.5, 3.5} {
+		t.Fatalf("start == goal in the wall: %v %v", ok, path)
+	}
+	if path, st := pf.FindPathStatus(7, 3, 10, 0, 3, 10); st != FindOK || len(path) != 8 || end(path) != [3]float32{0.5, 0.5, 3.5} {
+		t.Fatalf("from the wall without Partial: %v %v", st, path)
+	}
+	for st, want := range map[FindStatus]string{FindOK: "ok", FindUnreachable: "unreachable", FindBudgetExhausted: "budget-exhausted", FindStartInvalid: "start-invalid"} {
+		if st.String() != want {
+			t.Fatalf("%d.String() = %q, want %q", st, st.String(), want)
+		}
+	}
+}
+
+// checkTracePath 检查轨迹以 Found/Failed 结束，且 Path 事件与返回的路径一致
+func checkTracePath(t *testing.T, tr *navtrace.Trace, path [][3]float32, ok bool) {
+	t.Helper()
+	last := tr.Events[len(tr.Events)-1].Kind
+	if ok && last != navtrace.Path && last != navtrace.Found || !ok && last != navtrace.Failed {
+		t.Fatalf("ok=%v, trace ends with %v", ok, last)
+	}
+	found, cost, nodes := tr.Result()
+	if found != ok {
+		t.Fatalf("trace found=%v, search ok=%v", found, ok)
+	}
+	if !ok {
+		return
+	}
+	if len(nodes) != len(path) || cost != tr.Events[len(tr.Events)-1].G {
+		t.Fatalf("%d path events (cost %v), path %v", len(nodes), cost, path)
+	}
+	for i, c := range nodes {
+		p := [3]float32{float32(c.X) + 0.5, float32(c.Y) / HeightScale, float32(c.Z) + 0.5}
+		if p != path[i] {
+			t.Fatalf("path event %d at %v, path point %v", i, p, path[i])
+		}
+	}
+}
+
+func TestFindPath_Trace(t *testing.T) {
+	wd := buildSmoothWorld(10, 7, func(x, z int32) uint16 {
+		if x == 5 && z > 0 {
+			return 0
+		}
+		return 10
+	})
+	tr := navtrace.New(1, HeightScale)
+	pf := NewPathfinder(wd, AgentSpec{})
+	pf.Tracer = tr
+	path, ok := pf.FindPath(0, 5, 10, 9, 5)
+	if !ok {
+		t.Fatalf("no path")
+	}
+	checkTracePath(t, tr, path, ok)
+	if k := tr.Events[0].Kind; k != navtrace.Start || tr.Events[1].Kind != navtrace.Goal {
+		t.Fatalf("trace starts with %v", k)
+	}
+
+	// Expand 事件数即扩展数：预算在取出节点前检查，取出终点还要多一个
+	n := tr.Count(navtrace.Expand)
+	pf.MaxExpand = n + 1
+	tr.Reset()
+	if again, st := pf.FindPathStatus(0, 5, 10, 9, 5, 10); st != FindOK || len(again) != len(path) || tr.Count(navtrace.Expand) != n {
+		t.Fatalf("MaxExpand=%d: %v, %d expands", n+1, st, tr.Count(navtrace.Expand))
+	}
+	pf.MaxExpand = n
+	tr.Reset()
+	path, st := pf.FindPathStatus(0, 5, 10, 9, 5, 10)
+	if st != FindBudgetExhausted || tr.Count(navtrace.Expand) != n {
+		t.Fatalf("MaxExpand=%d: %v, %d expands", n, st, tr.Count(navtrace.Expand))
+	}
+	checkTracePath(t, tr, path, false)
+
+	// 不可达：开放表耗尽
+	pf.MaxExpand = 0
+	tr.Reset()
+	path, ok = pf.FindPath(0, 5, 10, 5, 5)
+	checkTracePath(t, tr, path, ok)
+	if ok || tr.Count(navtrace.Expand) != tr.Count(navtrace.Pop) {
+		t.Fatalf("unreachable: ok=%v, %d expands, %d pops", ok, tr.Count(navtrace.Expand), tr.Count(navtrace.Pop))
+	}
+}
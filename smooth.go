@@ -0,0 +1,102 @@
+// ---------- smooth.go ----------
+package main
+
+// SmoothPath 对 FindPath 的阶梯状宏格路径做拉绳（string pulling）优化。
+// - 只在同一站立高度的连续段内删点，高度变化处（楼梯、跌落）的路点全部保留，y 保持原值；
+// - 删点前用 walkable 沿直线逐宏格复核 edgePass 规则；
+// - 首尾替换为调用方传入的真实浮点起终点，而不是宏格中心。
+func (pf *Pathfinder) SmoothPath(path [][3]float32, start, goal [3]float32) [][3]float32 {
+	n := len(path)
+	if n == 0 {
+		return nil
+	}
+	out := make([][3]float32, 0, n)
+	out = append(out, start)
+
+	anchor := 0
+	for i := 1; i < n-1; i++ {
+		if isHeightTransition(path, i) || !pf.walkable(path[anchor], path[i+1]) {
+			out = append(out, path[i])
+			anchor = i
+		}
+	}
+	return append(out, goal)
+}
+
+func isHeightTransition(path [][3]float32, i int) bool {
+	return path[i][1] != path[i-1][1] || path[i][1] != path[i+1][1]
+}
+
+// walkable 沿 a->b 的直线逐宏格走 edgePass，过角点的对角步需两条正交边都可行（与 FindPath 一致），
+// 最终站立高度必须等于 b 的高度。
+func (pf *Pathfinder) walkable(a, b [3]float32) bool {
+	x, z := int32(a[0]), int32(a[2])
+	bx, bz := int32(b[0]), int32(b[2])
+	h := uint16(a[1]*HeightScale + 0.5)
+	bh := uint16(b[1]*HeightScale + 0.5)
+
+	dx, dz := bx-x, bz-z
+	nx, nz := abs32(dx), abs32(dz)
+	sx, sz := sign32(dx), sign32(dz)
+
+	for ix, iz := int32(0), int32(0); ix < nx || iz < nz; {
+		cmp := (2*ix+1)*nz - (2*iz+1)*nx
+		var d Dir
+		switch {
+		case cmp == 0:
+			d = dirOf(sx, sz)
+			if _, ok := pf.edgePass(x, z, h, toOrthA(d)); !ok {
+				return false
+			}
+			if _, ok := pf.edgePass(x, z, h, toOrthB(d)); !ok {
+				return false
+			}
+			ix++
+			iz++
+		case cmp < 0:
+			d = dirOf(sx, 0)
+			ix++
+		default:
+			d = dirOf(0, sz)
+			iz++
+		}
+		nh, ok := pf.edgePass(x, z, h, d)
+		if !ok {
+			return false
+		}
+		x, z = step(x, z, d)
+		h = nh
+	}
+	return h == bh
+}
+
+// dirOf 把单位位移映射为 Dir（z 减小为 N，与 step 一致）。
+func dirOf(dx, dz int32) Dir {
+	switch {
+	case dx > 0 && dz < 0:
+		return NE
+	case dx < 0 && dz < 0:
+		return NW
+	case dx > 0 && dz > 0:
+		return SE
+	case dx < 0 && dz > 0:
+		return SW
+	case dx > 0:
+		return E
+	case dx < 0:
+		return W
+	case dz < 0:
+		return N
+	}
+	return S
+}
+
+func sign32(a int32) int32 {
+	if a < 0 {
+		return -1
+	}
+	if a > 0 {
+		return 1
+	}
+	return 0
+}
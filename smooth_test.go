@@ -0,0 +1,103 @@
+package main
+
+import "testing"
+
+// buildSmoothWorld 建一块 w×h 宏格的平地，floor(x,z) 给出每格地面 End，
+// 返回 0 的格子为 0..80 的实心墙。
+func buildSmoothWorld(w, h int32, floor func(x, z int32) uint16) *World {
+	wd := NewWorld()
+	for z := int32(0); z < h; z++ {
+		for x := int32(0); x < w; x++ {
+			end := floor(x, z)
+			if end == 0 {
+				end = 80
+			}
+			wd.SetUniform(x, z, &RichRangeSetData{raw: []RichRange{{Range: Range{Begin: 0, End: end}}}})
+		}
+	}
+	return wd
+}
+
+func checkSmoothSegments(t *testing.T, pf *Pathfinder, path [][3]float32) {
+	t.Helper()
+	for i := 1; i < len(path); i++ {
+		if path[i][1] != path[i-1][1] {
+			continue // 高度变化段是原路径上的相邻宏格
+		}
+		if !pf.walkable(path[i-1], path[i]) {
+			t.Fatalf("segment %d %v -> %v is not walkable", i, path[i-1], path[i])
+		}
+	}
+}
+
+func TestSmoothPath_CornerCut(t *testing.T) {
+	// x=3 一列在 z=0..2 为墙，只能从 (3,3) 绕过去
+	wd := buildSmoothWorld(8, 6, func(x, z int32) uint16 {
+		if x == 3 && z <= 2 {
+			return 0
+		}
+		return 10
+	})
+	pf := NewPathfinder(wd, AgentSpec{})
+
+	// 擦过墙角的对角步要求两条正交边都可行
+	if pf.walkable([3]float32{2.5, 0.5, 3.5}, [3]float32{3.5, 0.5, 2.5}) {
+		t.Fatalf("diagonal across the wall corner should be rejected")
+	}
+	if !pf.walkable([3]float32{2.5, 0.5, 3.5}, [3]float32{4.5, 0.5, 3.5}) {
+		t.Fatalf("straight line below the wall should be walkable")
+	}
+
+	path, ok := pf.FindPath(0, 0, 10, 6, 0)
+	if !ok {
+		t.Fatalf("no path")
+	}
+	start, goal := [3]float32{0.3, 0.5, 0.2}, [3]float32{6.7, 0.5, 0.4}
+	sm := pf.SmoothPath(path, start, goal)
+	if sm[0] != start || sm[len(sm)-1] != goal {
+		t.Fatalf("ends not replaced: %v", sm)
+	}
+	if len(sm) <= 2 || len(sm) >= len(path) {
+		t.Fatalf("smoothed %d points from %d: %v", len(sm), len(path), sm)
+	}
+	checkSmoothSegments(t, pf, sm[1:len(sm)-1])
+	for _, p := range sm[1 : len(sm)-1] {
+		if p[2] < 3 {
+			t.Fatalf("inner waypoint %v does not go around the wall", p)
+		}
+	}
+}
+
+func TestSmoothPath_HeightTransition(t *testing.T) {
+	// x>=4 为高 0.3m 的台子，可一步迈上
+	wd := buildSmoothWorld(8, 3, func(x, z int32) uint16 {
+		if x >= 4 {
+			return 16
+		}
+		return 10
+	})
+	pf := NewPathfinder(wd, AgentSpec{})
+
+	if !pf.walkable([3]float32{0.5, 0.5, 1.5}, [3]float32{7.5, 0.8, 1.5}) {
+		t.Fatalf("line onto the step should be walkable")
+	}
+	if pf.walkable([3]float32{0.5, 0.5, 1.5}, [3]float32{7.5, 0.5, 1.5}) {
+		t.Fatalf("line must end at the height of b")
+	}
+
+	path, ok := pf.FindPath(0, 1, 10, 7, 1)
+	if !ok {
+		t.Fatalf("no path")
+	}
+	start, goal := [3]float32{0.5, 0.5, 1.5}, [3]float32{7.5, 0.8, 1.5}
+	sm := pf.SmoothPath(path, start, goal)
+	want := [][3]float32{start, {3.5, 0.5, 1.5}, {4.5, 0.8, 1.5}, goal}
+	if len(sm) != len(want) {
+		t.Fatalf("smoothed path %v, want %v", sm, want)
+	}
+	for i := range want {
+		if sm[i] != want[i] {
+			t.Fatalf("smoothed path %v, want %v", sm, want)
+		}
+	}
+}